@@ -13,6 +13,7 @@ const (
 	RenderTypeTable   = "table"
 	RenderTypeFiles   = "files"
 	RenderTypeEcharts = "echarts"
+	RenderTypeStream  = "stream"
 )
 
 func build(resp *RunFunctionResp, data interface{}, renderType string) error {
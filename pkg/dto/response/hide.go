@@ -0,0 +1,60 @@
+package response
+
+import "encoding/json"
+
+// HideFields 从响应数据中删除指定的字段，表格删除对应的列，表单（对象）删除对应的key
+// keys 可以是字段的code或者json名称，用于按角色隐藏没有读权限的字段
+func (r *RunFunctionResp) HideFields(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	hidden := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		hidden[k] = true
+	}
+	r.Data = hideFields(r.Data, hidden)
+	for i, data := range r.DataList {
+		r.DataList[i] = hideFields(data, hidden)
+	}
+}
+
+func hideFields(data interface{}, hidden map[string]bool) interface{} {
+	switch d := data.(type) {
+	case nil:
+		return nil
+	case table:
+		columns := make([]column, 0, len(d.Column))
+		for _, col := range d.Column {
+			if hidden[col.Code] {
+				delete(d.Values, col.Code)
+				continue
+			}
+			columns = append(columns, col)
+		}
+		d.Column = columns
+		return d
+	case *table:
+		t := hideFields(*d, hidden).(table)
+		return &t
+	}
+	// 结构体等其他类型转换成对象后删除，不是对象的数据保持不变
+	marshal, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(marshal, &m); err != nil {
+		return data
+	}
+	removed := false
+	for k := range m {
+		if hidden[k] {
+			delete(m, k)
+			removed = true
+		}
+	}
+	if !removed {
+		return data
+	}
+	return m
+}
@@ -10,6 +10,9 @@ type RunFunctionResp struct {
 	Data       interface{}            `json:"data"`
 	DataList   []interface{}          `json:"data_list"`
 	Multiple   bool                   `json:"multiple"`
+
+	streamSink StreamSink
+	stream     *streamData
 }
 
 func (r *RunFunctionResp) GetData() interface{} {
@@ -37,6 +40,7 @@ type Builder interface {
 type Response interface {
 	Form(data interface{}) Form
 	Table(resultList interface{}, title ...string) Table
+	Stream() Stream
 }
 
 func (r *RunFunctionResp) Form(data interface{}) Form {
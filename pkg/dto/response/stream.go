@@ -0,0 +1,118 @@
+package response
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// 流式输出的分片类型
+const (
+	StreamChunkText = "text" // 文本增量，例如大模型逐字输出
+	StreamChunkRows = "rows" // 表格行
+	StreamChunkLog  = "log"  // 日志行
+)
+
+// StreamChunk 流式输出的一个分片，Seq从0开始递增，接收方按Seq排序
+type StreamChunk struct {
+	Seq  int         `json:"seq"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// StreamSink 分片的投递方式，由runner根据运行模式设置（NATS消息或者命令行的<Chunk>标签），没有设置时只做聚合
+type StreamSink func(chunk *StreamChunk) error
+
+// Stream 流式响应，边执行边输出分片，Build后把所有分片聚合成最终结果，不支持流式的调用方拿到的就是聚合结果
+//
+//	stream := resp.Stream()
+//	for delta := range deltas {
+//		if err := stream.Text(delta); err != nil {
+//			return err
+//		}
+//	}
+//	return stream.Build()
+type Stream interface {
+	Builder
+	Text(delta string) error
+	Rows(rows ...interface{}) error
+	Log(line string) error
+}
+
+// StreamResult 流式响应的聚合结果
+type StreamResult struct {
+	Text   string        `json:"text"`
+	Rows   []interface{} `json:"rows,omitempty"`
+	Logs   []string      `json:"logs,omitempty"`
+	Chunks int           `json:"chunks"` // 分片数量
+}
+
+type streamData struct {
+	lock   sync.Mutex
+	resp   *RunFunctionResp
+	text   strings.Builder
+	result StreamResult
+	built  bool
+}
+
+// SetStreamSink 设置分片的投递方式，需要在调用处理函数之前设置
+func (r *RunFunctionResp) SetStreamSink(sink StreamSink) {
+	r.streamSink = sink
+}
+
+// Stream 获取流式响应，多次调用返回同一个
+func (r *RunFunctionResp) Stream() Stream {
+	if r.stream == nil {
+		r.stream = &streamData{resp: r}
+	}
+	return r.stream
+}
+
+func (s *streamData) Text(delta string) error {
+	if delta == "" {
+		return nil
+	}
+	return s.write(StreamChunkText, delta, func() { s.text.WriteString(delta) })
+}
+
+func (s *streamData) Rows(rows ...interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return s.write(StreamChunkRows, rows, func() { s.result.Rows = append(s.result.Rows, rows...) })
+}
+
+func (s *streamData) Log(line string) error {
+	return s.write(StreamChunkLog, line, func() { s.result.Logs = append(s.result.Logs, line) })
+}
+
+// write 加锁保证多个协程同时写入时分片的序号和投递顺序一致
+func (s *streamData) write(typ string, data interface{}, aggregate func()) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.built {
+		return errors.New("stream已经Build，不能继续写入")
+	}
+	chunk := &StreamChunk{Seq: s.result.Chunks, Type: typ, Data: data}
+	if s.resp.streamSink != nil {
+		if err := s.resp.streamSink(chunk); err != nil {
+			return errors.Wrap(err, "推送分片失败")
+		}
+	}
+	s.result.Chunks++
+	aggregate()
+	return nil
+}
+
+func (s *streamData) Build() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.built {
+		return nil
+	}
+	s.built = true
+	result := s.result
+	result.Text = s.text.String()
+	return build(s.resp, &result, RenderTypeStream)
+}
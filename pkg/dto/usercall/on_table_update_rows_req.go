@@ -9,13 +9,19 @@ import (
 
 type OnTableUpdateRowsReq struct {
 	Ids    []int                  `json:"ids"`
-	Fields map[string]interface{} `json:"fields"` // 要更新的字段和值的映射
+	Keys   []interface{}          `json:"keys,omitempty"` // 主键值，用法和 OnTableDeleteRowsReq.Keys 一致
+	Fields map[string]interface{} `json:"fields"`         // 要更新的字段和值的映射
 }
 
 func (req *OnTableUpdateRowsReq) GetIds() []int {
 	return req.Ids
 }
 
+// GetKeys 获取要更新行的主键，没有传keys时使用ids
+func (req *OnTableUpdateRowsReq) GetKeys() []interface{} {
+	return rowKeys(req.Ids, req.Keys)
+}
+
 func (req *OnTableUpdateRowsReq) GetFieldsMap() map[string]interface{} {
 	return req.Fields
 }
@@ -64,10 +64,14 @@ type AfterApiDeletedReq struct {
 	Router string `json:"router"`
 }
 
+// BeforeRunnerCloseReq runner关闭前回调的参数，Reason是关闭原因：idle(空闲超时)、down(平台通知关闭)、nats_lost(连接断开)、exit(进程退出)
 type BeforeRunnerCloseReq struct {
+	Reason string `json:"reason"`
 }
 
+// AfterRunnerCloseReq runner关闭后回调的参数，此时NATS连接已经关闭
 type AfterRunnerCloseReq struct {
+	Reason string `json:"reason"`
 }
 
 type Change struct {
@@ -195,13 +199,44 @@ func (c *OnInputValidateReq) DecodeBy(el interface{}) error {
 }
 
 type OnTableDeleteRowsReq struct {
-	Ids []int `json:"ids"`
+	Ids  []int         `json:"ids"`
+	Keys []interface{} `json:"keys,omitempty"` // 主键值，字符串/UUID主键使用；联合主键时每个元素是 {列名:值}
 }
 
 func (c *OnTableDeleteRowsReq) GetIds() []int {
 	return c.Ids
 }
 
+// GetKeys 获取要删除行的主键，没有传keys时使用ids
+func (c *OnTableDeleteRowsReq) GetKeys() []interface{} {
+	return rowKeys(c.Ids, c.Keys)
+}
+
+// OnTableRestoreRowsReq 恢复软删除的数据，参数和删除一致
+type OnTableRestoreRowsReq struct {
+	Ids  []int         `json:"ids"`
+	Keys []interface{} `json:"keys,omitempty"`
+}
+
+func (c *OnTableRestoreRowsReq) GetKeys() []interface{} {
+	return rowKeys(c.Ids, c.Keys)
+}
+
+type OnTableRestoreRowsResp struct {
+	Restored int64 `json:"restored"` // 恢复的行数
+}
+
+func rowKeys(ids []int, keys []interface{}) []interface{} {
+	if len(keys) > 0 {
+		return keys
+	}
+	res := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, id)
+	}
+	return res
+}
+
 type OnTableAddRowsReq struct {
 	Rows interface{} `json:"rows"`
 }
@@ -267,6 +302,8 @@ type OnInputValidateResp struct {
 }
 
 type OnTableDeleteRowsResp struct {
+	Deleted int64 `json:"deleted"` // 删除的行数
+	Soft    bool  `json:"soft"`    // 是否是软删除，软删除的数据可以恢复
 }
 
 type OnTableUpdateRowsResp struct {
@@ -0,0 +1,276 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/pkg/logger"
+	"gorm.io/gorm"
+)
+
+type AsyncTaskStatus string
+
+const (
+	AsyncTaskStatusPending AsyncTaskStatus = "pending" //已提交，排队中
+	AsyncTaskStatusRunning AsyncTaskStatus = "running" //执行中
+	AsyncTaskStatusSuccess AsyncTaskStatus = "success" //执行成功
+	AsyncTaskStatusFailed  AsyncTaskStatus = "failed"  //执行失败
+)
+
+// AsyncTask 异步任务记录，保存在runner自己的SQLite数据库中，平台通过 /_task_status 和 /_task_result 轮询
+type AsyncTask struct {
+	TaskID      string          `json:"task_id" gorm:"primaryKey;size:64"`
+	TraceID     string          `json:"trace_id" gorm:"index;size:64"`
+	Router      string          `json:"router"`
+	Method      string          `json:"method" gorm:"size:16"`
+	Status      AsyncTaskStatus `json:"status" gorm:"index;size:16"`
+	Progress    int             `json:"progress"`     //进度 0-100
+	ProgressMsg string          `json:"progress_msg"` //进度描述，例如：正在解析第3个sheet
+	Error       string          `json:"error"`
+	Result      string          `json:"-"` //最终的RunFunctionResp（json）
+	CreatedAt   int64           `json:"created_at" gorm:"autoCreateTime:milli"`
+	StartedAt   int64           `json:"started_at"`
+	FinishedAt  int64           `json:"finished_at"`
+}
+
+func (AsyncTask) TableName() string {
+	return "_async_task"
+}
+
+// IsFinished 任务是否已经结束（成功或失败）
+func (t *AsyncTask) IsFinished() bool {
+	return t.Status == AsyncTaskStatusSuccess || t.Status == AsyncTaskStatusFailed
+}
+
+// AsyncTaskReq /_task_status 和 /_task_result 的请求参数
+type AsyncTaskReq struct {
+	TaskID string `json:"task_id" form:"task_id"`
+}
+
+// AsyncTaskResult /_task_result 的响应
+type AsyncTaskResult struct {
+	TaskID string                    `json:"task_id"`
+	Status AsyncTaskStatus           `json:"status"`
+	Result *response.RunFunctionResp `json:"result"`
+}
+
+type asyncJob struct {
+	ctx    *Context
+	router *routerInfo
+	req    *request.RunFunctionReq
+	taskID string
+}
+
+// asyncPool 有界的异步任务协程池，worker数量和队列长度可以通过环境变量 ASYNC_WORKERS/ASYNC_QUEUE_SIZE 配置
+type asyncPool struct {
+	once    sync.Once
+	workers int
+	jobs    chan *asyncJob
+}
+
+func newAsyncPool() *asyncPool {
+	workers := getEnvIntOrDefault("ASYNC_WORKERS", 4)
+	if workers <= 0 {
+		workers = 1
+	}
+	queueSize := getEnvIntOrDefault("ASYNC_QUEUE_SIZE", 100)
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &asyncPool{
+		workers: workers,
+		jobs:    make(chan *asyncJob, queueSize),
+	}
+}
+
+// start 启动worker，只会执行一次
+func (p *asyncPool) start(r *Runner) {
+	p.once.Do(func() {
+		for i := 0; i < p.workers; i++ {
+			go func() {
+				for job := range p.jobs {
+					r.runAsyncJob(job)
+				}
+			}()
+		}
+	})
+}
+
+var (
+	taskTableLock = new(sync.Mutex)
+	taskTables    = map[*gorm.DB]bool{} // 已经建好异步任务表的数据库，建表失败时下次使用重试
+)
+
+// taskDB 获取异步任务表所在的数据库，每个数据库首次使用时自动建表并把上次进程遗留的未完成任务标记为失败
+func taskDB(ctx *Context) *gorm.DB {
+	db := ctx.MustGetOrInitDB()
+	taskTableLock.Lock()
+	defer taskTableLock.Unlock()
+	if taskTables[db] {
+		return db
+	}
+	if err := db.AutoMigrate(&AsyncTask{}); err != nil {
+		logger.Errorf(ctx, "create table %s error: %v", AsyncTask{}.TableName(), err)
+		return db
+	}
+	// 只在建表成功后清理一次，之后的任务都是当前进程创建的
+	taskTables[db] = true
+	err := db.Model(&AsyncTask{}).
+		Where("status in ?", []AsyncTaskStatus{AsyncTaskStatusPending, AsyncTaskStatusRunning}).
+		Updates(map[string]interface{}{
+			"status":      AsyncTaskStatusFailed,
+			"error":       "runner进程重启，任务已中断",
+			"finished_at": time.Now().UnixMilli(),
+		}).Error
+	if err != nil {
+		logger.Errorf(ctx, "清理中断的异步任务失败: %v", err)
+	}
+	return db
+}
+
+func getAsyncTask(ctx *Context, taskID string) (*AsyncTask, error) {
+	if taskID == "" {
+		return nil, ValidationError(ctx, map[string]string{"task_id": "必填"})
+	}
+	var task AsyncTask
+	err := taskDB(ctx).Where("task_id = ?", taskID).First(&task).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, Err(ctx).Code("E_TASK_NOT_FOUND").Msg("任务不存在").Detail(taskID).Build()
+		}
+		return nil, err
+	}
+	return &task, nil
+}
+
+func updateAsyncTask(ctx *Context, taskID string, fields map[string]interface{}) {
+	err := taskDB(ctx).Model(&AsyncTask{}).Where("task_id = ?", taskID).Updates(fields).Error
+	if err != nil {
+		logger.Errorf(ctx, "更新异步任务失败 task_id:%s fields:%+v err:%v", taskID, fields, err)
+	}
+}
+
+// submitAsyncTask 创建异步任务并投递到协程池，立即返回任务id
+func (r *Runner) submitAsyncTask(ctx *Context, router *routerInfo, req *request.RunFunctionReq) (*response.RunFunctionResp, error) {
+	task := &AsyncTask{
+		TaskID:  uuid.NewString(),
+		TraceID: ctx.getTraceId(),
+		Router:  router.Router,
+		Method:  router.Method,
+		Status:  AsyncTaskStatusPending,
+	}
+	if err := taskDB(ctx).Create(task).Error; err != nil {
+		return nil, fmt.Errorf("创建异步任务失败: %w", err)
+	}
+
+	r.asyncPool.start(r)
+	// 请求返回后任务还在执行，不能跟着请求的ctx取消（例如HTTP模式下handler返回后 hr.Context() 就会取消）
+	select {
+	case r.asyncPool.jobs <- &asyncJob{ctx: ctx.detached(), router: router, req: req, taskID: task.TaskID}:
+	default:
+		updateAsyncTask(ctx, task.TaskID, map[string]interface{}{
+			"status":      AsyncTaskStatusFailed,
+			"error":       "异步任务队列已满",
+			"finished_at": time.Now().UnixMilli(),
+		})
+		return nil, Err(ctx).Code("E_ASYNC_QUEUE_FULL").Msg("异步任务队列已满").
+			Detail(fmt.Sprintf("队列长度: %d", cap(r.asyncPool.jobs))).Retry().Build()
+	}
+	logger.Infof(ctx, "异步任务已提交 task_id:%s [%s] %s", task.TaskID, router.Method, router.Router)
+
+	rsp := new(response.RunFunctionResp)
+	rsp.TraceID = ctx.getTraceId()
+	if err := rsp.Form(task).Build(); err != nil {
+		return nil, err
+	}
+	rsp.MetaData = map[string]interface{}{
+		"async":   true,
+		"task_id": task.TaskID,
+	}
+	return rsp, nil
+}
+
+// runAsyncJob 在worker中执行异步任务，并把状态和最终结果写回数据库
+func (r *Runner) runAsyncJob(job *asyncJob) {
+	ctx := job.ctx
+	ctx.asyncTaskID = job.taskID
+	if !r.inflight.enter() {
+		err := runnerClosing(ctx)
+		updateAsyncTask(ctx, job.taskID, map[string]interface{}{
+			"status":      AsyncTaskStatusFailed,
+			"error":       err.Error(),
+			"finished_at": time.Now().UnixMilli(),
+		})
+		return
+	}
+	defer r.inflight.exit()
+	defer ctx.cleanupTemp() //任务结果保存之后再删除临时目录
+
+	updateAsyncTask(ctx, job.taskID, map[string]interface{}{
+		"status":     AsyncTaskStatusRunning,
+		"started_at": time.Now().UnixMilli(),
+	})
+
+	var rsp *response.RunFunctionResp
+	var err error
+	if timeout := job.router.timeout(); timeout > 0 {
+		rsp, err = r.invokeWithTimeout(ctx, job.router, job.req, timeout)
+	} else {
+		rsp, err = r.invoke(ctx, job.router, job.req)
+	}
+	r.record(ctx, job.router, job.req, rsp, err)
+
+	fields := map[string]interface{}{
+		"finished_at": time.Now().UnixMilli(),
+	}
+	if err != nil {
+		rsp = newErrorResp(err)
+		fields["status"] = AsyncTaskStatusFailed
+		fields["error"] = err.Error()
+		logger.Errorf(ctx, "异步任务执行失败 task_id:%s err:%v", job.taskID, err)
+	} else {
+		fields["status"] = AsyncTaskStatusSuccess
+		fields["progress"] = 100
+	}
+	result, marshalErr := json.Marshal(rsp)
+	if marshalErr != nil {
+		fields["status"] = AsyncTaskStatusFailed
+		fields["error"] = fmt.Sprintf("响应序列化失败: %v", marshalErr)
+	} else {
+		fields["result"] = string(result)
+	}
+	updateAsyncTask(ctx, job.taskID, fields)
+	logger.Infof(ctx, "异步任务执行结束 task_id:%s status:%v", job.taskID, fields["status"])
+}
+
+func (r *Runner) _taskStatus(ctx *Context, req *AsyncTaskReq, resp response.Response) error {
+	task, err := getAsyncTask(ctx, req.TaskID)
+	if err != nil {
+		return err
+	}
+	return resp.Form(task).Build()
+}
+
+func (r *Runner) _taskResult(ctx *Context, req *AsyncTaskReq, resp response.Response) error {
+	task, err := getAsyncTask(ctx, req.TaskID)
+	if err != nil {
+		return err
+	}
+	if !task.IsFinished() {
+		return Err(ctx).Code("E_TASK_NOT_FINISHED").Msg("任务尚未执行完成").
+			Detail(fmt.Sprintf("status: %s progress: %d%%", task.Status, task.Progress)).Retry().Build()
+	}
+	result := &AsyncTaskResult{TaskID: task.TaskID, Status: task.Status}
+	if task.Result != "" {
+		result.Result = new(response.RunFunctionResp)
+		if err := json.Unmarshal([]byte(task.Result), result.Result); err != nil {
+			return fmt.Errorf("解析任务结果失败: %w", err)
+		}
+	}
+	return resp.Form(result).Build()
+}
@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+)
+
+// TestAsyncTaskOverHTTP HTTP模式下handler返回后 hr.Context() 会被取消，异步任务不能跟着取消
+func TestAsyncTaskOverHTTP(t *testing.T) {
+	chdirTemp(t)
+	t.Cleanup(CloseAllDBs)
+	r := newTestRunner()
+	router := fmt.Sprintf("/async_%d", time.Now().UnixNano())
+	opt := &FormFunctionOptions{}
+	opt.Async = true
+	opt.Timeout = 2000
+	r.post(router, func(ctx *Context, req *slowReq, resp response.Response) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+		return resp.Form(map[string]int{"n": req.N}).Build()
+	}, opt)
+
+	srv := httptest.NewServer(r.httpHandler())
+	defer srv.Close()
+	res, err := http.Post(srv.URL+router, "application/json", strings.NewReader(`{"n":7}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Data AsyncTask `json:"data"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+	if body.Data.TaskID == "" {
+		t.Fatalf("应该返回任务id: %d", res.StatusCode)
+	}
+
+	ctx := NewContext(context.Background(), "GET", "/_task_status", r)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		task, err := getAsyncTask(ctx, body.Data.TaskID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.IsFinished() {
+			if task.Status != AsyncTaskStatusSuccess || !strings.Contains(task.Result, `"n":7`) {
+				t.Fatalf("异步任务应该执行成功: %+v", task)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("异步任务没有结束: %+v", task)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
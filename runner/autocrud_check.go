@@ -0,0 +1,319 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// AutoCrud 默认增删改的服务端校验：
+// 1. 只允许写入模型上存在的字段，未知字段直接拒绝
+// 2. 遵守 permission 标签（read/create/update），没有 permission 标签的字段表示全部权限
+// 3. 执行模型上的 validate 标签校验
+// 校验失败返回 E_VALIDATION 的 AppError，新增按行返回 rows[行号].code 形式的字段错误
+// permission:"update=admin" 这种限定了角色的权限，当前用户没有对应角色时返回 E_FORBIDDEN，见 permission.go
+
+const (
+	permissionRead   = "read"
+	permissionCreate = "create"
+	permissionUpdate = "update"
+)
+
+// crudField AutoCrud模型的字段信息
+type crudField struct {
+	code       string
+	jsonName   string
+	dbName     string
+	permission permissionRule //为nil表示没有permission标签，拥有全部权限
+}
+
+// crudModel 通过gorm解析出来的模型字段，key包括code、json名称和数据库列名
+type crudModel struct {
+	typ    reflect.Type
+	schema *schema.Schema
+	fields map[string]*crudField
+
+	user      *UserInfo       // 当前用户，用于判断限定了角色的字段权限
+	forbidden []string        // checkFields 中因为角色被拒绝的字段
+	unchanged map[string]bool // 更新时提交的值和库里一样的字段，见 markUnchanged
+}
+
+func parseCrudModel(db *gorm.DB, model interface{}) (*crudModel, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, fmt.Errorf("解析AutoCrud模型失败: %w", err)
+	}
+	m := &crudModel{typ: stmt.Schema.ModelType, schema: stmt.Schema, fields: make(map[string]*crudField)}
+	for _, sf := range stmt.Schema.Fields {
+		if sf.DBName == "" {
+			continue
+		}
+		tag := sf.StructField.Tag
+		jsonName := strings.Split(tag.Get("json"), ",")[0]
+		if jsonName == "-" || tag.Get("runner") == "-" {
+			continue
+		}
+		if jsonName == "" {
+			jsonName = sf.Name
+		}
+		permissionTag, ok := tag.Lookup("permission")
+		f := &crudField{
+			code:       runnerCode(tag.Get("runner")),
+			jsonName:   jsonName,
+			dbName:     sf.DBName,
+			permission: parsePermissionTag(permissionTag, ok),
+		}
+		if f.code == "" {
+			f.code = jsonName
+		}
+		for _, key := range []string{f.code, jsonName, sf.DBName} {
+			if key != "" {
+				m.fields[key] = f
+			}
+		}
+	}
+	return m, nil
+}
+
+// checkFields 检查字段是否存在以及是否有对应的权限，错误写入errs，返回允许写入的 code->值
+// 新增时零值的只读字段（例如前端带上的 id:0）、更新时值没有变化的只读字段（前端提交整行）直接忽略，不算越权
+func (m *crudModel) checkFields(values map[string]interface{}, action string, prefix string, errs map[string]string) map[string]interface{} {
+	allowed := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		f, ok := m.fields[key]
+		if !ok {
+			errs[prefix+key] = "未知字段"
+			continue
+		}
+		if !f.permission.allow(action, m.user) {
+			if action == permissionCreate && isEmptyValue(reflect.ValueOf(value)) {
+				continue
+			}
+			if action == permissionUpdate && m.unchanged[f.code] {
+				continue
+			}
+			if f.permission.restricted(action) {
+				m.forbidden = append(m.forbidden, f.code)
+				continue
+			}
+			if action == permissionCreate {
+				errs[prefix+f.code] = "字段不允许新增时填写"
+			} else {
+				errs[prefix+f.code] = "字段不允许修改"
+			}
+			continue
+		}
+		allowed[f.code] = value
+	}
+	return allowed
+}
+
+// markUnchanged 找出没有修改权限、提交的值和要更新的每一行库里的值都一样的字段，checkFields 时忽略这些字段
+func (m *crudModel) markUnchanged(ctx *Context, db *gorm.DB, model interface{}, cond clause.Expression, values map[string]interface{}) error {
+	stored := reflect.New(reflect.SliceOf(m.typ))
+	if err := db.Model(model).Where(cond).Find(stored.Interface()).Error; err != nil {
+		return err
+	}
+	rows := stored.Elem()
+	if rows.Len() == 0 {
+		return nil
+	}
+	m.unchanged = make(map[string]bool)
+	for key, value := range values {
+		f, ok := m.fields[key]
+		if !ok || f.permission.allow(permissionUpdate, m.user) {
+			continue
+		}
+		field := m.schema.LookUpField(f.dbName)
+		if field == nil {
+			continue
+		}
+		// 按模型的字段类型解码后再比较，避免 1 和 1.0、时间格式这类表示上的差异
+		submitted := reflect.New(m.typ)
+		if err := m.decodeValues(map[string]interface{}{f.code: value}, submitted.Interface()); err != nil {
+			continue
+		}
+		want, _ := json.Marshal(field.ReflectValueOf(ctx, submitted.Elem()).Interface())
+		same := true
+		for i := 0; i < rows.Len() && same; i++ {
+			got, _ := json.Marshal(field.ReflectValueOf(ctx, rows.Index(i)).Interface())
+			same = bytes.Equal(got, want)
+		}
+		if same {
+			m.unchanged[f.code] = true
+		}
+	}
+	return nil
+}
+
+// restrictedFields 限定了角色而当前用户没有权限的字段，整行写入（例如回滚）时这些字段都会被覆盖
+func (m *crudModel) restrictedFields(action string) []string {
+	seen := make(map[*crudField]bool, len(m.fields))
+	var codes []string
+	for _, f := range m.fields {
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		if f.permission.restricted(action) && !f.permission.allow(action, m.user) {
+			codes = append(codes, f.code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// autoCrudAddRows AutoCrud默认的新增逻辑，history为true时记录变更历史
+func autoCrudAddRows(ctx *Context, model interface{}, req *usercall.OnTableAddRowsReq, history bool) error {
+	db := ctx.dbFor(model)
+	m, err := parseCrudModel(db, model)
+	if err != nil {
+		return err
+	}
+	m.user = ctx.UserInfo()
+
+	var rows []map[string]interface{}
+	if err := req.DecodeBy(&rows); err != nil {
+		return Err(ctx).Validation().Detail(fmt.Sprintf("新增数据格式错误: %v", err)).Build()
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("没有要添加的数据")
+	}
+
+	slice := reflect.MakeSlice(reflect.SliceOf(m.typ), 0, len(rows))
+	errs := make(map[string]string)
+	for i, row := range rows {
+		prefix := fmt.Sprintf("rows[%d].", i)
+		allowed := m.checkFields(row, permissionCreate, prefix, errs)
+
+		item := reflect.New(m.typ)
+		if err := m.decodeValues(allowed, item.Interface()); err != nil {
+			errs[fmt.Sprintf("rows[%d]", i)] = fmt.Sprintf("数据格式错误: %v", err)
+			continue
+		}
+		for code, msg := range validateStruct(item.Interface()) {
+			errs[prefix+code] = msg
+		}
+		slice = reflect.Append(slice, item.Elem())
+	}
+	if len(m.forbidden) > 0 {
+		return fieldForbidden(ctx, permissionCreate, m.forbidden)
+	}
+	if len(errs) > 0 {
+		return ValidationError(ctx, errs)
+	}
+
+	slicePtr := reflect.New(slice.Type())
+	slicePtr.Elem().Set(slice)
+	if !history {
+		return db.Create(slicePtr.Interface()).Error
+	}
+	if _, err := ensureHistoryTable(db, m.schema); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(slicePtr.Interface()).Error; err != nil {
+			return err
+		}
+		return recordRowsHistory(ctx, tx, m.schema, historyActionCreate, reflect.Value{}, slicePtr.Elem())
+	})
+}
+
+// autoCrudUpdateRows AutoCrud默认的更新逻辑，只校验本次提交的字段，history为true时记录变更历史
+func autoCrudUpdateRows(ctx *Context, model interface{}, req *usercall.OnTableUpdateRowsReq, history bool) error {
+	db := ctx.dbFor(model)
+	m, err := parseCrudModel(db, model)
+	if err != nil {
+		return err
+	}
+	if len(req.Fields) == 0 {
+		return fmt.Errorf("没有要更新的字段")
+	}
+	m.user = ctx.UserInfo()
+	cond, err := primaryKeyCondition(ctx, m.schema, req.GetKeys())
+	if err != nil {
+		return err
+	}
+	if err := m.markUnchanged(ctx, db, model, cond, req.Fields); err != nil {
+		return err
+	}
+
+	errs := make(map[string]string)
+	allowed := m.checkFields(req.Fields, permissionUpdate, "", errs)
+	if len(m.forbidden) > 0 {
+		return fieldForbidden(ctx, permissionUpdate, m.forbidden)
+	}
+	if len(errs) > 0 {
+		return ValidationError(ctx, errs)
+	}
+
+	item := reflect.New(m.typ)
+	if err := m.decodeValues(allowed, item.Interface()); err != nil {
+		return Err(ctx).Validation().Detail(fmt.Sprintf("更新数据格式错误: %v", err)).Build()
+	}
+	for code, msg := range validateStruct(item.Interface()) {
+		if _, ok := allowed[code]; ok {
+			errs[code] = msg
+		}
+	}
+	if len(errs) > 0 {
+		return ValidationError(ctx, errs)
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	updates := make(map[string]interface{}, len(allowed))
+	for code, value := range allowed {
+		switch value.(type) {
+		case map[string]interface{}:
+			marshal, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			value = json.RawMessage(marshal)
+		}
+		updates[m.fields[code].dbName] = value
+	}
+	if !history {
+		return db.Model(model).Where(cond).Updates(updates).Error
+	}
+	if _, err := ensureHistoryTable(db, m.schema); err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		befores := reflect.New(reflect.SliceOf(m.typ))
+		if err := tx.Model(model).Where(cond).Find(befores.Interface()).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(model).Where(cond).Updates(updates).Error; err != nil {
+			return err
+		}
+		afters := reflect.New(reflect.SliceOf(m.typ))
+		if err := tx.Model(model).Where(cond).Find(afters.Interface()).Error; err != nil {
+			return err
+		}
+		return recordRowsHistory(ctx, tx, m.schema, historyActionUpdate, befores.Elem(), afters.Elem())
+	})
+}
+
+// decodeValues 把 code->值 按json名称解码到模型上
+func (m *crudModel) decodeValues(values map[string]interface{}, el interface{}) error {
+	data := make(map[string]interface{}, len(values))
+	for code, value := range values {
+		data[m.fields[code].jsonName] = value
+	}
+	marshal, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(marshal, el)
+}
@@ -0,0 +1,65 @@
+package runner
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type crudCheckOrder struct {
+	ID        int    `json:"id" gorm:"primaryKey;autoIncrement" runner:"code:id;name:ID" permission:"read"`
+	CreatedAt int64  `json:"created_at" gorm:"autoCreateTime:milli" runner:"code:created_at;name:创建时间" permission:"read"`
+	OrderNo   string `json:"order_no" runner:"code:order_no;name:订单号" permission:"read,create" validate:"required"`
+	Status    string `json:"status" runner:"code:status;name:状态" validate:"required,oneof=待付款 已付款"`
+	Remark    string `json:"remark" runner:"code:remark;name:备注"`
+}
+
+func TestCrudModelCheckFields(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	m, err := parseCrudModel(db, &crudCheckOrder{})
+	if err != nil {
+		t.Fatalf("解析模型失败: %v", err)
+	}
+
+	errs := make(map[string]string)
+	allowed := m.checkFields(map[string]interface{}{
+		"order_no":   "NO-1",
+		"status":     "已付款",
+		"created_at": 123,
+		"hack":       1,
+	}, permissionUpdate, "", errs)
+	if errs["order_no"] != "字段不允许修改" || errs["created_at"] != "字段不允许修改" || errs["hack"] != "未知字段" {
+		t.Fatalf("更新时应该拒绝只读字段和未知字段，实际 %v", errs)
+	}
+	if len(allowed) != 1 || allowed["status"] != "已付款" {
+		t.Fatalf("只应该允许更新status，实际 %v", allowed)
+	}
+
+	errs = make(map[string]string)
+	allowed = m.checkFields(map[string]interface{}{
+		"id":       0,
+		"order_no": "NO-1",
+		"status":   "未知状态",
+	}, permissionCreate, "rows[0].", errs)
+	if len(errs) != 0 {
+		t.Fatalf("新增时零值的只读字段应该被忽略，实际 %v", errs)
+	}
+	item := &crudCheckOrder{}
+	if err := m.decodeValues(allowed, item); err != nil {
+		t.Fatalf("解码失败: %v", err)
+	}
+	if got := validateStruct(item); got["status"] == "" || len(got) != 1 {
+		t.Fatalf("期望status校验失败，实际 %v", got)
+	}
+
+	errs = make(map[string]string)
+	m.checkFields(map[string]interface{}{"id": 10}, permissionCreate, "rows[1].", errs)
+	if errs["rows[1].id"] != "字段不允许新增时填写" {
+		t.Fatalf("新增时不允许指定只读字段，实际 %v", errs)
+	}
+}
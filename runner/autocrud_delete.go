@@ -0,0 +1,266 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
+	"github.com/yunhanshu-net/pkg/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// CallbackTypeOnTableRestoreRows 恢复软删除数据的回调类型
+const CallbackTypeOnTableRestoreRows = "OnTableRestoreRows"
+
+const (
+	crudAuditActionDelete  = "delete"
+	crudAuditActionRestore = "restore"
+)
+
+// CrudAudit AutoCrud删除/恢复的审计记录，记录谁在什么时候删除了哪些数据以及删除前的快照
+type CrudAudit struct {
+	ID        int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	Table     string `json:"table" gorm:"size:128;index"`
+	Action    string `json:"action" gorm:"size:16"`
+	Soft      bool   `json:"soft"`
+	User      string `json:"user" gorm:"size:128"`
+	TraceID   string `json:"trace_id" gorm:"size:64"`
+	Keys      string `json:"keys"`     //主键列表，JSON
+	Snapshot  string `json:"snapshot"` //删除前的数据快照，JSON
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (CrudAudit) TableName() string {
+	return "_crud_audit"
+}
+
+var auditTables sync.Map // 已经建过审计表的数据库，key是 *gorm.Config
+
+// auditDB 模型所在的数据库，审计表和数据放在同一个库里，保证在同一个事务中写入
+func auditDB(ctx *Context, model interface{}) *gorm.DB {
+	db := ctx.dbFor(model)
+	if _, ok := auditTables.Load(db.Config); !ok {
+		if err := db.AutoMigrate(&CrudAudit{}); err != nil {
+			logger.Errorf(ctx, "create table %s error: %v", CrudAudit{}.TableName(), err)
+		} else {
+			auditTables.Store(db.Config, true)
+		}
+	}
+	return db
+}
+
+func requestUser(ctx *Context) string {
+	return ctx.UserInfo().Username
+}
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// softDeleteField 模型上的 gorm.DeletedAt 字段，没有则说明是物理删除
+func softDeleteField(s *schema.Schema) *schema.Field {
+	for _, f := range s.Fields {
+		if f.FieldType == deletedAtType && f.DBName != "" {
+			return f
+		}
+	}
+	return nil
+}
+
+// hasSoftDelete 模型是否支持软删除
+func hasSoftDelete(model interface{}) bool {
+	s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return false
+	}
+	return softDeleteField(s) != nil
+}
+
+// primaryKeyCondition 根据gorm解析出的主键生成查询条件
+// 单主键时key是主键值（int/string/UUID），联合主键时key是 {列名或json名称:值}
+func primaryKeyCondition(ctx *Context, s *schema.Schema, keys []interface{}) (clause.Expression, error) {
+	pks := s.PrimaryFields
+	if len(pks) == 0 {
+		return nil, fmt.Errorf("表 %s 没有主键，不支持AutoCrud的修改和删除", s.Table)
+	}
+	if len(keys) == 0 {
+		return nil, ValidationError(ctx, map[string]string{"ids": "必填"})
+	}
+
+	if len(pks) == 1 {
+		values := make([]interface{}, 0, len(keys))
+		for i, key := range keys {
+			if m, ok := key.(map[string]interface{}); ok {
+				v, ok := primaryKeyValue(m, pks[0])
+				if !ok {
+					return nil, ValidationError(ctx, map[string]string{fmt.Sprintf("keys[%d]", i): "缺少主键 " + pks[0].DBName})
+				}
+				key = v
+			}
+			values = append(values, key)
+		}
+		return clause.IN{Column: clause.Column{Table: s.Table, Name: pks[0].DBName}, Values: values}, nil
+	}
+
+	var rows []clause.Expression
+	for i, key := range keys {
+		m, ok := key.(map[string]interface{})
+		if !ok {
+			return nil, ValidationError(ctx, map[string]string{fmt.Sprintf("keys[%d]", i): "联合主键需要传 {列名:值}"})
+		}
+		var eqs []clause.Expression
+		for _, pk := range pks {
+			v, ok := primaryKeyValue(m, pk)
+			if !ok {
+				return nil, ValidationError(ctx, map[string]string{fmt.Sprintf("keys[%d]", i): "缺少主键 " + pk.DBName})
+			}
+			eqs = append(eqs, clause.Eq{Column: clause.Column{Table: s.Table, Name: pk.DBName}, Value: v})
+		}
+		rows = append(rows, clause.And(eqs...))
+	}
+	return clause.Or(rows...), nil
+}
+
+func primaryKeyValue(m map[string]interface{}, pk *schema.Field) (interface{}, bool) {
+	for _, name := range []string{pk.DBName, jsonFieldName(pk), pk.Name} {
+		if v, ok := m[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func jsonFieldName(f *schema.Field) string {
+	name, _, _ := strings.Cut(f.StructField.Tag.Get("json"), ",")
+	return name
+}
+
+func writeCrudAudit(ctx *Context, tx *gorm.DB, audit *CrudAudit) error {
+	audit.User = requestUser(ctx)
+	audit.TraceID = ctx.getTraceId()
+	audit.CreatedAt = time.Now().UnixMilli()
+	return tx.Create(audit).Error
+}
+
+// autoCrudDeleteRows AutoCrud默认的删除逻辑：按gorm主键删除，模型带 gorm.DeletedAt 时软删除，删除前的数据写入审计表
+// history为true时同时记录行的变更历史
+func autoCrudDeleteRows(ctx *Context, model interface{}, req *usercall.OnTableDeleteRowsReq, history bool) (*usercall.OnTableDeleteRowsResp, error) {
+	db := auditDB(ctx, model)
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, fmt.Errorf("解析AutoCrud模型失败: %w", err)
+	}
+	cond, err := primaryKeyCondition(ctx, stmt.Schema, req.GetKeys())
+	if err != nil {
+		return nil, err
+	}
+
+	if history {
+		if _, err := ensureHistoryTable(db, stmt.Schema); err != nil {
+			return nil, err
+		}
+	}
+	resp := &usercall.OnTableDeleteRowsResp{Soft: softDeleteField(stmt.Schema) != nil}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		snapshot := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+		if err := tx.Model(model).Where(cond).Find(snapshot.Interface()).Error; err != nil {
+			return err
+		}
+		if snapshot.Elem().Len() == 0 {
+			return Err(ctx).Code("E_NOT_FOUND").Msg("要删除的数据不存在").Build()
+		}
+
+		res := tx.Where(cond).Delete(model)
+		if res.Error != nil {
+			return res.Error
+		}
+		resp.Deleted = res.RowsAffected
+		if history {
+			if err := recordRowsHistory(ctx, tx, stmt.Schema, historyActionDelete, snapshot.Elem(), reflect.Value{}); err != nil {
+				return err
+			}
+		}
+
+		keys, _ := json.Marshal(req.GetKeys())
+		data, _ := json.Marshal(snapshot.Interface())
+		return writeCrudAudit(ctx, tx, &CrudAudit{
+			Table:    stmt.Schema.Table,
+			Action:   crudAuditActionDelete,
+			Soft:     resp.Soft,
+			Keys:     string(keys),
+			Snapshot: string(data),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// autoCrudRestoreRows AutoCrud默认的恢复逻辑，只支持软删除的模型
+func autoCrudRestoreRows(ctx *Context, model interface{}, req *usercall.OnTableRestoreRowsReq, history bool) (*usercall.OnTableRestoreRowsResp, error) {
+	db := auditDB(ctx, model)
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, fmt.Errorf("解析AutoCrud模型失败: %w", err)
+	}
+	deletedAt := softDeleteField(stmt.Schema)
+	if deletedAt == nil {
+		return nil, Err(ctx).Code("E_NOT_SUPPORTED").Msg("该表不支持恢复").
+			Hint("模型需要包含 gorm.DeletedAt 字段才能软删除和恢复").Build()
+	}
+	cond, err := primaryKeyCondition(ctx, stmt.Schema, req.GetKeys())
+	if err != nil {
+		return nil, err
+	}
+
+	if history {
+		if _, err := ensureHistoryTable(db, stmt.Schema); err != nil {
+			return nil, err
+		}
+	}
+	resp := &usercall.OnTableRestoreRowsResp{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		befores := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+		if history {
+			if err := tx.Unscoped().Model(model).Where(cond).Find(befores.Interface()).Error; err != nil {
+				return err
+			}
+		}
+		res := tx.Unscoped().Model(model).Where(cond).
+			Where(clause.Neq{Column: clause.Column{Table: stmt.Schema.Table, Name: deletedAt.DBName}, Value: nil}).
+			Update(deletedAt.DBName, nil)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return Err(ctx).Code("E_NOT_FOUND").Msg("没有可以恢复的数据").Build()
+		}
+		resp.Restored = res.RowsAffected
+		if history {
+			afters := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+			if err := tx.Model(model).Where(cond).Find(afters.Interface()).Error; err != nil {
+				return err
+			}
+			if err := recordRowsHistory(ctx, tx, stmt.Schema, historyActionRestore, befores.Elem(), afters.Elem()); err != nil {
+				return err
+			}
+		}
+
+		keys, _ := json.Marshal(req.GetKeys())
+		return writeCrudAudit(ctx, tx, &CrudAudit{
+			Table:  stmt.Schema.Table,
+			Action: crudAuditActionRestore,
+			Soft:   true,
+			Keys:   string(keys),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
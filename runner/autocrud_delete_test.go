@@ -0,0 +1,155 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
+	consts "github.com/yunhanshu-net/pkg/constants/usercall"
+)
+
+type deleteHardRow struct {
+	ID   int    `json:"id" gorm:"primaryKey;autoIncrement"`
+	Name string `json:"name"`
+}
+
+type deleteCompositeRow struct {
+	TenantID string `json:"tenant_id" gorm:"primaryKey;size:32"`
+	Code     string `json:"code" gorm:"primaryKey;size:32"`
+	Name     string `json:"name"`
+}
+
+func lastCrudAudit(t *testing.T, ctx *Context) CrudAudit {
+	var audit CrudAudit
+	if err := ctx.MustGetOrInitDB().Order("id desc").First(&audit).Error; err != nil {
+		t.Fatalf("没有审计记录: %v", err)
+	}
+	return audit
+}
+
+func TestAutoCrudSoftDeleteAndRestore(t *testing.T) {
+	ctx := newHistoryTestContext(t)
+	db := ctx.MustGetOrInitDB()
+	db.Create(&[]historyProduct{{Name: "苹果"}, {Name: "香蕉"}})
+
+	rsp, err := autoCrudDeleteRows(ctx, &historyProduct{}, &usercall.OnTableDeleteRowsReq{Ids: []int{1}}, false)
+	if err != nil || !rsp.Soft || rsp.Deleted != 1 {
+		t.Fatalf("应该软删除1行: %+v %v", rsp, err)
+	}
+	var count int64
+	db.Unscoped().Model(&historyProduct{}).Where("id = 1 AND deleted_at IS NOT NULL").Count(&count)
+	if count != 1 {
+		t.Fatal("软删除的数据应该保留在表里")
+	}
+	audit := lastCrudAudit(t, ctx)
+	if audit.Action != crudAuditActionDelete || !audit.Soft || audit.Table != "history_products" || !strings.Contains(audit.Snapshot, "苹果") {
+		t.Fatalf("删除的审计记录不对: %+v", audit)
+	}
+
+	if _, err := autoCrudDeleteRows(ctx, &historyProduct{}, &usercall.OnTableDeleteRowsReq{Ids: []int{1}}, false); err == nil {
+		t.Fatal("已经删除的数据不能再删除")
+	}
+	restored, err := autoCrudRestoreRows(ctx, &historyProduct{}, &usercall.OnTableRestoreRowsReq{Ids: []int{1}}, false)
+	if err != nil || restored.Restored != 1 {
+		t.Fatalf("应该恢复1行: %+v %v", restored, err)
+	}
+	if err := db.First(&historyProduct{}, 1).Error; err != nil {
+		t.Fatalf("恢复后应该能查到数据: %v", err)
+	}
+	if audit := lastCrudAudit(t, ctx); audit.Action != crudAuditActionRestore {
+		t.Fatalf("恢复的审计记录不对: %+v", audit)
+	}
+	var appErr *AppError
+	_, err = autoCrudRestoreRows(ctx, &historyProduct{}, &usercall.OnTableRestoreRowsReq{Ids: []int{2}}, false)
+	if !errors.As(err, &appErr) || appErr.Code != "E_NOT_FOUND" {
+		t.Fatalf("没有被删除的数据不能恢复: %v", err)
+	}
+}
+
+func TestAutoCrudHardDelete(t *testing.T) {
+	ctx := newHistoryTestContext(t)
+	db := ctx.MustGetOrInitDB()
+	if err := db.AutoMigrate(&deleteHardRow{}); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	db.Create(&deleteHardRow{Name: "a"})
+
+	rsp, err := autoCrudDeleteRows(ctx, &deleteHardRow{}, &usercall.OnTableDeleteRowsReq{Keys: []interface{}{1}}, false)
+	if err != nil || rsp.Soft || rsp.Deleted != 1 {
+		t.Fatalf("应该物理删除1行: %+v %v", rsp, err)
+	}
+	var count int64
+	db.Model(&deleteHardRow{}).Count(&count)
+	if count != 0 {
+		t.Fatal("物理删除后表里不应该有数据")
+	}
+	if audit := lastCrudAudit(t, ctx); audit.Soft || !strings.Contains(audit.Snapshot, `"name":"a"`) {
+		t.Fatalf("物理删除也要记录快照: %+v", audit)
+	}
+	var appErr *AppError
+	_, err = autoCrudRestoreRows(ctx, &deleteHardRow{}, &usercall.OnTableRestoreRowsReq{Ids: []int{1}}, false)
+	if !errors.As(err, &appErr) || appErr.Code != "E_NOT_SUPPORTED" {
+		t.Fatalf("没有 gorm.DeletedAt 的模型不支持恢复: %v", err)
+	}
+}
+
+func TestAutoCrudDeleteCompositeKey(t *testing.T) {
+	ctx := newHistoryTestContext(t)
+	db := ctx.MustGetOrInitDB()
+	if err := db.AutoMigrate(&deleteCompositeRow{}); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	db.Create(&[]deleteCompositeRow{{TenantID: "t1", Code: "a"}, {TenantID: "t2", Code: "a"}})
+
+	if _, err := autoCrudDeleteRows(ctx, &deleteCompositeRow{}, &usercall.OnTableDeleteRowsReq{Keys: []interface{}{"a"}}, false); err == nil {
+		t.Fatal("联合主键需要传 {列名:值}")
+	}
+	req := &usercall.OnTableDeleteRowsReq{Keys: []interface{}{map[string]interface{}{"tenant_id": "t1", "code": "a"}}}
+	rsp, err := autoCrudDeleteRows(ctx, &deleteCompositeRow{}, req, false)
+	if err != nil || rsp.Deleted != 1 {
+		t.Fatalf("按联合主键删除失败: %+v %v", rsp, err)
+	}
+	var left []deleteCompositeRow
+	db.Find(&left)
+	if len(left) != 1 || left[0].TenantID != "t2" {
+		t.Fatalf("只应该删除t1的数据: %+v", left)
+	}
+	if audit := lastCrudAudit(t, ctx); !strings.Contains(audit.Keys, `"tenant_id":"t1"`) {
+		t.Fatalf("审计记录应该包含联合主键: %+v", audit)
+	}
+}
+
+// TestTableDeleteRestoreCallback 没有自定义回调时，表格的删除和恢复回调走AutoCrud的默认逻辑
+func TestTableDeleteRestoreCallback(t *testing.T) {
+	ctx := newHistoryTestContext(t)
+	ctx.MustGetOrInitDB().Create(&historyProduct{Name: "苹果"})
+
+	r := newTestRunner()
+	router := fmt.Sprintf("/table_delete_%d", time.Now().UnixNano())
+	r.get(router, func(ctx *Context, req *serveReq, resp response.Response) error {
+		return nil
+	}, &TableFunctionOptions{AutoCrudTable: &historyProduct{}})
+
+	call := func(typ string) map[string]interface{} {
+		resp := &response.RunFunctionResp{}
+		err := r._callback(ctx, &usercall.Request{Method: "GET", Router: router, Type: typ, Body: map[string]interface{}{"ids": []int{1}}}, resp)
+		if err != nil {
+			t.Fatalf("%s 回调失败: %v", typ, err)
+		}
+		data, _ := json.Marshal(resp.Data)
+		var m map[string]interface{}
+		_ = json.Unmarshal(data, &m)
+		return m
+	}
+	if m := call(consts.CallbackTypeOnTableDeleteRows); m["deleted"] != float64(1) || m["soft"] != true {
+		t.Fatalf("删除回调的返回不对: %v", m)
+	}
+	if m := call(CallbackTypeOnTableRestoreRows); m["restored"] != float64(1) {
+		t.Fatalf("恢复回调的返回不对: %v", m)
+	}
+}
@@ -0,0 +1,325 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// AutoCrud 的行级变更历史，TableFunctionOptions.EnableHistory 开启后，
+// 默认的增删改（以及恢复、回滚）都会在同一个事务里写一条历史到 <table>_history，
+// 每一行的历史都有递增的版本号，可以查看某一行的历史并回滚到指定的版本
+
+const (
+	CallbackTypeOnTableRowHistory  = "OnTableRowHistory"  // 查看某一行的变更历史
+	CallbackTypeOnTableRowRollback = "OnTableRowRollback" // 把某一行回滚到指定版本
+)
+
+const (
+	historyActionCreate   = "create"
+	historyActionUpdate   = "update"
+	historyActionDelete   = "delete"
+	historyActionRestore  = "restore"
+	historyActionRollback = "rollback"
+)
+
+// RowHistory 行变更历史，Before/After 是变更前后整行的JSON快照，Diff 是变化的字段 {字段:{old,new}}
+type RowHistory struct {
+	ID        int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	RowKey    string `json:"row_key" gorm:"size:255"`
+	Version   int    `json:"version"`
+	Action    string `json:"action" gorm:"size:16"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	Diff      string `json:"diff"`
+	User      string `json:"user" gorm:"size:128"`
+	TraceID   string `json:"trace_id" gorm:"size:64"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// RowHistoryReq 查看历史的请求，key和删除时的keys元素一致：单主键传主键值，联合主键传 {列名:值}
+type RowHistoryReq struct {
+	Key interface{} `json:"key"`
+}
+
+type RowHistoryResp struct {
+	List []*RowHistory `json:"list"`
+}
+
+// RowRollbackReq 回滚请求，把行恢复成指定版本变更后的样子
+type RowRollbackReq struct {
+	Key     interface{} `json:"key"`
+	Version int         `json:"version"`
+}
+
+type RowRollbackResp struct {
+	Version int `json:"version"` // 回滚产生的新版本号
+}
+
+func historyTableName(s *schema.Schema) string {
+	return s.Table + "_history"
+}
+
+var historyTables sync.Map // 已经建过的历史表，key是 *gorm.Config + 表名
+
+type historyTableKey struct {
+	config *gorm.Config
+	name   string
+}
+
+// ensureHistoryTable 建历史表，索引名带上表名，避免多张历史表在同一个库里重名
+func ensureHistoryTable(tx *gorm.DB, s *schema.Schema) (string, error) {
+	name := historyTableName(s)
+	key := historyTableKey{config: tx.Config, name: name}
+	if _, ok := historyTables.Load(key); ok {
+		return name, nil
+	}
+	if err := tx.Table(name).AutoMigrate(&RowHistory{}); err != nil {
+		return "", fmt.Errorf("创建历史表 %s 失败: %w", name, err)
+	}
+	err := tx.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_row_version ON %s (row_key, version)", name, tx.Statement.Quote(name))).Error
+	if err != nil {
+		return "", fmt.Errorf("创建历史表索引失败: %w", err)
+	}
+	historyTables.Store(key, true)
+	return name, nil
+}
+
+// rowKeyOf 根据行的主键值生成历史记录的key，单主键是主键值的JSON，联合主键是 {列名:值} 的JSON
+func rowKeyOf(ctx *Context, s *schema.Schema, row reflect.Value) string {
+	for row.Kind() == reflect.Ptr {
+		row = row.Elem()
+	}
+	if len(s.PrimaryFields) == 1 {
+		v, _ := s.PrimaryFields[0].ValueOf(ctx, row)
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	data, _ := json.Marshal(primaryKeyMap(ctx, s, row))
+	return string(data)
+}
+
+// rowKeyFromRequest 把请求里的key按主键的类型转换一下再生成key，避免 "1" 和 1 对不上
+func rowKeyFromRequest(ctx *Context, s *schema.Schema, key interface{}) (string, reflect.Value, error) {
+	if len(s.PrimaryFields) == 0 {
+		return "", reflect.Value{}, fmt.Errorf("表 %s 没有主键，不支持历史记录", s.Table)
+	}
+	row := reflect.New(s.ModelType).Elem()
+	m, isMap := key.(map[string]interface{})
+	for _, pk := range s.PrimaryFields {
+		v := key
+		if isMap {
+			var ok bool
+			if v, ok = primaryKeyValue(m, pk); !ok {
+				return "", row, ValidationError(ctx, map[string]string{"key": "缺少主键 " + pk.DBName})
+			}
+		} else if len(s.PrimaryFields) > 1 {
+			return "", row, ValidationError(ctx, map[string]string{"key": "联合主键需要传 {列名:值}"})
+		}
+		if err := pk.Set(ctx, row, v); err != nil {
+			return "", row, ValidationError(ctx, map[string]string{"key": fmt.Sprintf("主键格式错误: %v", err)})
+		}
+	}
+	return rowKeyOf(ctx, s, row), row, nil
+}
+
+// historyDiff 对比两个快照，返回变化的字段
+func historyDiff(before, after []byte) string {
+	var b, a map[string]interface{}
+	_ = json.Unmarshal(before, &b)
+	_ = json.Unmarshal(after, &a)
+	diff := make(map[string]map[string]interface{})
+	for k, nv := range a {
+		if ov, ok := b[k]; !ok || !reflect.DeepEqual(ov, nv) {
+			diff[k] = map[string]interface{}{"old": b[k], "new": nv}
+		}
+	}
+	for k, ov := range b {
+		if _, ok := a[k]; !ok {
+			diff[k] = map[string]interface{}{"old": ov, "new": nil}
+		}
+	}
+	data, _ := json.Marshal(diff)
+	return string(data)
+}
+
+// recordHistory 在事务里给一行写一条历史，before/after 为nil表示新增/删除
+// 历史表需要在事务外先用 ensureHistoryTable 建好，事务回滚时SQLite会一起回滚建表，建表的缓存就不对了
+func recordHistory(ctx *Context, tx *gorm.DB, s *schema.Schema, action string, rowKey string, before, after interface{}) (int, error) {
+	table := historyTableName(s)
+	var last RowHistory
+	if err := tx.Table(table).Where("row_key = ?", rowKey).Order("version desc").Limit(1).Find(&last).Error; err != nil {
+		return 0, err
+	}
+
+	h := &RowHistory{
+		RowKey:    rowKey,
+		Version:   last.Version + 1,
+		Action:    action,
+		User:      requestUser(ctx),
+		TraceID:   ctx.getTraceId(),
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	var beforeData, afterData []byte
+	if before != nil {
+		beforeData, _ = json.Marshal(before)
+		h.Before = string(beforeData)
+	}
+	if after != nil {
+		afterData, _ = json.Marshal(after)
+		h.After = string(afterData)
+	}
+	h.Diff = historyDiff(beforeData, afterData)
+	if err := tx.Table(table).Create(h).Error; err != nil {
+		return 0, err
+	}
+	return h.Version, nil
+}
+
+// recordRowsHistory 给一批行写历史，befores/afters 是同类型的切片（reflect.Value），按主键对应
+func recordRowsHistory(ctx *Context, tx *gorm.DB, s *schema.Schema, action string, befores, afters reflect.Value) error {
+	beforeMap := make(map[string]interface{})
+	var keys []string
+	if befores.IsValid() {
+		for i := 0; i < befores.Len(); i++ {
+			key := rowKeyOf(ctx, s, befores.Index(i))
+			beforeMap[key] = befores.Index(i).Interface()
+			keys = append(keys, key)
+		}
+	}
+	afterMap := make(map[string]interface{})
+	if afters.IsValid() {
+		for i := 0; i < afters.Len(); i++ {
+			key := rowKeyOf(ctx, s, afters.Index(i))
+			if _, ok := beforeMap[key]; !ok {
+				keys = append(keys, key)
+			}
+			afterMap[key] = afters.Index(i).Interface()
+		}
+	}
+	for _, key := range keys {
+		if _, err := recordHistory(ctx, tx, s, action, key, beforeMap[key], afterMap[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// autoCrudRowHistory 查看某一行的变更历史，按版本倒序
+func autoCrudRowHistory(ctx *Context, model interface{}, req *RowHistoryReq) (*RowHistoryResp, error) {
+	db := ctx.dbFor(model)
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, fmt.Errorf("解析AutoCrud模型失败: %w", err)
+	}
+	rowKey, _, err := rowKeyFromRequest(ctx, stmt.Schema, req.Key)
+	if err != nil {
+		return nil, err
+	}
+	table, err := ensureHistoryTable(db, stmt.Schema)
+	if err != nil {
+		return nil, err
+	}
+	resp := &RowHistoryResp{}
+	if err := db.Table(table).Where("row_key = ?", rowKey).Order("version desc").Find(&resp.List).Error; err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// autoCrudRowRollback 把某一行回滚成指定版本变更后的样子，行被物理删除了会重新插入，被软删除了会一起恢复
+// 回滚会覆盖整行，permission 标签限定了修改角色的字段，当前用户都需要有对应角色
+func autoCrudRowRollback(ctx *Context, model interface{}, req *RowRollbackReq) (*RowRollbackResp, error) {
+	db := ctx.dbFor(model)
+	m, err := parseCrudModel(db, model)
+	if err != nil {
+		return nil, err
+	}
+	m.user = ctx.UserInfo()
+	if codes := m.restrictedFields(permissionUpdate); len(codes) > 0 {
+		return nil, fieldForbidden(ctx, permissionUpdate, codes)
+	}
+	s := m.schema
+	rowKey, keyRow, err := rowKeyFromRequest(ctx, s, req.Key)
+	if err != nil {
+		return nil, err
+	}
+	table, err := ensureHistoryTable(db, s)
+	if err != nil {
+		return nil, err
+	}
+
+	var target RowHistory
+	res := db.Table(table).Where("row_key = ? AND version = ?", rowKey, req.Version).Limit(1).Find(&target)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, Err(ctx).Code("E_NOT_FOUND").Msg("历史版本不存在").Build()
+	}
+	if target.After == "" {
+		return nil, Err(ctx).Validation().Msg("该版本是删除操作，不能回滚到删除后的状态").
+			Hint("请选择删除之前的版本").Build()
+	}
+
+	item := reflect.New(s.ModelType)
+	if err := json.Unmarshal([]byte(target.After), item.Interface()); err != nil {
+		return nil, fmt.Errorf("解析历史快照失败: %w", err)
+	}
+
+	resp := &RowRollbackResp{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		cond, err := primaryKeyCondition(ctx, s, []interface{}{primaryKeyMap(ctx, s, keyRow)})
+		if err != nil {
+			return err
+		}
+		current := reflect.New(reflect.SliceOf(s.ModelType))
+		if err := tx.Unscoped().Model(model).Where(cond).Find(current.Interface()).Error; err != nil {
+			return err
+		}
+
+		var before interface{}
+		if current.Elem().Len() > 0 {
+			before = current.Elem().Index(0).Interface()
+			if err := tx.Unscoped().Model(model).Where(cond).Select("*").Updates(item.Interface()).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Create(item.Interface()).Error; err != nil {
+				return err
+			}
+		}
+
+		after := reflect.New(reflect.SliceOf(s.ModelType))
+		if err := tx.Unscoped().Model(model).Where(cond).Find(after.Interface()).Error; err != nil {
+			return err
+		}
+		var afterRow interface{}
+		if after.Elem().Len() > 0 {
+			afterRow = after.Elem().Index(0).Interface()
+		}
+		resp.Version, err = recordHistory(ctx, tx, s, historyActionRollback, rowKey, before, afterRow)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// primaryKeyMap 把行的主键转成 {列名:值}，用来复用 primaryKeyCondition
+func primaryKeyMap(ctx *Context, s *schema.Schema, row reflect.Value) map[string]interface{} {
+	for row.Kind() == reflect.Ptr {
+		row = row.Elem()
+	}
+	values := make(map[string]interface{}, len(s.PrimaryFields))
+	for _, pk := range s.PrimaryFields {
+		values[pk.DBName], _ = pk.ValueOf(ctx, row)
+	}
+	return values
+}
@@ -0,0 +1,157 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
+	"github.com/yunhanshu-net/pkg/trace"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type historyProduct struct {
+	ID        int            `json:"id" gorm:"primaryKey;autoIncrement" runner:"code:id;name:ID" permission:"read"`
+	Name      string         `json:"name" runner:"code:name;name:名称" validate:"required"`
+	Stock     int            `json:"stock" runner:"code:stock;name:库存"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index" runner:"-"`
+}
+
+func newHistoryTestContext(t *testing.T) *Context {
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := db.AutoMigrate(&historyProduct{}); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	ctx := &Context{Context: context.Background(), user: "test", name: t.Name()}
+	name := sanitizeDBName(ctx.getDBName())
+	dbLock.Lock()
+	dbs[name] = db
+	dbLock.Unlock()
+	t.Cleanup(func() {
+		dbLock.Lock()
+		delete(dbs, name)
+		dbLock.Unlock()
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return ctx
+}
+
+func TestAutoCrudHistoryAndRollback(t *testing.T) {
+	ctx := newHistoryTestContext(t)
+	model := &historyProduct{}
+
+	err := autoCrudAddRows(ctx, model, &usercall.OnTableAddRowsReq{Rows: []map[string]interface{}{{"name": "苹果", "stock": 10}}}, true)
+	if err != nil {
+		t.Fatalf("新增失败: %v", err)
+	}
+	err = autoCrudUpdateRows(ctx, model, &usercall.OnTableUpdateRowsReq{Ids: []int{1}, Fields: map[string]interface{}{"stock": 0}}, true)
+	if err != nil {
+		t.Fatalf("更新失败: %v", err)
+	}
+	if _, err = autoCrudDeleteRows(ctx, model, &usercall.OnTableDeleteRowsReq{Ids: []int{1}}, true); err != nil {
+		t.Fatalf("删除失败: %v", err)
+	}
+
+	list, err := autoCrudRowHistory(ctx, model, &RowHistoryReq{Key: "1"})
+	if err != nil {
+		t.Fatalf("查询历史失败: %v", err)
+	}
+	var actions []string
+	for _, h := range list.List {
+		actions = append(actions, fmt.Sprintf("%d:%s", h.Version, h.Action))
+	}
+	if fmt.Sprint(actions) != "[3:delete 2:update 1:create]" {
+		t.Fatalf("历史记录不对: %v", actions)
+	}
+	if list.List[1].Diff != `{"stock":{"new":0,"old":10}}` {
+		t.Fatalf("更新的diff不对: %s", list.List[1].Diff)
+	}
+
+	if _, err := autoCrudRowRollback(ctx, model, &RowRollbackReq{Key: 1, Version: 3}); err == nil {
+		t.Fatal("不应该允许回滚到删除后的版本")
+	}
+	rsp, err := autoCrudRowRollback(ctx, model, &RowRollbackReq{Key: 1, Version: 1})
+	if err != nil {
+		t.Fatalf("回滚失败: %v", err)
+	}
+	if rsp.Version != 4 {
+		t.Fatalf("回滚应该产生版本4，实际 %d", rsp.Version)
+	}
+
+	var got historyProduct
+	if err := ctx.MustGetOrInitDB().First(&got, 1).Error; err != nil {
+		t.Fatalf("回滚后应该能查到数据（软删除被恢复）: %v", err)
+	}
+	if got.Name != "苹果" || got.Stock != 10 {
+		t.Fatalf("回滚后的数据不对: %+v", got)
+	}
+}
+
+func TestAutoCrudRollbackFieldPermission(t *testing.T) {
+	ctx := newHistoryTestContext(t)
+	ctx.FunctionMsg = &trace.FunctionMsg{RequestUser: `{"id":"1","roles":["admin","finance"]}`}
+	model := &financeRow{}
+	if err := ctx.MustGetOrInitDB().AutoMigrate(model); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	err := autoCrudAddRows(ctx, model, &usercall.OnTableAddRowsReq{Rows: []map[string]interface{}{{"name": "a", "price": 10}}}, true)
+	if err != nil {
+		t.Fatalf("新增失败: %v", err)
+	}
+	err = autoCrudUpdateRows(ctx, model, &usercall.OnTableUpdateRowsReq{Ids: []int{1}, Fields: map[string]interface{}{"cost": 8}}, true)
+	if err != nil {
+		t.Fatalf("更新失败: %v", err)
+	}
+
+	sales := &Context{Context: context.Background(), user: ctx.user, name: ctx.name}
+	sales.FunctionMsg = &trace.FunctionMsg{RequestUser: `{"id":"2","roles":["admin"]}`}
+	_, err = autoCrudRowRollback(sales, model, &RowRollbackReq{Key: 1, Version: 1})
+	var appErr *AppError
+	if !errors.As(err, &appErr) || appErr.Code != "E_FORBIDDEN" {
+		t.Fatalf("没有成本的修改权限时不能回滚: %v", err)
+	}
+	if _, err := autoCrudRowRollback(ctx, model, &RowRollbackReq{Key: 1, Version: 1}); err != nil {
+		t.Fatalf("有权限时应该能回滚: %v", err)
+	}
+	var got financeRow
+	if err := ctx.MustGetOrInitDB().First(&got, 1).Error; err != nil || got.Cost != 0 || got.Price != 10 {
+		t.Fatalf("回滚后的数据不对: %+v %v", got, err)
+	}
+}
+
+type historySku struct {
+	Code  string `json:"code" gorm:"primaryKey;size:32" runner:"code:code;name:编码"`
+	Stock int    `json:"stock" runner:"code:stock;name:库存"`
+}
+
+func TestAutoCrudUpdateByKeys(t *testing.T) {
+	ctx := newHistoryTestContext(t)
+	db := ctx.MustGetOrInitDB()
+	if err := db.AutoMigrate(&historySku{}); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	db.Create(&[]historySku{{Code: "a", Stock: 1}, {Code: "b", Stock: 1}})
+
+	for _, history := range []bool{false, true} {
+		err := autoCrudUpdateRows(ctx, &historySku{}, &usercall.OnTableUpdateRowsReq{Keys: []interface{}{"a"}, Fields: map[string]interface{}{"stock": 5}}, history)
+		if err != nil {
+			t.Fatalf("按字符串主键更新失败: %v", err)
+		}
+	}
+	var list []historySku
+	db.Order("code").Find(&list)
+	if list[0].Stock != 5 || list[1].Stock != 1 {
+		t.Fatalf("只应该更新主键为a的行: %+v", list)
+	}
+	if err := autoCrudUpdateRows(ctx, &historySku{}, &usercall.OnTableUpdateRowsReq{Fields: map[string]interface{}{"stock": 0}}, false); err == nil {
+		t.Fatal("没有传主键时不应该更新")
+	}
+}
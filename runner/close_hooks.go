@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
+	"github.com/yunhanshu-net/pkg/logger"
+)
+
+// runner关闭的原因，会传给 BeforeRunnerClose/AfterRunnerClose
+const (
+	CloseReasonIdle     = "idle"      // 超过空闲时间没有请求
+	CloseReasonDown     = "down"      // 平台通知关闭
+	CloseReasonNatsLost = "nats_lost" // NATS连接断开
+	CloseReasonExit     = "exit"      // 进程正常退出
+)
+
+// setCloseReason 记录关闭原因，只有第一次设置的生效
+func (r *Runner) setCloseReason(reason string) {
+	r.closeReason.CompareAndSwap(nil, &reason)
+}
+
+func (r *Runner) getCloseReason() string {
+	if reason := r.closeReason.Load(); reason != nil {
+		return *reason
+	}
+	return CloseReasonExit
+}
+
+// closeHookTimeout 关闭回调的总时间预算，环境变量 RUNNER_CLOSE_HOOK_TIMEOUT_MS，默认5秒
+func closeHookTimeout() time.Duration {
+	return time.Duration(getEnvIntOrDefault("RUNNER_CLOSE_HOOK_TIMEOUT_MS", 5000)) * time.Millisecond
+}
+
+// runCloseHooks 按路由顺序执行所有路由配置的关闭回调，超过deadline的回调不再等待，剩下的回调跳过
+func (r *Runner) runCloseHooks(ctx context.Context, typ string, reason string, deadline time.Time) {
+	keys := make([]string, 0, len(r.routerMap))
+	for key := range r.routerMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		worker := r.routerMap[key]
+		if worker.Option == nil {
+			continue
+		}
+		callback, ok := worker.Option.GetCallbacks()[typ]
+		if !ok {
+			continue
+		}
+		if time.Now().After(deadline) {
+			logger.Warnf(ctx, "%s 回调跳过 [%s %s]: 超过关闭时间预算", typ, worker.Method, worker.Router)
+			continue
+		}
+
+		start := time.Now()
+		err := r.callCloseHook(ctx, worker, callback, reason, deadline)
+		cost := time.Since(start)
+		if err != nil {
+			logger.Errorf(ctx, "%s 回调失败 [%s %s] reason:%s 耗时:%v: %v", typ, worker.Method, worker.Router, reason, cost, err)
+			continue
+		}
+		logger.Infof(ctx, "%s 回调成功 [%s %s] reason:%s 耗时:%v", typ, worker.Method, worker.Router, reason, cost)
+	}
+}
+
+// callCloseHook 在deadline内执行一个关闭回调，超时后返回错误，回调本身可以通过ctx.Done()感知
+func (r *Runner) callCloseHook(ctx context.Context, worker *routerInfo, callback interface{}, reason string, deadline time.Time) error {
+	hookCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	c := NewContext(hookCtx, worker.Method, worker.Router, r)
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		switch hook := callback.(type) {
+		case BeforeRunnerClose:
+			done <- hook(c, &usercall.BeforeRunnerCloseReq{Reason: reason})
+		case AfterRunnerClose:
+			done <- hook(c, &usercall.AfterRunnerCloseReq{Reason: reason})
+		default:
+			done <- fmt.Errorf("回调类型不匹配: %T", callback)
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-hookCtx.Done():
+		return fmt.Errorf("执行超时: %w", hookCtx.Err())
+	}
+}
@@ -183,6 +183,13 @@ func (cm *ConfigManager) getBeforeConfigChangeCallback(configKey string) BeforeC
 	return cm.callbacks[configKey]
 }
 
+// setCache 直接设置缓存中的配置，不写入存储，回放录制时用来恢复配置快照
+func (cm *ConfigManager) setCache(configKey string, config *usercall.ConfigData) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.cache[configKey] = config
+}
+
 // ClearCache 清空缓存
 func (cm *ConfigManager) ClearCache() {
 	cm.mutex.Lock()
@@ -3,12 +3,16 @@ package runner
 import (
 	"context"
 	"fmt"
+	"mime"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/yunhanshu-net/function-go/env"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
 	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
 	"github.com/yunhanshu-net/pkg/trace"
 
@@ -95,7 +99,15 @@ type Context struct {
 	// Logger 绑定的日志记录器
 	Logger *ContextLogger
 
-	runner *Runner
+	runner       *Runner
+	asyncTaskID  string              //异步执行时对应的任务id
+	streamSink   response.StreamSink //流式响应分片的投递方式，见 resp.Stream()
+	progressSink progressSink        //进度事件的投递方式，见 Progress
+
+	userOnce sync.Once
+	userInfo *UserInfo // 发起请求的用户，见 UserInfo()
+
+	temp *requestTemp // 当前请求的临时目录，见 TempBaseDir
 }
 
 type FunctionUrl struct {
@@ -176,6 +188,7 @@ func NewContext(ctx context.Context, method string, router string, runner *Runne
 		method:  method,
 		router:  router,
 		Locker:  newLock(), //分布式锁
+		temp:    &requestTemp{},
 	}
 	contextInstance.Context = ctx
 	//v, ok := ctx.Value(trace.FunctionMsgKey).(*trace.FunctionMsg)
@@ -227,6 +240,41 @@ func NewContext(ctx context.Context, method string, router string, runner *Runne
 	return contextInstance
 }
 
+// detached 复制一份不随请求取消的Context，保留trace_id和请求用户，用于请求返回后继续执行的任务
+// 流式响应的投递方式不会复制，请求返回后分片只做聚合
+func (c *Context) detached() *Context {
+	d := NewContext(context.WithoutCancel(c.Context), c.method, c.router, c.runner)
+	d.refRouter, d.refMethod = c.refRouter, c.refMethod
+	d.FunctionMsg = c.FunctionMsg
+	return d
+}
+
+// withContext 浅拷贝一份使用ctx的Context，临时目录、分布式锁和请求用户和原Context共享，
+// 用于给处理函数单独设置截止时间而不修改调用方的Context
+func (c *Context) withContext(ctx context.Context) *Context {
+	user := c.UserInfo()
+	d := &Context{
+		refRouter:    c.refRouter,
+		refMethod:    c.refMethod,
+		Context:      ctx,
+		user:         c.user,
+		name:         c.name,
+		version:      c.version,
+		router:       c.router,
+		method:       c.method,
+		FunctionMsg:  c.FunctionMsg,
+		Locker:       c.Locker,
+		Logger:       c.Logger,
+		runner:       c.runner,
+		asyncTaskID:  c.asyncTaskID,
+		streamSink:   c.streamSink,
+		progressSink: c.progressSink,
+		temp:         c.temp,
+	}
+	d.userOnce.Do(func() { d.userInfo = user })
+	return d
+}
+
 func (c *Context) getDBName() string {
 	return fmt.Sprintf("%s_%s.db", c.user, c.name)
 }
@@ -244,20 +292,12 @@ func (c *Context) getTraceId() string {
 }
 
 func (c *Context) GetUsername() string {
-	return c.FunctionMsg.RequestUser
-}
-
-type UserInfo struct {
-	IsLoggedIn bool   `json:"is_logged_in"` //是否已经登陆？
-	Username   string `json:"username"`     //用户名
+	return c.UserInfo().Username
 }
 
+// GetUserInfo 发起请求的用户，见 UserInfo()
 func (c *Context) GetUserInfo() UserInfo {
-
-	return UserInfo{
-		IsLoggedIn: true,
-		Username:   c.FunctionMsg.RequestUser,
-	}
+	return *c.UserInfo()
 }
 
 func (c *Context) GetFile() string {
@@ -297,6 +337,8 @@ func (c *Context) GetFunctionMsg() *trace.FunctionMsg {
 // ===== Files 相关方法 =====
 
 // NewFiles 创建新的文件集合，自动设置context
+// 需要上传的文件请使用 CreateFilesFromData/CreateFilesFromPath，它们通过 StorageProvider 保存，
+// files.Files 自带的 AddFileFromData/AddFileFromPath 不经过 StorageProvider
 func (c *Context) NewFiles(input interface{}) *files.Files {
 	return files.NewFiles(input).SetContext(c.Context)
 }
@@ -322,56 +364,90 @@ func (c *Context) NewPermanentFiles() *files.Files {
 		SetUnlimited()
 }
 
-// CreateFilesFromData 从数据创建文件并立即上传到规范路径
+// CreateFilesFromData 从数据创建文件并通过 StorageProvider 立即上传到规范路径
 func (c *Context) CreateFilesFromData(filename string, data []byte) (*files.Files, error) {
-	files := c.NewFiles([]string{})
-	err := files.AddFileFromData(filename, data)
+	storage, err := getStorageProvider()
+	if err != nil {
+		return nil, err
+	}
+	url, err := c.upload(storage, filename, data)
 	if err != nil {
 		return nil, err
 	}
-	return files, nil
+	return c.NewFiles([]string{url}), nil
 }
 
 // CreateFilesFromPath 从本地路径创建文件并立即上传到规范路径
 func (c *Context) CreateFilesFromPath(localPath string) (*files.Files, error) {
-	files := c.NewFiles([]string{})
-	err := files.AddFileFromPath(localPath)
+	storage, err := getStorageProvider()
 	if err != nil {
 		return nil, err
 	}
-	return files, nil
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	url, err := c.upload(storage, filepath.Base(localPath), data)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewFiles([]string{url}), nil
 }
 
-// ===== Temp 目录工具（统一输出到 ./temp/<traceID>/...） =====
+// uploadKey 文件在存储中的key：<user>/<runner>/<router>/<trace_id>/<filename>
+func (c *Context) uploadKey(filename string) string {
+	return strings.TrimPrefix(path.Join(c.GetUploadPath(), c.getTraceId(), filepath.Base(filename)), "/")
+}
 
-// TempBaseDir 返回当前请求的基础临时目录路径：./temp/<traceID>
-// 并确保目录已创建。
-func (c *Context) TempBaseDir() (string, error) {
-	workDir, err := os.Getwd()
+func (c *Context) upload(storage StorageProvider, filename string, data []byte) (string, error) {
+	key := c.uploadKey(filename)
+	url, err := storage.Put(c.Context, key, data, mime.TypeByExtension(filepath.Ext(filename)))
 	if err != nil {
-		return "", fmt.Errorf("获取工作目录失败: %w", err)
+		return "", fmt.Errorf("上传文件 %s 到 %s 失败: %w", filename, storage.Name(), err)
+	}
+	return url, nil
+}
+
+// ===== Temp 目录工具（统一输出到 ./temp/<router>/<traceID>/...，见 temp.go） =====
+
+// TempBaseDir 返回当前请求的基础临时目录路径：./temp/<router>/<traceID>，router中的/替换成.
+// 并确保目录已创建。请求结束后目录会被删除，需要保留时调用 ctx.FS().KeepTemp()
+func (c *Context) TempBaseDir() (string, error) {
+	t := c.temp
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.base == "" {
+		workDir, err := os.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("获取工作目录失败: %w", err)
+		}
+		traceID := c.getTraceId()
+		if traceID == "" {
+			traceID = fmt.Sprintf("ctx-%d", time.Now().UnixNano())
+		}
+		t.base = filepath.Join(workDir, tempDirName, tempRouterDir(c.router), unsafeFileChars.ReplaceAllString(traceID, "_"))
 	}
-	base := filepath.Join(workDir, "temp", c.getTraceId())
-	if err := os.MkdirAll(base, 0755); err != nil {
+	if err := os.MkdirAll(t.base, 0755); err != nil {
 		return "", fmt.Errorf("创建临时目录失败: %w", err)
 	}
-	return base, nil
+	activeTempDirs.Store(t.base, struct{}{})
+	return t.base, nil
 }
 
-// TempDir 在基础临时目录下拼接子路径并确保创建。
+// TempDir 在基础临时目录下拼接子路径并确保创建，子路径不能跳出基础临时目录。
 func (c *Context) TempDir(parts ...string) (string, error) {
 	base, err := c.TempBaseDir()
 	if err != nil {
 		return "", err
 	}
-	full := filepath.Join(append([]string{base}, parts...)...)
+	full := filepath.Join(base, filepath.Clean(string(filepath.Separator)+filepath.Join(parts...)))
 	if err := os.MkdirAll(full, 0755); err != nil {
 		return "", fmt.Errorf("创建临时子目录失败: %w", err)
 	}
 	return full, nil
 }
 
-// TempUniqueDir 在基础临时目录下创建带时间戳的唯一子目录：./temp/<traceID>/<prefix>/<ns>
+// TempUniqueDir 在基础临时目录下创建带时间戳的唯一子目录：./temp/<router>/<traceID>/<prefix>/<ns>
 func (c *Context) TempUniqueDir(prefix string, parts ...string) (string, error) {
 	baseParts := []string{prefix, fmt.Sprintf("%d", time.Now().UnixNano())}
 	baseParts = append(baseParts, parts...)
@@ -396,9 +472,45 @@ func (fs *ContextFS) TempUniqueDir(prefix string, parts ...string) (string, erro
 	return fs.ctx.TempUniqueDir(prefix, parts...)
 }
 
+// GetTempUniqueDir 当前请求的临时目录 ./temp/<router>/<traceID>/<part>，router中的/替换成.，创建失败时返回空字符串
 func (fs *ContextFS) GetTempUniqueDir(part string) string {
-	//todo 这里应该是./temp/router把/替换成./trace_id/{part}
-	return ""
+	dir, err := fs.ctx.TempDir(part)
+	if err != nil {
+		logger.Errorf(fs.ctx, "创建临时目录失败: %v", err)
+		return ""
+	}
+	return dir
+}
+
+// KeepTemp 请求结束后保留临时目录，保留的目录由后台清理任务按 RUNNER_TEMP_MAX_AGE_HOURS 删除
+func (fs *ContextFS) KeepTemp() { fs.ctx.keepTemp() }
+
+// CreateTempFile 在临时目录下创建文件，写入计入当前请求的临时目录配额（RUNNER_TEMP_QUOTA_MB），超出时写入返回 E_TEMP_QUOTA
+func (fs *ContextFS) CreateTempFile(name string) (*TempFile, error) {
+	return fs.ctx.createTempFile(name)
+}
+
+// WriteTempFile 把数据写入临时目录下的文件，返回文件路径，超出配额时返回 E_TEMP_QUOTA
+func (fs *ContextFS) WriteTempFile(name string, data []byte) (string, error) {
+	f, err := fs.ctx.createTempFile(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// TempUsage 当前请求的临时目录占用的字节数
+func (fs *ContextFS) TempUsage() (int64, error) {
+	base, err := fs.ctx.TempBaseDir()
+	if err != nil {
+		return 0, err
+	}
+	return dirSize(base)
 }
 
 // Files 构建与上传（委托 Context 内部实现）
@@ -413,6 +525,18 @@ func (fs *ContextFS) CreateFilesFromPath(localPath string) (*files.Files, error)
 	return fs.ctx.CreateFilesFromPath(localPath)
 }
 
+// Storage 当前使用的文件存储
+func (fs *ContextFS) Storage() (StorageProvider, error) { return getStorageProvider() }
+
+// Upload 把数据上传到当前请求的规范路径，返回下载地址
+func (fs *ContextFS) Upload(filename string, data []byte) (string, error) {
+	storage, err := getStorageProvider()
+	if err != nil {
+		return "", err
+	}
+	return fs.ctx.upload(storage, filename, data)
+}
+
 // ===== Config 相关方法 =====
 
 // ConfigManager 获取配置管理器
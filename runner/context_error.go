@@ -1,5 +1,10 @@
 package runner
 
+import (
+	"fmt"
+	"time"
+)
+
 // 极简的结构化错误与构造器（仅在本文件实现，不改动其他文件）
 
 // AppError 为平台统一的结构化错误
@@ -110,6 +115,19 @@ func ExecFailed(ctx *Context, msg, detail string) error {
 	return Err(ctx).Code("E_EXEC_FAILED").Msg(msg).Detail(detail).Retryable(false).Build()
 }
 
+// ExecTimeout 函数执行超时的错误（超过 BaseConfig.Timeout 配置的时间）
+func ExecTimeout(ctx *Context, timeout time.Duration) error {
+	return Err(ctx).Code("E_TIMEOUT").Msg("函数执行超时").
+		Detail(fmt.Sprintf("执行时间超过了配置的超时时间 %v", timeout)).
+		Hint("请稍后重试，或调大函数的 Timeout 配置").Retryable(true).Build()
+}
+
+// runnerClosing runner正在关闭，不再接收新请求
+func runnerClosing(ctx *Context) error {
+	return Err(ctx).Code("E_RUNNER_CLOSING").Msg("runner正在关闭").
+		Hint("请稍后重试，平台会把请求转给其他实例").Retryable(true).Build()
+}
+
 // 常见错误场景的一键封装（减少样板代码）
 func (b *ErrorBuilder) Validation() *ErrorBuilder {
 	if b.e.Code == "" {
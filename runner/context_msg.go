@@ -1,12 +1,574 @@
 package runner
 
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/yunhanshu-net/pkg/logger"
+)
+
+// 消息通知，处理函数通过 ctx.Notify() 发送，默认异步投递，失败按指数退避重试
+//
+//	ctx.Notify().Channel("email").To("a@b.com").Template("order_paid", map[string]interface{}{"no": no}).Send()
+//
+// 内置渠道（名称 -> 配置）：
+//
+//	email    SMTP邮件，NOTIFY_SMTP_HOST/NOTIFY_SMTP_PORT(587)/NOTIFY_SMTP_USER/NOTIFY_SMTP_PASSWORD/NOTIFY_SMTP_FROM，配置了HOST才可用
+//	webhook  POST JSON到 NOTIFY_WEBHOOK_URL，配置了URL才可用
+//	platform 平台站内信，通过NATS发布到 NOTIFY_NATS_SUBJECT（默认 runner.notify）
+//	file     追加JSON行到 NOTIFY_FILE（默认 logs/notify.jsonl），本地开发和测试使用
+//
+// 没有指定渠道时使用 NOTIFY_DEFAULT_CHANNEL（默认file），本地开发不会真的发出邮件
+// 重试次数 NOTIFY_MAX_RETRIES（默认3），首次重试间隔 NOTIFY_RETRY_BACKOFF_MS（默认1000）
+const (
+	NotifyChannelEmail    = "email"
+	NotifyChannelWebhook  = "webhook"
+	NotifyChannelPlatform = "platform"
+	NotifyChannelFile     = "file"
+)
+
+// Notification 一条通知，Template不为空时Subject和Body由模板渲染
+type Notification struct {
+	ID        string                 `json:"id"`
+	Channel   string                 `json:"channel"`
+	To        []string               `json:"to"`
+	Subject   string                 `json:"subject"`
+	Body      string                 `json:"body"`
+	HTML      bool                   `json:"html,omitempty"`
+	Template  string                 `json:"template,omitempty"`
+	Vars      map[string]interface{} `json:"vars,omitempty"`
+	TraceID   string                 `json:"trace_id"`
+	Router    string                 `json:"router"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// NotifyChannel 通知渠道，Send返回错误时会重试
+type NotifyChannel interface {
+	Name() string
+	Send(ctx context.Context, n *Notification) error
+}
+
+// MsgServices 消息服务，ctx.GetMessageService() 的简化接口，更多选项见 ctx.Notify()
 type MsgServices interface {
+	Send(channel string, to []string, subject string, body string) error
+	SendTemplate(channel string, template string, to []string, vars map[string]interface{}) error
 }
 
 type IMsgServices struct {
+	ctx *Context
+}
+
+func (s *IMsgServices) Send(channel string, to []string, subject string, body string) error {
+	return s.ctx.Notify().Channel(channel).To(to...).Subject(subject).Body(body).Send()
+}
+
+func (s *IMsgServices) SendTemplate(channel string, template string, to []string, vars map[string]interface{}) error {
+	return s.ctx.Notify().Channel(channel).To(to...).Template(template, vars).Send()
 }
 
 func (c *Context) GetMessageService() MsgServices {
+	return &IMsgServices{ctx: c}
+}
+
+// Notify 构造一条通知，链式设置后调用 Send（异步）或 SendSync（同步）
+func (c *Context) Notify() *NotifyBuilder {
+	return &NotifyBuilder{ctx: c, n: &Notification{}}
+}
+
+// NotifyBuilder 通知构造器（链式）
+type NotifyBuilder struct {
+	ctx *Context
+	n   *Notification
+}
+
+func (b *NotifyBuilder) Channel(name string) *NotifyBuilder { b.n.Channel = name; return b }
+func (b *NotifyBuilder) To(to ...string) *NotifyBuilder     { b.n.To = append(b.n.To, to...); return b }
+func (b *NotifyBuilder) Subject(s string) *NotifyBuilder    { b.n.Subject = s; return b }
+func (b *NotifyBuilder) Body(s string) *NotifyBuilder       { b.n.Body = s; return b }
+func (b *NotifyBuilder) HTML() *NotifyBuilder               { b.n.HTML = true; return b }
+
+// Template 使用 RegisterNotifyTemplate 注册的模板
+func (b *NotifyBuilder) Template(name string, vars map[string]interface{}) *NotifyBuilder {
+	b.n.Template = name
+	for k, v := range vars {
+		b.Var(k, v)
+	}
+	return b
+}
+
+// Var 设置模板变量，没有使用模板时Subject和Body也会按变量替换
+func (b *NotifyBuilder) Var(key string, value interface{}) *NotifyBuilder {
+	if b.n.Vars == nil {
+		b.n.Vars = make(map[string]interface{})
+	}
+	b.n.Vars[key] = value
+	return b
+}
+
+// Send 渲染后放入发送队列立即返回，渠道不存在、模板错误或者队列已满时返回错误
+func (b *NotifyBuilder) Send() error {
+	ch, err := b.prepare()
+	if err != nil {
+		return err
+	}
+	return notifier.enqueue(b.ctx, ch, b.n)
+}
+
+// SendSync 渲染后同步发送，失败时按配置重试，返回最后一次的错误
+func (b *NotifyBuilder) SendSync() error {
+	ch, err := b.prepare()
+	if err != nil {
+		return err
+	}
+	return notifier.deliver(b.ctx, ch, b.n)
+}
+
+func (b *NotifyBuilder) prepare() (NotifyChannel, error) {
+	n := b.n
+	if n.Channel == "" {
+		n.Channel = getEnvOrDefault("NOTIFY_DEFAULT_CHANNEL", NotifyChannelFile)
+	}
+	ch := notifier.channel(n.Channel)
+	if ch == nil {
+		return nil, Err(b.ctx).Code("E_NOTIFY_CHANNEL").Msg("通知渠道不可用").
+			Detail(fmt.Sprintf("渠道 %s 未注册或者没有配置", n.Channel)).Build()
+	}
+	n.ID = uuid.NewString()
+	n.TraceID = b.ctx.getTraceId()
+	n.Router = b.ctx.router
+	n.CreatedAt = time.Now()
+	if err := notifier.render(n); err != nil {
+		return nil, Err(b.ctx).Code("E_NOTIFY_TEMPLATE").Msg("通知模板渲染失败").Detail(err.Error()).Build()
+	}
+	return ch, nil
+}
+
+// ===== 渠道注册与模板 =====
+
+type notifyTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+type notifyService struct {
+	mu        sync.RWMutex
+	channels  map[string]NotifyChannel
+	templates map[string]*notifyTemplate
+
+	initOnce  sync.Once
+	startOnce sync.Once
+	jobs      chan *notifyJob
+	pending   sync.WaitGroup
+}
+
+type notifyJob struct {
+	ctx *Context
+	ch  NotifyChannel
+	n   *Notification
+}
+
+var notifier = &notifyService{channels: map[string]NotifyChannel{}, templates: map[string]*notifyTemplate{}}
+
+// RegisterNotifyChannel 注册或替换通知渠道，例如在测试中注册 MemoryChannel
+func RegisterNotifyChannel(ch NotifyChannel) {
+	notifier.init()
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	notifier.channels[ch.Name()] = ch
+}
+
+// RegisterNotifyTemplate 注册通知模板，subject和body使用 text/template 语法，变量通过 {{.name}} 引用
+// 渲染时额外提供 trace_id 和 router 两个变量
+func RegisterNotifyTemplate(name string, subject string, body string) error {
+	st, err := template.New(name + ".subject").Option("missingkey=error").Parse(subject)
+	if err != nil {
+		return fmt.Errorf("解析通知模板 %s 的标题失败: %w", name, err)
+	}
+	bt, err := template.New(name + ".body").Option("missingkey=error").Parse(body)
+	if err != nil {
+		return fmt.Errorf("解析通知模板 %s 的内容失败: %w", name, err)
+	}
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	notifier.templates[name] = &notifyTemplate{subject: st, body: bt}
+	return nil
+}
+
+// init 按环境变量注册内置渠道，只执行一次，已经通过 RegisterNotifyChannel 注册的同名渠道不会被覆盖
+func (s *notifyService) init() {
+	s.initOnce.Do(func() {
+		builtin := []NotifyChannel{
+			&NatsChannel{Subject: getEnvOrDefault("NOTIFY_NATS_SUBJECT", "runner.notify")},
+			&FileChannel{Path: getEnvOrDefault("NOTIFY_FILE", "logs/notify.jsonl")},
+		}
+		if host := getEnvOrDefault("NOTIFY_SMTP_HOST", ""); host != "" {
+			builtin = append(builtin, &SMTPChannel{
+				Host:     host,
+				Port:     getEnvIntOrDefault("NOTIFY_SMTP_PORT", 587),
+				Username: getEnvOrDefault("NOTIFY_SMTP_USER", ""),
+				Password: getEnvOrDefault("NOTIFY_SMTP_PASSWORD", ""),
+				From:     getEnvOrDefault("NOTIFY_SMTP_FROM", getEnvOrDefault("NOTIFY_SMTP_USER", "")),
+			})
+		}
+		if url := getEnvOrDefault("NOTIFY_WEBHOOK_URL", ""); url != "" {
+			builtin = append(builtin, &WebhookChannel{URL: url})
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, ch := range builtin {
+			if _, ok := s.channels[ch.Name()]; !ok {
+				s.channels[ch.Name()] = ch
+			}
+		}
+	})
+}
+
+func (s *notifyService) channel(name string) NotifyChannel {
+	s.init()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.channels[name]
+}
+
+// render 渲染模板，没有模板但是设置了变量时，Subject和Body按内联模板渲染
+func (s *notifyService) render(n *Notification) error {
+	vars := map[string]interface{}{"trace_id": n.TraceID, "router": n.Router}
+	for k, v := range n.Vars {
+		vars[k] = v
+	}
+	var subject, body *template.Template
+	if n.Template != "" {
+		s.mu.RLock()
+		t, ok := s.templates[n.Template]
+		s.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("通知模板 %s 不存在", n.Template)
+		}
+		subject, body = t.subject, t.body
+	} else if len(n.Vars) > 0 {
+		var err error
+		if subject, err = template.New("subject").Option("missingkey=error").Parse(n.Subject); err != nil {
+			return err
+		}
+		if body, err = template.New("body").Option("missingkey=error").Parse(n.Body); err != nil {
+			return err
+		}
+	} else {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := subject.Execute(&buf, vars); err != nil {
+		return err
+	}
+	n.Subject = buf.String()
+	buf.Reset()
+	if err := body.Execute(&buf, vars); err != nil {
+		return err
+	}
+	n.Body = buf.String()
+	return nil
+}
+
+// ===== 异步投递与重试 =====
+
+func (s *notifyService) start() {
+	s.startOnce.Do(func() {
+		workers := getEnvIntOrDefault("NOTIFY_WORKERS", 2)
+		if workers <= 0 {
+			workers = 1
+		}
+		s.jobs = make(chan *notifyJob, getEnvIntOrDefault("NOTIFY_QUEUE_SIZE", 100))
+		for i := 0; i < workers; i++ {
+			go func() {
+				for job := range s.jobs {
+					_ = s.deliver(job.ctx, job.ch, job.n)
+					s.pending.Done()
+				}
+			}()
+		}
+	})
+}
+
+func (s *notifyService) enqueue(ctx *Context, ch NotifyChannel, n *Notification) error {
+	s.start()
+	// 请求结束后ctx会被取消，异步投递只保留trace_id等值
+	job := &notifyJob{ctx: ctx.detached(), ch: ch, n: n}
+	s.pending.Add(1)
+	select {
+	case s.jobs <- job:
+		return nil
+	default:
+		s.pending.Done()
+		return Err(ctx).Code("E_NOTIFY_QUEUE_FULL").Msg("通知队列已满").
+			Detail(fmt.Sprintf("队列长度: %d", cap(s.jobs))).Retry().Build()
+	}
+}
+
+// deliver 发送并按指数退避重试，ctx被取消时停止重试
+func (s *notifyService) deliver(ctx *Context, ch NotifyChannel, n *Notification) error {
+	retries := getEnvIntOrDefault("NOTIFY_MAX_RETRIES", 3)
+	backoff := time.Duration(getEnvIntOrDefault("NOTIFY_RETRY_BACKOFF_MS", 1000)) * time.Millisecond
+	var err error
+	for attempt := 0; ; attempt++ {
+		sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err = ch.Send(sendCtx, n)
+		cancel()
+		if err == nil {
+			logger.Infof(ctx, "通知发送成功 [%s] id:%s to:%v", n.Channel, n.ID, n.To)
+			return nil
+		}
+		if attempt >= retries {
+			break
+		}
+		logger.Warnf(ctx, "通知发送失败 [%s] id:%s 第%d次重试: %v", n.Channel, n.ID, attempt+1, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff << attempt):
+		}
+	}
+	logger.Errorf(ctx, "通知发送失败 [%s] id:%s to:%v 已重试%d次: %v", n.Channel, n.ID, n.To, retries, err)
+	return err
+}
+
+// flushNotifications 关闭前等待队列中的通知发送完成，最多等到deadline
+func flushNotifications(ctx context.Context, deadline time.Time) {
+	done := make(chan struct{})
+	go func() {
+		notifier.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Until(deadline)):
+		logger.Warnf(ctx, "等待通知发送超时，未发送的通知将丢失")
+	}
+}
+
+// ===== 内置渠道 =====
+
+// SMTPChannel SMTP邮件
+type SMTPChannel struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (c *SMTPChannel) Name() string { return NotifyChannelEmail }
+
+func (c *SMTPChannel) Send(ctx context.Context, n *Notification) error {
+	if len(n.To) == 0 {
+		return fmt.Errorf("没有收件人")
+	}
+	contentType := "text/plain"
+	if n.HTML {
+		contentType = "text/html"
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", c.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", n.Subject))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\nContent-Type: %s; charset=UTF-8\r\n\r\n", contentType)
+	msg.WriteString(n.Body)
+
+	// smtp.SendMail 不支持ctx，服务器不响应时会一直阻塞，这里自己拨号并在ctx结束时断开连接
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(c.Host, strconv.Itoa(c.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	if err := c.sendMail(conn, n.To, msg.Bytes()); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("发送邮件超时: %w", ctx.Err())
+		}
+		// 连接的deadline可能比ctx的计时器先到
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("发送邮件超时: %w", context.DeadlineExceeded)
+		}
+		return err
+	}
+	return nil
+}
+
+// sendMail 和 smtp.SendMail 的流程一致：支持时先STARTTLS，配置了用户名时认证
+func (c *SMTPChannel) sendMail(conn net.Conn, to []string, msg []byte) error {
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return err
+		}
+	}
+	if c.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("SMTP服务器不支持认证")
+		}
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// WebhookChannel 把通知以JSON POST到指定地址，返回非2xx时重试
+type WebhookChannel struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+func (c *WebhookChannel) Name() string { return NotifyChannelWebhook }
+
+func (c *WebhookChannel) Send(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook返回 %d", res.StatusCode)
+	}
+	return nil
+}
+
+// NatsChannel 平台站内信，把通知JSON发布到NATS主题，由平台投递给用户
+type NatsChannel struct {
+	Subject string
+	Conn    func() *nats.Conn // 为空时使用当前runner的连接
+}
+
+func (c *NatsChannel) Name() string { return NotifyChannelPlatform }
+
+func (c *NatsChannel) Send(ctx context.Context, n *Notification) error {
+	var conn *nats.Conn
+	if c.Conn != nil {
+		conn = c.Conn()
+	} else if r != nil {
+		conn = r.conn()
+	}
+	if conn == nil {
+		return fmt.Errorf("NATS未连接")
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return conn.Publish(c.Subject, data)
+}
+
+// FileChannel 追加JSON行到文件
+type FileChannel struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (c *FileChannel) Name() string { return NotifyChannelFile }
+
+func (c *FileChannel) Send(ctx context.Context, n *Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// MemoryChannel 保存在内存中，测试时注册后通过 Sent() 检查发出的通知
+type MemoryChannel struct {
+	ChannelName string // 为空时为 memory
+	mu          sync.Mutex
+	sent        []*Notification
+}
+
+func (c *MemoryChannel) Name() string {
+	if c.ChannelName == "" {
+		return "memory"
+	}
+	return c.ChannelName
+}
+
+func (c *MemoryChannel) Send(ctx context.Context, n *Notification) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, n)
+	return nil
+}
 
-	return &IMsgServices{}
+// Sent 已经发送的通知
+func (c *MemoryChannel) Sent() []*Notification {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Notification(nil), c.sent...)
 }
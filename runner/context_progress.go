@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/yunhanshu-net/pkg/constants"
+	"github.com/yunhanshu-net/pkg/logger"
+	"github.com/yunhanshu-net/pkg/x/jsonx"
+)
+
+// ProgressEvent 函数执行进度事件
+type ProgressEvent struct {
+	TraceID string `json:"trace_id"`
+	TaskID  string `json:"task_id,omitempty"` //异步任务才有
+	Router  string `json:"router"`
+	Method  string `json:"method"`
+	Percent int    `json:"percent"` //0-100
+	Message string `json:"message"`
+	Ts      int64  `json:"ts"` //毫秒时间戳
+}
+
+// progressSink 进度事件的投递方式：run命令输出<Progress>标签，HTTP的SSE请求输出progress事件
+type progressSink func(event *ProgressEvent) error
+
+// cliProgressSink run命令模式下每个进度事件输出一个<Progress>标签
+func cliProgressSink(event *ProgressEvent) error {
+	fmt.Println("<Progress>" + jsonx.String(event) + "</Progress>")
+	return nil
+}
+
+// progressSubject 进度事件的NATS主题，按trace_id区分，客户端可以只订阅自己关心的请求
+func progressSubject(traceID string) string {
+	return fmt.Sprintf("function-runner.progress.%s", traceID)
+}
+
+// Progress 上报函数执行进度，例如：ctx.Progress(40, "正在解析第3个sheet")
+// connect模式下通过NATS推送进度事件，run命令模式下输出<Progress>标签，serve/dev模式只有SSE请求会收到progress事件，
+// 其他HTTP请求丢弃进度，不会输出到服务的标准输出；异步任务同时会更新任务状态里的进度
+func (c *Context) Progress(percent int, message string) {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	event := &ProgressEvent{
+		TraceID: c.getTraceId(),
+		TaskID:  c.asyncTaskID,
+		Router:  c.router,
+		Method:  c.method,
+		Percent: percent,
+		Message: message,
+		Ts:      time.Now().UnixMilli(),
+	}
+
+	if c.asyncTaskID != "" {
+		updateAsyncTask(c, c.asyncTaskID, map[string]interface{}{
+			"progress":     percent,
+			"progress_msg": message,
+		})
+	}
+
+	if c.progressSink != nil {
+		if err := c.progressSink(event); err != nil {
+			logger.Warnf(c, "推送进度事件失败: %v", err)
+		}
+		return
+	}
+	if c.runner == nil || c.runner.conn() == nil {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorf(c, "进度事件序列化失败: %v", err)
+		return
+	}
+	msg := nats.NewMsg(progressSubject(event.TraceID))
+	msg.Header.Set(constants.TraceID, event.TraceID)
+	msg.Data = data
+	if err := c.runner.publish(msg); err != nil {
+		logger.Warnf(c, "推送进度事件失败: %v", err)
+	}
+}
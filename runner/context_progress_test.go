@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+)
+
+// captureStdout 执行fn期间的标准输出
+func captureStdout(t *testing.T, fn func()) string {
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = wr
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(rd)
+		out <- string(data)
+	}()
+	fn()
+	wr.Close()
+	return <-out
+}
+
+func TestProgressSink(t *testing.T) {
+	ctx := NewContext(context.Background(), "POST", "/progress", nil)
+	var events []*ProgressEvent
+	ctx.progressSink = func(event *ProgressEvent) error {
+		events = append(events, event)
+		return nil
+	}
+	ctx.Progress(-1, "开始")
+	ctx.Progress(150, "完成")
+	if len(events) != 2 || events[0].Percent != 0 || events[1].Percent != 100 || events[1].Router != "/progress" {
+		t.Fatalf("进度应该限制在0-100: %+v", events)
+	}
+
+	out := captureStdout(t, func() {
+		NewContext(context.Background(), "POST", "/progress", nil).Progress(10, "没有投递方式")
+	})
+	if strings.Contains(out, "<Progress>") {
+		t.Fatalf("没有投递方式时不应该输出到标准输出: %s", out)
+	}
+	out = captureStdout(t, func() { _ = cliProgressSink(events[0]) })
+	if !strings.HasPrefix(out, "<Progress>") || !strings.Contains(out, `"percent":0`) {
+		t.Fatalf("run命令应该输出<Progress>标签: %s", out)
+	}
+}
+
+func TestProgressOverHTTP(t *testing.T) {
+	r := newTestRunner()
+	router := fmt.Sprintf("/progress_%d", time.Now().UnixNano())
+	r.post(router, func(ctx *Context, req *serveReq, resp response.Response) error {
+		ctx.Progress(50, "处理中")
+		return resp.Form(map[string]string{"hello": req.Name}).Build()
+	}, &FormFunctionOptions{})
+	srv := httptest.NewServer(r.httpHandler())
+	defer srv.Close()
+
+	var body []byte
+	out := captureStdout(t, func() {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+router, strings.NewReader(`{"name":"go"}`))
+		req.Header.Set("Accept", "text/event-stream")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		body, _ = io.ReadAll(res.Body)
+		res.Body.Close()
+
+		res, err = http.Post(srv.URL+router, "application/json", strings.NewReader(`{"name":"go"}`))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("普通请求应该正常返回: %d", res.StatusCode)
+		}
+	})
+	if !strings.Contains(string(body), "event: progress") || !strings.Contains(string(body), `"percent":50`) ||
+		!strings.Contains(string(body), "event: response") {
+		t.Fatalf("SSE请求应该收到进度事件: %s", body)
+	}
+	if strings.Contains(out, "<Progress>") {
+		t.Fatalf("HTTP模式不应该把进度输出到服务的标准输出: %s", out)
+	}
+}
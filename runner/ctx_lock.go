@@ -1,7 +1,14 @@
 package runner
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/yunhanshu-net/function-go/env"
+	"github.com/yunhanshu-net/pkg/logger"
 )
 
 type Locker interface {
@@ -9,22 +16,144 @@ type Locker interface {
 	// Lock 尝试加锁，返回是否加锁成功，非阻塞
 	Lock(key string, ttl ...time.Duration) bool
 
+	// LockWait 阻塞加锁，在timeout时间内一直尝试，超时仍未拿到锁返回false
+	LockWait(key string, timeout time.Duration, ttl ...time.Duration) bool
+
 	// Unlock 删除锁，返回是否删除成功
 	Unlock(key string) bool
 }
 
+// LockBackend 分布式锁的存储后端
+// owner是持锁者的唯一标识，只有持锁者自己才能释放锁；ttl<=0表示锁不会自动过期
+type LockBackend interface {
+	// TryAcquire 尝试获取锁，锁被别人持有且未过期时返回false；同一个owner重复获取会刷新过期时间
+	TryAcquire(key string, owner string, ttl time.Duration) (bool, error)
+
+	// Release 释放锁，锁不存在或者不属于owner时返回false
+	Release(key string, owner string) (bool, error)
+}
+
+const (
+	LockBackendSQLite = "sqlite" //单机使用，基于runner的SQLite数据库，多个进程共享数据目录时也能互斥
+	LockBackendNats   = "nats"   //多实例使用，基于NATS JetStream KV
+)
+
+var (
+	lockBackendMux = new(sync.Mutex)
+	lockBackend    LockBackend
+)
+
+// SetLockBackend 指定分布式锁的后端，不指定时根据环境变量 LOCK_BACKEND（sqlite/nats，默认sqlite）自动选择
+func SetLockBackend(backend LockBackend) {
+	lockBackendMux.Lock()
+	defer lockBackendMux.Unlock()
+	lockBackend = backend
+}
+
+func getLockBackend() (LockBackend, error) {
+	lockBackendMux.Lock()
+	defer lockBackendMux.Unlock()
+	if lockBackend != nil {
+		return lockBackend, nil
+	}
+
+	var backend LockBackend
+	var err error
+	switch kind := getEnvOrDefault("LOCK_BACKEND", LockBackendSQLite); kind {
+	case LockBackendSQLite:
+		backend, err = NewSQLiteLockBackend(mustGetOrInitDB(fmt.Sprintf("%s_%s.db", env.User, env.Name)))
+	case LockBackendNats:
+		// 复用runner的NATS连接，没有连接时报错，不单独再建连接
+		if r == nil || r.conn() == nil {
+			return nil, fmt.Errorf("LOCK_BACKEND=%s 需要runner已经连接NATS", kind)
+		}
+		backend, err = NewNatsLockBackend(r.conn(), fmt.Sprintf("locks_%s_%s", env.User, env.Name))
+	default:
+		err = fmt.Errorf("不支持的锁后端: %s", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	lockBackend = backend
+	return lockBackend, nil
+}
+
+// Lock 分布式锁，每个请求的Context持有一个Lock，owner在同一个Lock内保持不变
+// 用法：
+//
+//	if ctx.Locker.Lock("stock:"+sku, time.Second*5) { //time.Second*5表示过期时间，如果不填表示无过期时间
+//		defer ctx.Locker.Unlock("stock:" + sku)
+//	}
 type Lock struct {
+	owner   string
+	backend LockBackend //为空时使用全局后端
 }
 
 func newLock() *Lock {
-	return &Lock{}
+	return &Lock{owner: uuid.NewString()}
+}
+
+func (l *Lock) getBackend() (LockBackend, error) {
+	if l.backend != nil {
+		return l.backend, nil
+	}
+	return getLockBackend()
+}
+
+// Owner 当前持锁者标识
+func (l *Lock) Owner() string {
+	return l.owner
 }
 
 func (l *Lock) Lock(key string, ttl ...time.Duration) bool {
-	//todo待实现
-	return true
+	backend, err := l.getBackend()
+	if err != nil {
+		logger.Errorf(context.Background(), "获取锁后端失败 key:%s err:%v", key, err)
+		return false
+	}
+	var expire time.Duration
+	if len(ttl) > 0 {
+		expire = ttl[0]
+	}
+	ok, err := backend.TryAcquire(key, l.owner, expire)
+	if err != nil {
+		logger.Errorf(context.Background(), "加锁失败 key:%s err:%v", key, err)
+		return false
+	}
+	return ok
 }
+
+func (l *Lock) LockWait(key string, timeout time.Duration, ttl ...time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	interval := 10 * time.Millisecond
+	for {
+		if l.Lock(key, ttl...) {
+			return true
+		}
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return false
+		}
+		if interval > remain {
+			interval = remain
+		}
+		time.Sleep(interval)
+		if interval < 200*time.Millisecond {
+			interval *= 2
+		}
+	}
+}
+
 func (l *Lock) Unlock(key string) bool {
-	//todo待实现
-	return true
+	backend, err := l.getBackend()
+	if err != nil {
+		logger.Errorf(context.Background(), "获取锁后端失败 key:%s err:%v", key, err)
+		return false
+	}
+	ok, err := backend.Release(key, l.owner)
+	if err != nil {
+		logger.Errorf(context.Background(), "释放锁失败 key:%s err:%v", key, err)
+		return false
+	}
+	return ok
 }
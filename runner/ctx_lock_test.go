@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openLockTestBackend(t *testing.T, path string) *SQLiteLockBackend {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	db.Exec("PRAGMA journal_mode=WAL;")
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("获取原生数据库连接失败: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	backend, err := NewSQLiteLockBackend(db)
+	if err != nil {
+		t.Fatalf("创建锁后端失败: %v", err)
+	}
+	return backend
+}
+
+func newTestLock(owner string, backend LockBackend) *Lock {
+	return &Lock{owner: owner, backend: backend}
+}
+
+func TestSQLiteLockMutualExclusionAcrossGoroutines(t *testing.T) {
+	backend := openLockTestBackend(t, filepath.Join(t.TempDir(), "lock.db"))
+
+	var holders, maxHolders, total int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l := newTestLock(fmt.Sprintf("owner-%d", i), backend)
+			for j := 0; j < 5; j++ {
+				if !l.LockWait("stock:sku-1", 10*time.Second, 5*time.Second) {
+					t.Errorf("owner-%d 等待加锁超时", i)
+					return
+				}
+				n := atomic.AddInt32(&holders, 1)
+				for {
+					m := atomic.LoadInt32(&maxHolders)
+					if n <= m || atomic.CompareAndSwapInt32(&maxHolders, m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&total, 1)
+				atomic.AddInt32(&holders, -1)
+				if !l.Unlock("stock:sku-1") {
+					t.Errorf("owner-%d 释放锁失败", i)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if maxHolders != 1 {
+		t.Fatalf("同一时刻最多应该只有1个持锁者，实际: %d", maxHolders)
+	}
+	if total != 50 {
+		t.Fatalf("期望完成50次加锁，实际: %d", total)
+	}
+}
+
+func TestSQLiteLockOwnerAndTTL(t *testing.T) {
+	backend := openLockTestBackend(t, filepath.Join(t.TempDir(), "lock.db"))
+	a := newTestLock("a", backend)
+	b := newTestLock("b", backend)
+
+	if !a.Lock("order:1", 100*time.Millisecond) {
+		t.Fatal("a 应该加锁成功")
+	}
+	if b.Lock("order:1") {
+		t.Fatal("锁未过期时 b 不应该加锁成功")
+	}
+	if b.Unlock("order:1") {
+		t.Fatal("b 不是持锁者，不应该释放成功")
+	}
+	if !a.Lock("order:1", 100*time.Millisecond) {
+		t.Fatal("a 重复加锁应该刷新过期时间")
+	}
+
+	if !b.LockWait("order:1", time.Second) {
+		t.Fatal("锁过期后 b 应该加锁成功")
+	}
+	if a.Unlock("order:1") {
+		t.Fatal("锁已经被 b 持有，a 不应该释放成功")
+	}
+	if !b.Unlock("order:1") {
+		t.Fatal("b 应该释放成功")
+	}
+	if b.Unlock("order:1") {
+		t.Fatal("重复释放应该返回false")
+	}
+
+	if !a.Lock("order:2") {
+		t.Fatal("a 应该加锁成功")
+	}
+	if b.LockWait("order:2", 50*time.Millisecond) {
+		t.Fatal("不过期的锁被持有时 b 应该等待超时")
+	}
+}
+
+// TestLockHelperProcess 被 TestSQLiteLockAcrossProcesses 以子进程方式调用，单独运行时跳过
+func TestLockHelperProcess(t *testing.T) {
+	path := os.Getenv("LOCK_HELPER_DB")
+	if path == "" {
+		t.Skip("仅作为子进程运行")
+	}
+	l := newTestLock(os.Getenv("LOCK_HELPER_OWNER"), openLockTestBackend(t, path))
+	if l.Lock(os.Getenv("LOCK_HELPER_KEY")) {
+		fmt.Println("<LockResult>acquired</LockResult>")
+	} else {
+		fmt.Println("<LockResult>busy</LockResult>")
+	}
+}
+
+func runLockHelper(t *testing.T, path, owner, key string) string {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$", "-test.v")
+	cmd.Env = append(os.Environ(), "LOCK_HELPER_DB="+path, "LOCK_HELPER_OWNER="+owner, "LOCK_HELPER_KEY="+key)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("子进程执行失败: %v\n%s", err, out)
+	}
+	switch {
+	case strings.Contains(string(out), "<LockResult>acquired</LockResult>"):
+		return "acquired"
+	case strings.Contains(string(out), "<LockResult>busy</LockResult>"):
+		return "busy"
+	}
+	t.Fatalf("子进程输出异常:\n%s", out)
+	return ""
+}
+
+func TestSQLiteLockAcrossProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock.db")
+	parent := newTestLock("parent", openLockTestBackend(t, path))
+
+	if !parent.Lock("report:daily") {
+		t.Fatal("父进程应该加锁成功")
+	}
+	if got := runLockHelper(t, path, "child-1", "report:daily"); got != "busy" {
+		t.Fatalf("父进程持锁时子进程不应该加锁成功，实际: %s", got)
+	}
+	if !parent.Unlock("report:daily") {
+		t.Fatal("父进程应该释放成功")
+	}
+	if got := runLockHelper(t, path, "child-2", "report:daily"); got != "acquired" {
+		t.Fatalf("锁释放后子进程应该加锁成功，实际: %s", got)
+	}
+	if parent.Lock("report:daily") {
+		t.Fatal("子进程持有的锁不过期，父进程不应该加锁成功")
+	}
+}
@@ -3,15 +3,11 @@ package runner
 import (
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 )
 
 var (
@@ -19,82 +15,88 @@ var (
 	dbs    = make(map[string]*gorm.DB)
 )
 
-// mustGetOrInitDB 获取或初始化数据库连接
-// 如果数据库不存在，会自动创建
-func mustGetOrInitDB(dbName string) *gorm.DB {
-	dbLock.Lock()
-	defer dbLock.Unlock()
+const (
+	dataDir       = "../data"
+	DefaultDBName = "default" // runner默认的数据库 ../data/<user>_<name>.db
+)
 
-	// 安全处理数据库名称，防止目录穿越攻击
-	dbName = sanitizeDBName(dbName)
+// DBNamer 模型实现这个接口时，CreateTables 和 AutoCrud 会使用对应名称的数据库，而不是默认数据库
+type DBNamer interface {
+	DBName() string
+}
 
-	// 检查缓存是否已存在连接
-	if db, ok := dbs[dbName]; ok {
-		return db
-	}
+var namedDBConfigs = make(map[string]*DBConfig)
 
-	// 确保数据目录存在
-	dataDir := "../data"
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		logrus.Errorf("创建数据目录失败: %v", err)
-		panic(fmt.Errorf("创建数据目录失败: %v", err))
+// RegisterDB 注册一个命名数据库，之后可以通过 ctx.DB(name) 获取，例如：
+//
+//	runner.RegisterDB("archive", &runner.DBConfig{DSN: "../data/archive.db"})
+//	runner.RegisterDB("replica", &runner.DBConfig{DSN: "../data/replica.db", ReadOnly: true})
+//	runner.RegisterDB("test", &runner.DBConfig{DSN: ":memory:"})
+func RegisterDB(name string, cfg *DBConfig) error {
+	if name == "" || name == DefaultDBName {
+		return fmt.Errorf("数据库名称不能为空或者为 %s", DefaultDBName)
 	}
-	file, err := os.OpenFile(dataDir+"/gorm.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		panic(err)
+	if cfg == nil || cfg.DSN == "" {
+		return fmt.Errorf("数据库 %s 的DSN不能为空", name)
+	}
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	if _, ok := dbs[namedDBKey(name)]; ok {
+		return fmt.Errorf("数据库 %s 已经打开，不能重复注册", name)
 	}
+	namedDBConfigs[name] = cfg
+	return nil
+}
 
-	newLogger := logger.New(
-		log.New(file, "\r\n", log.LstdFlags), // 将日志写入文件
-		logger.Config{
-			SlowThreshold: time.Second,
-			LogLevel:      logger.Info,
-			Colorful:      false,
-		},
-	)
-	//// 设置GORM日志配置
-	//gormLogger := logger.New(
-	//	log.New(os.Stdout, "\r\n", log.LstdFlags),
-	//	logger.Config{
-	//		SlowThreshold:             200 * time.Millisecond,
-	//		LogLevel:                  logger.Warn,
-	//		IgnoreRecordNotFoundError: true,
-	//		Colorful:                  false,
-	//	},
-	//)
-
-	// 创建数据库连接
-	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
-		Logger: newLogger,
-	})
+func namedDBKey(name string) string {
+	return "db:" + name
+}
 
-	if err != nil {
-		logrus.Errorf("打开数据库失败 %s: %v", dbName, err)
-		panic(fmt.Errorf("打开数据库失败 %s: %v", dbName, err))
+// defaultDBConfig 默认数据库的配置，可以通过环境变量调整：
+// DB_DRIVER 驱动（默认sqlite），DB_DSN 连接串（默认 ../data/<user>_<name>.db），DB_MAX_OPEN_CONNS，DB_MAX_IDLE_CONNS
+func defaultDBConfig(path string) *DBConfig {
+	return &DBConfig{
+		Driver:       getEnvOrDefault("DB_DRIVER", DBDriverSQLite),
+		DSN:          getEnvOrDefault("DB_DSN", path),
+		MaxOpenConns: getEnvIntOrDefault("DB_MAX_OPEN_CONNS", 5),
+		MaxIdleConns: getEnvIntOrDefault("DB_MAX_IDLE_CONNS", 2),
 	}
+}
 
-	// 设置SQLite优化参数
-	db.Exec("PRAGMA journal_mode=WAL;PRAGMA temp_store=MEMORY;PRAGMA synchronous=NORMAL;")
-
-	// 设置连接池参数
-	sqlDB, err := db.DB()
+// getOrOpenDB 从缓存获取连接，没有则按配置打开，调用方需要持有dbLock
+func getOrOpenDB(key string, cfg *DBConfig) (*gorm.DB, error) {
+	if db, ok := dbs[key]; ok {
+		return db, nil
+	}
+	if cfg.withDefaults().Driver == DBDriverSQLite && !isSQLiteMemory(cfg.DSN) {
+		// 确保数据目录存在
+		if err := os.MkdirAll(filepath.Dir(strings.TrimPrefix(cfg.DSN, "file:")), 0755); err != nil {
+			logrus.Errorf("创建数据目录失败: %v", err)
+			return nil, fmt.Errorf("创建数据目录失败: %v", err)
+		}
+	}
+	db, err := openDB(cfg)
 	if err != nil {
-		logrus.Errorf("获取原生数据库连接失败: %v", err)
-		panic(fmt.Errorf("获取原生数据库连接失败: %v", err))
+		logrus.Errorf("打开数据库失败 %s: %v", key, err)
+		return nil, fmt.Errorf("打开数据库失败 %s: %v", key, err)
 	}
-
-	sqlDB.SetMaxOpenConns(5)            // 增加连接数，支持多协程
-	sqlDB.SetMaxIdleConns(2)            // 保持一些空闲连接
-	sqlDB.SetConnMaxLifetime(time.Hour) // 连接最长生命周期
-
 	// 缓存连接
-	dbs[dbName] = db
-	logrus.Infof("数据库连接已创建: %s", dbName)
+	dbs[key] = db
+	logrus.Infof("数据库连接已创建: %s", key)
+	return db, nil
+}
 
+// mustGetOrInitDB 获取或初始化runner默认的数据库连接
+// 如果数据库不存在，会自动创建
+func mustGetOrInitDB(dbName string) *gorm.DB {
+	db, err := getOrInitDB(dbName)
+	if err != nil {
+		panic(err)
+	}
 	return db
 }
 
-// getOrInitDB 获取或初始化数据库连接
+// getOrInitDB 获取或初始化runner默认的数据库连接
 // 如果数据库不存在，会自动创建
 func getOrInitDB(dbName string) (*gorm.DB, error) {
 	dbLock.Lock()
@@ -102,59 +104,18 @@ func getOrInitDB(dbName string) (*gorm.DB, error) {
 
 	// 安全处理数据库名称，防止目录穿越攻击
 	dbName = sanitizeDBName(dbName)
+	return getOrOpenDB(dbName, defaultDBConfig(dbName))
+}
 
-	// 检查缓存是否已存在连接
-	if db, ok := dbs[dbName]; ok {
-		return db, nil
-	}
-
-	// 确保数据目录存在
-	dataDir := "../data"
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		logrus.Errorf("创建数据目录失败: %v", err)
-		return nil, err
-	}
-
-	// 设置GORM日志配置
-	gormLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags),
-		logger.Config{
-			SlowThreshold:             200 * time.Millisecond,
-			LogLevel:                  logger.Warn,
-			IgnoreRecordNotFoundError: true,
-			Colorful:                  false,
-		},
-	)
-
-	// 创建数据库连接
-	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{
-		Logger: gormLogger,
-	})
-
-	if err != nil {
-		logrus.Errorf("打开数据库失败 %s: %v", dbName, err)
-		return nil, err
-	}
-
-	// 设置SQLite优化参数
-	db.Exec("PRAGMA journal_mode=WAL;PRAGMA temp_store=MEMORY;PRAGMA synchronous=NORMAL;")
-
-	// 设置连接池参数
-	sqlDB, err := db.DB()
-	if err != nil {
-		logrus.Errorf("获取原生数据库连接失败: %v", err)
-		return nil, err
+// getNamedDB 获取 RegisterDB 注册的数据库
+func getNamedDB(name string) (*gorm.DB, error) {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	cfg, ok := namedDBConfigs[name]
+	if !ok {
+		return nil, fmt.Errorf("数据库 %s 未注册，请先调用 runner.RegisterDB", name)
 	}
-
-	sqlDB.SetMaxOpenConns(5)            // 增加连接数，支持多协程
-	sqlDB.SetMaxIdleConns(2)            // 保持一些空闲连接
-	sqlDB.SetConnMaxLifetime(time.Hour) // 连接最长生命周期
-
-	// 缓存连接
-	dbs[dbName] = db
-	logrus.Infof("数据库连接已创建: %s", dbName)
-
-	return db, nil
+	return getOrOpenDB(namedDBKey(name), cfg)
 }
 
 // sanitizeDBName 安全处理数据库名称，防止目录穿越
@@ -171,7 +132,32 @@ func sanitizeDBName(dbName string) string {
 		dbName = dbName + ".db"
 	}
 
-	return "../data/" + dbName
+	return dataDir + "/" + dbName
+}
+
+// DB 获取指定名称的数据库，name为空或者 default 时是runner默认的数据库，打开失败会panic
+func (c *Context) DB(name string) *gorm.DB {
+	db, err := c.GetDB(name)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// GetDB 获取指定名称的数据库，name为空或者 default 时是runner默认的数据库
+func (c *Context) GetDB(name string) (*gorm.DB, error) {
+	if name == "" || name == DefaultDBName {
+		return c.GetOrInitDB()
+	}
+	return getNamedDB(name)
+}
+
+// dbFor 获取模型所在的数据库，模型实现了 DBNamer 时使用对应的命名数据库
+func (c *Context) dbFor(model interface{}) *gorm.DB {
+	if namer, ok := model.(DBNamer); ok {
+		return c.DB(namer.DBName())
+	}
+	return c.MustGetOrInitDB()
 }
 
 // Context的mustGetOrInitDB方法
@@ -0,0 +1,152 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DBConfig 数据库连接配置
+type DBConfig struct {
+	Driver          string        // 驱动，对应 RegisterDBProvider 注册的名称，默认 sqlite
+	DSN             string        // 连接串，sqlite 是文件路径，":memory:" 表示内存库
+	ReadOnly        bool          // 只读连接，例如只读的副本库
+	MaxOpenConns    int           // 最大连接数，默认5
+	MaxIdleConns    int           // 最大空闲连接数，默认2
+	ConnMaxLifetime time.Duration // 连接最长生命周期，默认1小时
+}
+
+func (c *DBConfig) withDefaults() *DBConfig {
+	cfg := *c
+	if cfg.Driver == "" {
+		cfg.Driver = DBDriverSQLite
+	}
+	if cfg.MaxOpenConns <= 0 {
+		cfg.MaxOpenConns = 5
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = 2
+	}
+	if cfg.ConnMaxLifetime <= 0 {
+		cfg.ConnMaxLifetime = time.Hour
+	}
+	return &cfg
+}
+
+// DBProvider 数据库驱动，负责根据配置打开连接，连接的缓存和关闭由runner统一管理
+type DBProvider interface {
+	Open(cfg *DBConfig, gormLogger logger.Interface) (*gorm.DB, error)
+}
+
+const DBDriverSQLite = "sqlite"
+
+var (
+	dbProviderLock = new(sync.RWMutex)
+	dbProviders    = map[string]DBProvider{DBDriverSQLite: &SQLiteProvider{}}
+)
+
+// RegisterDBProvider 注册数据库驱动，默认数据库的驱动通过环境变量 DB_DRIVER 选择
+func RegisterDBProvider(driver string, provider DBProvider) {
+	dbProviderLock.Lock()
+	defer dbProviderLock.Unlock()
+	dbProviders[driver] = provider
+}
+
+func getDBProvider(driver string) (DBProvider, error) {
+	dbProviderLock.RLock()
+	defer dbProviderLock.RUnlock()
+	provider, ok := dbProviders[driver]
+	if !ok {
+		return nil, fmt.Errorf("不支持的数据库驱动: %s", driver)
+	}
+	return provider, nil
+}
+
+// SQLiteProvider 默认的SQLite驱动
+type SQLiteProvider struct{}
+
+func (p *SQLiteProvider) Open(cfg *DBConfig, gormLogger logger.Interface) (*gorm.DB, error) {
+	dsn := cfg.DSN
+	memory := isSQLiteMemory(dsn)
+	if cfg.ReadOnly && !memory {
+		if strings.Contains(dsn, "?") {
+			dsn += "&mode=ro"
+		} else {
+			dsn = "file:" + dsn + "?mode=ro"
+		}
+	}
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: gormLogger})
+	if err != nil {
+		return nil, err
+	}
+	if !memory && !cfg.ReadOnly {
+		// 设置SQLite优化参数
+		db.Exec("PRAGMA journal_mode=WAL;PRAGMA temp_store=MEMORY;PRAGMA synchronous=NORMAL;")
+	}
+	if memory {
+		// 内存库每个连接都是一个独立的库，只保留一个连接
+		cfg.MaxOpenConns = 1
+		cfg.MaxIdleConns = 1
+		cfg.ConnMaxLifetime = 0
+	}
+	return db, nil
+}
+
+func isSQLiteMemory(dsn string) bool {
+	return dsn == ":memory:" || strings.Contains(dsn, "mode=memory")
+}
+
+var (
+	gormLoggerOnce sync.Once
+	gormLogger     logger.Interface
+)
+
+// getGormLogger 所有数据库共用一个写到 ../data/gorm.log 的日志
+func getGormLogger() logger.Interface {
+	gormLoggerOnce.Do(func() {
+		var w *os.File = os.Stdout
+		if err := os.MkdirAll(dataDir, 0755); err == nil {
+			if file, err := os.OpenFile(dataDir+"/gorm.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666); err == nil {
+				w = file
+			}
+		}
+		gormLogger = logger.New(
+			log.New(w, "\r\n", log.LstdFlags),
+			logger.Config{
+				SlowThreshold: time.Second,
+				LogLevel:      logger.Info,
+				Colorful:      false,
+			},
+		)
+	})
+	return gormLogger
+}
+
+// openDB 按配置打开连接并设置连接池
+func openDB(cfg *DBConfig) (*gorm.DB, error) {
+	cfg = cfg.withDefaults()
+	provider, err := getDBProvider(cfg.Driver)
+	if err != nil {
+		return nil, err
+	}
+	db, err := provider.Open(cfg, getGormLogger())
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("获取原生数据库连接失败: %w", err)
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	return db, nil
+}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+type archiveRecord struct {
+	ID   int `gorm:"primaryKey"`
+	Name string
+}
+
+func (archiveRecord) DBName() string {
+	return "archive_test"
+}
+
+func TestNamedDBs(t *testing.T) {
+	t.Cleanup(CloseAllDBs)
+	dir := t.TempDir()
+	if err := RegisterDB("archive_test", &DBConfig{DSN: filepath.Join(dir, "archive.db")}); err != nil {
+		t.Fatalf("注册数据库失败: %v", err)
+	}
+	if err := RegisterDB("replica_test", &DBConfig{DSN: filepath.Join(dir, "archive.db"), ReadOnly: true}); err != nil {
+		t.Fatalf("注册数据库失败: %v", err)
+	}
+	if err := RegisterDB("memory_test", &DBConfig{DSN: ":memory:"}); err != nil {
+		t.Fatalf("注册数据库失败: %v", err)
+	}
+	if err := RegisterDB(DefaultDBName, &DBConfig{DSN: ":memory:"}); err == nil {
+		t.Fatal("不应该允许注册默认数据库")
+	}
+
+	ctx := &Context{Context: context.Background()}
+	if _, err := ctx.GetDB("not_registered"); err == nil {
+		t.Fatal("未注册的数据库应该返回错误")
+	}
+
+	archive := ctx.dbFor(&archiveRecord{})
+	if archive != ctx.DB("archive_test") {
+		t.Fatal("实现了DBNamer的模型应该使用对应的数据库")
+	}
+	if err := archive.AutoMigrate(&archiveRecord{}); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	if err := archive.Create(&archiveRecord{ID: 1, Name: "a"}).Error; err != nil {
+		t.Fatalf("写入失败: %v", err)
+	}
+
+	replica := ctx.DB("replica_test")
+	var got archiveRecord
+	if err := replica.First(&got, 1).Error; err != nil || got.Name != "a" {
+		t.Fatalf("只读副本应该能读到数据: %v %+v", err, got)
+	}
+	if err := replica.Create(&archiveRecord{ID: 2}).Error; err == nil {
+		t.Fatal("只读副本不应该允许写入")
+	}
+
+	memory := ctx.DB("memory_test")
+	if err := memory.AutoMigrate(&archiveRecord{}); err != nil {
+		t.Fatalf("内存库建表失败: %v", err)
+	}
+	if err := memory.Create(&archiveRecord{ID: 1}).Error; err != nil {
+		t.Fatalf("内存库写入失败: %v", err)
+	}
+	var count int64
+	if err := ctx.DB("memory_test").Model(&archiveRecord{}).Count(&count).Error; err != nil || count != 1 {
+		t.Fatalf("内存库的数据应该在多次获取之间保持: %v %d", err, count)
+	}
+
+	CloseAllDBs()
+	if err := RegisterDB("memory_test", &DBConfig{DSN: ":memory:"}); err != nil {
+		t.Fatalf("关闭连接之后应该可以重新注册: %v", err)
+	}
+}
@@ -16,16 +16,22 @@ import (
 )
 
 func (r *Runner) registerBuiltInRouters() {
-	r.get("/_env", _env)
+	r.get("/_env", r._env)
 	r.get("/_help", r.help)
 	r.get("/_ping", ping)
+	r.get("/_metrics", r._metrics)
 	r.get("/_getApiInfos", r._getApiInfos)
 	r.get("/_getApiInfo", r._getApiInfo)
 	r.post("/_callback", r._callback)
+	r.get("/_task_status", r._taskStatus)
+	r.get("/_task_result", r._taskResult)
+	r.get("/_migrations", r._migrations)
+	r.post("/_migrate", r._migrate)
+	r.post("/_migrate_rollback", r._migrateRollback)
 	//r.post("/_syscall", r._syscall)
 }
-func _env(ctx *Context, req *request.NoData, resp response.Response) error {
-	return resp.Form(map[string]string{"version": "1.0", "lang": "go"}).Build()
+func (r *Runner) _env(ctx *Context, req *request.NoData, resp response.Response) error {
+	return resp.Form(map[string]interface{}{"version": "1.0", "lang": "go", "nats": natsStatus(r.conn())}).Build()
 }
 
 func (r *Runner) help(ctx *Context, req *request.NoData, resp response.Response) error {
@@ -111,6 +117,13 @@ func (r *Runner) buildApiInfo(worker *routerInfo) (*api.Info, error) {
 		apiInfo.Callbacks = append(apiInfo.Callbacks, constants.CallbackTypeOnTableDeleteRows)
 		apiInfo.Callbacks = append(apiInfo.Callbacks, constants.CallbackTypeOnTableUpdateRows)
 	}
+	// AutoCrud的模型支持软删除时，前端展示恢复入口（自定义的恢复回调在下面统一添加）
+	if _, ok := opt.GetCallbacks()[CallbackTypeOnTableRestoreRows]; !ok && opt.GetAutoCrudTable() != nil && hasSoftDelete(opt.GetAutoCrudTable()) {
+		apiInfo.Callbacks = append(apiInfo.Callbacks, CallbackTypeOnTableRestoreRows)
+	}
+	if tb, ok := opt.(*TableFunctionOptions); ok && tb.AutoCrudTable != nil && tb.EnableHistory {
+		apiInfo.Callbacks = append(apiInfo.Callbacks, CallbackTypeOnTableRowHistory, CallbackTypeOnTableRowRollback)
+	}
 	// 处理配置相关
 	if config.AutoUpdateConfig != nil {
 		// 解析配置结构体，生成表单配置
@@ -160,6 +173,10 @@ func (r *Runner) buildApiInfo(worker *routerInfo) (*api.Info, error) {
 	for name, _ := range callbacks {
 		apiInfo.Callbacks = append(apiInfo.Callbacks, name)
 	}
+	// 注册了迁移时需要在版本变更时回调，用来自动执行迁移
+	if _, ok := callbacks[constants.CallbackTypeOnVersionChange]; !ok && hasMigrations() {
+		apiInfo.Callbacks = append(apiInfo.Callbacks, constants.CallbackTypeOnVersionChange)
+	}
 	//// 获取回调函数信息 - 简化处理
 	//apiInfo.Callbacks = []string{}
 
@@ -270,7 +287,20 @@ func (r *Runner) _getApiInfos(ctx *Context, req *usercall.NoData, resp response.
 	if err != nil {
 		return err
 	}
-	return resp.Form(apis).Build()
+	// 按当前用户隐藏没有权限的函数和字段
+	visible := make([]*api.Info, 0, len(apis))
+	for _, info := range apis {
+		worker, ok := r.getRouter(info.Router, info.Method)
+		if !ok {
+			continue
+		}
+		if authorize(ctx, worker) != nil {
+			continue
+		}
+		filterApiInfo(ctx, worker, info)
+		visible = append(visible, info)
+	}
+	return resp.Form(visible).Build()
 }
 
 func (r *Runner) _getApiInfo(ctx *Context, req *usercall.ApiInfoRequest, resp response.Response) error {
@@ -278,6 +308,9 @@ func (r *Runner) _getApiInfo(ctx *Context, req *usercall.ApiInfoRequest, resp re
 	if err != nil {
 		return err
 	}
+	if worker, ok := r.getRouter(apiInfo.Router, apiInfo.Method); ok {
+		filterApiInfo(ctx, worker, apiInfo)
+	}
 	// 返回API信息
 	return resp.Form(apiInfo).Build()
 }
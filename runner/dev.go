@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+//go:embed devui/index.html
+var devIndexHTML []byte
+
+// devLogLimit 每个请求最多返回的日志行数
+const devLogLimit = 500
+
+// devCmd 本地开发控制台，根据 /_getApiInfos 渲染每个函数的表单和表格，在进程内执行请求和回调，不需要NATS和平台
+//
+//	./app dev --addr 127.0.0.1:7070
+func (r *Runner) devCmd(cmd *cobra.Command, args []string) {
+	addr, err := cmd.Flags().GetString("addr")
+	if err != nil {
+		writeString(err.Error())
+		return
+	}
+	if err := checkListenAddr(addr); err != nil {
+		writeString(err.Error())
+		return
+	}
+	r.isDebug = true
+	r.asyncEnabled = true
+	r.touch()
+	fmt.Printf("开发控制台: http://%s/_dev/\n", addr)
+	r.listenHTTP(context.Background(), addr, r.devHandler())
+}
+
+// devHandler 在HTTP模式的基础上增加控制台页面 /_dev/ 和按trace_id查询日志的 /_dev/logs
+func (r *Runner) devHandler() http.Handler {
+	mux := r.httpHandler()
+	mux.HandleFunc("/_dev/", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(devIndexHTML)
+	})
+	mux.HandleFunc("/_dev/logs", func(w http.ResponseWriter, req *http.Request) {
+		traceID := req.URL.Query().Get("trace_id")
+		if traceID == "" {
+			writeHTTPJSON(w, http.StatusBadRequest, map[string]string{"msg": "trace_id不能为空"})
+			return
+		}
+		lines, err := grepLogFile(logFile, traceID, devLogLimit)
+		if err != nil {
+			writeHTTPJSON(w, http.StatusInternalServerError, map[string]string{"msg": err.Error()})
+			return
+		}
+		writeHTTPJSON(w, http.StatusOK, map[string]interface{}{"trace_id": traceID, "logs": lines})
+	})
+	return mux
+}
+
+// grepLogFile 从日志文件中找出包含keyword的行，超过limit时只保留最后limit行
+func grepLogFile(path string, keyword string, limit int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("读取日志失败: %w", err)
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.Contains(line, keyword) {
+			lines = append(lines, line)
+			if len(lines) > limit {
+				lines = lines[1:]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取日志失败: %w", err)
+	}
+	return lines, nil
+}
@@ -1,12 +1,9 @@
 package runner
 
 import (
-	"encoding/json"
 	"fmt"
-	"reflect"
 
 	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
-	"github.com/yunhanshu-net/pkg/logger"
 )
 
 type FunctionType string
@@ -34,7 +31,10 @@ type FunctionOptions struct {
 	Router           string                             `json:"router"`             //api的路由
 	Method           string                             `json:"method"`             //api的method
 	ApiDesc          string                             `json:"api_desc"`           //函数介绍
-	IsPublicApi      bool                               `json:"is_public_api"`      //是否是公共api，默认false
+	IsPublicApi      bool                               `json:"is_public_api"`      //是否是公共api，默认false，公共api不需要登录
+	MustLogin        bool                               `json:"must_login"`         //是否需要登录，未登录时返回 E_UNAUTHORIZED
+	Roles            []string                           `json:"roles"`              //允许调用的角色，为空表示不限制
+	Policy           AccessPolicy                       `json:"-"`                  //自定义权限判断，在Roles之后执行
 	ChineseName      string                             `json:"chinese_name"`       //中文名称
 	EnglishName      string                             `json:"english_name"`       //英文名称，需要符合go的文件名称规范和路由规范
 	Classify         string                             `json:"classify"`           //分类
@@ -42,6 +42,7 @@ type FunctionOptions struct {
 	Async            bool                               `json:"async"`              //是否异步，比较耗时的api，或者需要后台慢慢处理的api
 	FunctionType     FunctionType                       `json:"function_type"`      //函数类型 默认：dynamic_function
 	Timeout          int                                `json:"timeout"`            //超时时间，单位毫秒,0表示不超时
+	MaxConcurrency   int                                `json:"max_concurrency"`    //单个路由的最大并发，超过时直接响应繁忙，0表示不限制
 	RenderType       string                             `json:"widget"`             // 渲染类型	//form，table，echarts
 	CreateTables     []interface{}                      `json:"create_tables"`      //创建该api时候会自动帮忙创建这个数据库表gorm的model列表
 	UseTables        []interface{}                      `json:"use_tables"`         //这里需要记录这个函数用到的数据表，方便梳理引用关系
@@ -76,53 +77,16 @@ type FunctionOptions struct {
 	OnDryRun OnDryRun `json:"-"` // DryRun 回调，用于预览危险操作
 }
 
-func (f *FunctionOptions) defaultDeleteRows(ctx *Context, req *usercall.OnTableDeleteRowsReq) error {
-	return ctx.MustGetOrInitDB().Model(f.AutoCrudTable).Delete("id in ?", req.Ids).Error
+func (f *FunctionOptions) defaultDeleteRows(ctx *Context, req *usercall.OnTableDeleteRowsReq) (*usercall.OnTableDeleteRowsResp, error) {
+	return autoCrudDeleteRows(ctx, f.AutoCrudTable, req, false)
 }
 
 func (f *FunctionOptions) defaultUpdateRows(ctx *Context, req *usercall.OnTableUpdateRowsReq) error {
-	for k, field := range req.Fields {
-		switch field.(type) {
-		case map[string]interface{}:
-			marshal, err := json.Marshal(field)
-			if err != nil {
-				return err
-			}
-			req.Fields[k] = json.RawMessage(marshal)
-		}
-	}
-
-	return ctx.MustGetOrInitDB().Model(f.AutoCrudTable).Where("id in ?", req.Ids).Updates(req.Fields).Error
+	return autoCrudUpdateRows(ctx, f.AutoCrudTable, req, false)
 }
 
 func (f *FunctionOptions) defaultAddRows(ctx *Context, req *usercall.OnTableAddRowsReq) error {
-	//  获取模型的类型
-	modelType := reflect.TypeOf(f.AutoCrudTable)
-	if modelType.Kind() == reflect.Ptr {
-		modelType = modelType.Elem()
-	}
-
-	//  创建可寻址的slice
-	sliceType := reflect.SliceOf(modelType)
-	slicePtr := reflect.New(sliceType) // 创建slice指针
-	slice := slicePtr.Elem()           // 获取slice值
-
-	//  解码数据到slice中
-	err := req.DecodeBy(slicePtr.Interface())
-	if err != nil {
-		logger.Errorf(ctx, "DecodeBy failed: %v", err)
-		return err
-	}
-
-	logger.Infof(ctx, "defaultAddRows slice: %+v", slice.Interface())
-
-	// 检查是否有数据
-	if slice.Len() == 0 {
-		return fmt.Errorf("没有要添加的数据")
-	}
-
-	//  执行数据库插入
-	return ctx.MustGetOrInitDB().Create(slicePtr.Interface()).Error
+	return autoCrudAddRows(ctx, f.AutoCrudTable, req, false)
 }
 
 // GetOnInputFuzzyMap 实现FunctionInfoProvider接口
@@ -169,7 +133,11 @@ func (f *FunctionOptions) GetBaseConfig() *BaseConfig {
 		Async:            f.Async,
 		FunctionType:     f.FunctionType,
 		Timeout:          f.Timeout,
+		MaxConcurrency:   f.MaxConcurrency,
 		IsPublicApi:      f.IsPublicApi,
+		MustLogin:        f.MustLogin,
+		Roles:            f.Roles,
+		Policy:           f.Policy,
 		Request:          f.Request,
 		Response:         f.Response,
 		CreateTables:     f.CreateTables,
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	consts "github.com/yunhanshu-net/pkg/constants/usercall"
+	"github.com/yunhanshu-net/pkg/logger"
+)
+
+// inflightTracker 记录正在处理的请求，关闭时先停止接收新请求，再等待已经接收的请求处理完
+type inflightTracker struct {
+	mu       sync.Mutex
+	count    int
+	draining bool
+	idle     chan struct{} // count归零时关闭，用于等待
+}
+
+// enter 开始处理一个请求，正在关闭时返回false，调用方需要拒绝这个请求
+func (t *inflightTracker) enter() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.count++
+	return true
+}
+
+// exit 请求处理结束，必须和成功的enter成对调用
+func (t *inflightTracker) exit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count--
+	if t.count <= 0 && t.idle != nil {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// hold 不受关闭状态限制地占用一个计数，用于超时后被分离的处理函数，处理函数真正结束时调用exit
+// 这样关闭和空闲退出都会等分离的处理函数结束
+func (t *inflightTracker) hold() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count++
+}
+
+func (t *inflightTracker) running() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+func (t *inflightTracker) isDraining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
+// drain 停止接收新请求，等待正在处理的请求结束，超时返回false
+func (t *inflightTracker) drain(timeout time.Duration) bool {
+	t.mu.Lock()
+	t.draining = true
+	if t.count <= 0 {
+		t.mu.Unlock()
+		return true
+	}
+	if t.idle == nil {
+		t.idle = make(chan struct{})
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// drainTimeout 优雅关闭时等待请求处理完的最长时间，环境变量 RUNNER_DRAIN_TIMEOUT_MS，默认10秒
+func drainTimeout() time.Duration {
+	return time.Duration(getEnvIntOrDefault("RUNNER_DRAIN_TIMEOUT_MS", 10000)) * time.Millisecond
+}
+
+// drain 优雅关闭：停止接收新请求，等待正在处理的请求结束（最多等timeout），
+// 执行所有路由的 BeforeRunnerClose，等待异步通知发送完，关闭连接，最后执行 AfterRunnerClose
+func (r *Runner) drain(ctx context.Context, timeout time.Duration) error {
+	if sub := r.natsSubscribe.Swap(nil); sub != nil {
+		// 已经收到但还没处理的消息会被响应繁忙，平台可以转给其他实例
+		if err := sub.Drain(); err != nil {
+			logger.Debugf(ctx, "清理订阅时出错: %v", err)
+		}
+	}
+	// 还在队列里没有被worker取到的请求直接响应繁忙，不能等到连接关闭之后
+	for _, msg := range r.reqPool.stop() {
+		r.replyBusy(ctx, msg, "runner正在关闭")
+	}
+	if !r.inflight.drain(timeout) {
+		logger.Warnf(ctx, "等待请求处理完成超时(%v)，还有%d个请求在处理中", timeout, r.inflight.running())
+	}
+
+	// run/usercall 是一次性进程，只有常驻进程(connect)模式才执行关闭回调
+	// 关闭前后的回调共用一个时间预算，避免某个回调卡住导致进程无法退出
+	reason := r.getCloseReason()
+	deadline := time.Now().Add(closeHookTimeout())
+	if r.asyncEnabled {
+		r.runCloseHooks(ctx, consts.CallbackTypeBeforeRunnerClose, reason, deadline)
+	}
+	// 站内信通过NATS发送，需要在关闭连接前发完
+	flushNotifications(ctx, deadline)
+	err := r.close(ctx)
+	if r.asyncEnabled {
+		r.runCloseHooks(ctx, consts.CallbackTypeAfterRunnerClose, reason, deadline)
+	}
+	return err
+}
@@ -0,0 +1,221 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
+)
+
+type slowReq struct {
+	N int `json:"n"`
+}
+
+func newTestRunner() *Runner {
+	return &Runner{
+		routerMap:    make(map[string]*routerInfo),
+		down:         make(chan struct{}, 1),
+		inflight:     &inflightTracker{},
+		asyncPool:    newAsyncPool(),
+		reqPool:      newRequestPool(),
+		asyncEnabled: true,
+	}
+}
+
+// go test -race 下并发执行请求，同时读取运行状态并优雅关闭
+func TestRunnerDrain(t *testing.T) {
+	r := newTestRunner()
+	// 处理函数按路由缓存在全局，每次用新的路由避免 -count 多次执行时拿到上一次的处理函数
+	router := fmt.Sprintf("/slow_%d", time.Now().UnixNano())
+	release := make(chan struct{})
+	var handled, before, after int32
+	r.post(router, func(ctx *Context, req *slowReq, resp response.Response) error {
+		<-release
+		atomic.AddInt32(&handled, 1)
+		return resp.Form(map[string]int{"n": req.N}).Build()
+	}, &FormFunctionOptions{
+		BeforeRunnerClose: func(ctx *Context, req *usercall.BeforeRunnerCloseReq) error {
+			if atomic.LoadInt32(&handled) != 8 {
+				t.Errorf("BeforeRunnerClose 应该在请求处理完之后执行，已处理 %d", atomic.LoadInt32(&handled))
+			}
+			atomic.AddInt32(&before, 1)
+			return nil
+		},
+		AfterRunnerClose: func(ctx *Context, req *usercall.AfterRunnerCloseReq) error {
+			atomic.AddInt32(&after, 1)
+			return nil
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r.touch()
+			_, err := r.runFunctionV2(NewContext(context.Background(), "POST", router, r),
+				&request.RunFunctionReq{Method: "POST", Router: router, Body: map[string]int{"n": i}})
+			if err != nil {
+				t.Errorf("请求失败: %v", err)
+			}
+		}(i)
+	}
+	for r.GetRunningCount() != 8 {
+		_ = r.idleTime()
+		time.Sleep(time.Millisecond)
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- r.drain(context.Background(), 5*time.Second) }()
+	for !r.inflight.isDraining() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := r.runFunctionV2(NewContext(context.Background(), "POST", router, r),
+		&request.RunFunctionReq{Method: "POST", Router: router}); err == nil {
+		t.Fatal("关闭过程中不应该再接收新请求")
+	}
+
+	close(release)
+	wg.Wait()
+	if err := <-drained; err != nil {
+		t.Fatalf("关闭失败: %v", err)
+	}
+	if handled != 8 || before != 1 || after != 1 || r.GetRunningCount() != 0 {
+		t.Fatalf("handled=%d before=%d after=%d running=%d", handled, before, after, r.GetRunningCount())
+	}
+	if err := r.close(context.Background()); err != nil {
+		t.Fatalf("重复关闭应该直接返回: %v", err)
+	}
+}
+
+func TestInflightDrainTimeout(t *testing.T) {
+	tracker := &inflightTracker{}
+	if !tracker.enter() {
+		t.Fatal("未关闭时应该可以进入")
+	}
+	if tracker.drain(10 * time.Millisecond) {
+		t.Fatal("还有请求在处理，应该等待超时")
+	}
+	if tracker.enter() {
+		t.Fatal("关闭后不应该再接收请求")
+	}
+	tracker.exit()
+	if !tracker.drain(time.Second) {
+		t.Fatal("请求处理完之后应该立即返回")
+	}
+}
+
+// TestDetachedHandlerBlocksDrain 超时后被分离的处理函数仍然算正在处理，关闭时要等它结束
+func TestDetachedHandlerBlocksDrain(t *testing.T) {
+	r := newTestRunner()
+	router := fmt.Sprintf("/detach_%d", time.Now().UnixNano())
+	release := make(chan struct{})
+	opt := &FormFunctionOptions{}
+	opt.Timeout = 20
+	r.post(router, func(ctx *Context, req *slowReq, resp response.Response) error {
+		<-release //不响应取消信号
+		return resp.Form(map[string]int{"n": req.N}).Build()
+	}, opt)
+
+	_, err := r.runFunctionV2(NewContext(context.Background(), "POST", router, r),
+		&request.RunFunctionReq{Method: "POST", Router: router})
+	var appErr *AppError
+	if !errors.As(err, &appErr) || appErr.Code != "E_TIMEOUT" {
+		t.Fatalf("应该返回超时错误: %v", err)
+	}
+	if r.GetRunningCount() != 1 || r.GetDetachedCount() != 1 {
+		t.Fatalf("分离的处理函数应该计入正在处理: running=%d detached=%d", r.GetRunningCount(), r.GetDetachedCount())
+	}
+	if r.inflight.drain(20 * time.Millisecond) {
+		t.Fatal("分离的处理函数还没结束，关闭应该等待超时")
+	}
+
+	close(release)
+	if !r.inflight.drain(time.Second) {
+		t.Fatal("分离的处理函数结束后应该可以关闭")
+	}
+	for r.GetDetachedCount() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if r.GetRunningCount() != 0 {
+		t.Fatalf("running=%d", r.GetRunningCount())
+	}
+}
+
+// TestInvokeWithTimeoutKeepsCallerContext 超时只作用在处理函数的Context副本上，调用方的ctx在返回后仍然可用
+func TestInvokeWithTimeoutKeepsCallerContext(t *testing.T) {
+	r := newTestRunner()
+	router := fmt.Sprintf("/deadline_%d", time.Now().UnixNano())
+	opt := &FormFunctionOptions{}
+	opt.Timeout = 1000
+	var handlerDeadline bool
+	r.post(router, func(ctx *Context, req *slowReq, resp response.Response) error {
+		_, handlerDeadline = ctx.Deadline()
+		return resp.Form(map[string]int{"n": req.N}).Build()
+	}, opt)
+
+	ctx := NewContext(context.Background(), "POST", router, r)
+	if _, err := r.runFunctionV2(ctx, &request.RunFunctionReq{Method: "POST", Router: router}); err != nil {
+		t.Fatal(err)
+	}
+	if !handlerDeadline {
+		t.Error("处理函数的Context应该带截止时间")
+	}
+	if _, ok := ctx.Deadline(); ok || ctx.Err() != nil {
+		t.Fatalf("调用方的ctx不应该被修改或取消: %v", ctx.Err())
+	}
+}
+
+func TestCloseHooksReasonAndBudget(t *testing.T) {
+	t.Setenv("RUNNER_CLOSE_HOOK_TIMEOUT_MS", "100")
+	r := newTestRunner()
+	prefix := fmt.Sprintf("/close_%d", time.Now().UnixNano())
+	handler := func(ctx *Context, req *slowReq, resp response.Response) error { return nil }
+
+	var gotReason string
+	var afterCalled int32
+	r.post(prefix+"/a", handler, &FormFunctionOptions{
+		BeforeRunnerClose: func(ctx *Context, req *usercall.BeforeRunnerCloseReq) error {
+			gotReason = req.Reason
+			return nil
+		},
+		AfterRunnerClose: func(ctx *Context, req *usercall.AfterRunnerCloseReq) error {
+			atomic.AddInt32(&afterCalled, 1)
+			return nil
+		},
+	})
+	r.post(prefix+"/b", handler, &FormFunctionOptions{
+		BeforeRunnerClose: func(ctx *Context, req *usercall.BeforeRunnerCloseReq) error {
+			panic("boom")
+		},
+	})
+	r.post(prefix+"/c", handler, &FormFunctionOptions{
+		BeforeRunnerClose: func(ctx *Context, req *usercall.BeforeRunnerCloseReq) error {
+			<-ctx.Done() //一直等到超时
+			return ctx.Err()
+		},
+	})
+
+	r.setCloseReason(CloseReasonIdle)
+	r.setCloseReason(CloseReasonDown)
+	start := time.Now()
+	if err := r.drain(context.Background(), time.Second); err != nil {
+		t.Fatalf("关闭失败: %v", err)
+	}
+	if cost := time.Since(start); cost > time.Second {
+		t.Fatalf("回调超过时间预算后不应该继续等待，耗时 %v", cost)
+	}
+	if gotReason != CloseReasonIdle {
+		t.Fatalf("关闭原因应该是第一次设置的 idle，实际 %q", gotReason)
+	}
+	if atomic.LoadInt32(&afterCalled) != 0 {
+		t.Fatal("时间预算用完之后 AfterRunnerClose 应该被跳过")
+	}
+}
@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsLockValue 存在KV中的锁信息，KV的TTL是整个bucket级别的，所以过期时间记录在value里
+type natsLockValue struct {
+	Owner    string `json:"owner"`
+	ExpireAt int64  `json:"expire_at"` //毫秒时间戳，0表示不过期
+}
+
+func (v *natsLockValue) expired(now int64) bool {
+	return v.ExpireAt > 0 && v.ExpireAt <= now
+}
+
+// NatsLockBackend 基于NATS JetStream KV的锁后端，适合多个runner实例之间互斥，依赖KV的revision做CAS
+type NatsLockBackend struct {
+	kv nats.KeyValue
+}
+
+func NewNatsLockBackend(nc *nats.Conn, bucket string) (*NatsLockBackend, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket, Description: "runner distributed locks"})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &NatsLockBackend{kv: kv}, nil
+}
+
+// natsLockKey KV的key只允许部分字符，业务key需要编码一下，例如 app1:token:gen
+func natsLockKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func (b *NatsLockBackend) TryAcquire(key string, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now().UnixMilli()
+	value := &natsLockValue{Owner: owner}
+	if ttl > 0 {
+		value.ExpireAt = now + ttl.Milliseconds()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+
+	kvKey := natsLockKey(key)
+	_, err = b.kv.Create(kvKey, data)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, nats.ErrKeyExists) {
+		return false, err
+	}
+
+	entry, err := b.kv.Get(kvKey)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) { //刚好被释放，下次再抢
+			return false, nil
+		}
+		return false, err
+	}
+	var current natsLockValue
+	if err := json.Unmarshal(entry.Value(), &current); err != nil {
+		return false, err
+	}
+	if current.Owner != owner && !current.expired(now) {
+		return false, nil
+	}
+	// 自己持有或者已经过期，基于revision覆盖，并发时只有一个能成功
+	if _, err := b.kv.Update(kvKey, data, entry.Revision()); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (b *NatsLockBackend) Release(key string, owner string) (bool, error) {
+	kvKey := natsLockKey(key)
+	entry, err := b.kv.Get(kvKey)
+	if err != nil {
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	var current natsLockValue
+	if err := json.Unmarshal(entry.Value(), &current); err != nil {
+		return false, err
+	}
+	if current.Owner != owner {
+		return false, nil
+	}
+	if err := b.kv.Delete(kvKey, nats.LastRevision(entry.Revision())); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
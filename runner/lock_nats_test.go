@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+)
+
+// connectTestNats 启动一个内嵌的开启JetStream的nats-server并连接
+func connectTestNats(t *testing.T) *nats.Conn {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	srv := natsserver.RunServer(&opts)
+	t.Cleanup(srv.Shutdown)
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("连接NATS失败: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func openNatsLockTestBackend(t *testing.T) *NatsLockBackend {
+	backend, err := NewNatsLockBackend(connectTestNats(t), "locks_test")
+	if err != nil {
+		t.Fatalf("创建锁后端失败: %v", err)
+	}
+	return backend
+}
+
+func TestNatsLockBackend(t *testing.T) {
+	backend := openNatsLockTestBackend(t)
+	a := newTestLock("a", backend)
+	b := newTestLock("b", backend)
+
+	if !a.Lock("app1:token:gen", 200*time.Millisecond) {
+		t.Fatal("a 应该加锁成功")
+	}
+	if b.Lock("app1:token:gen") {
+		t.Fatal("锁未过期时 b 不应该加锁成功")
+	}
+	if b.Unlock("app1:token:gen") {
+		t.Fatal("b 不是持锁者，不应该释放成功")
+	}
+	if !a.Lock("app1:token:gen", 200*time.Millisecond) {
+		t.Fatal("a 重复加锁应该刷新过期时间")
+	}
+
+	if !b.LockWait("app1:token:gen", 2*time.Second) {
+		t.Fatal("锁过期后 b 应该加锁成功")
+	}
+	if a.Unlock("app1:token:gen") {
+		t.Fatal("锁已经被 b 持有，a 不应该释放成功")
+	}
+	if !b.Unlock("app1:token:gen") {
+		t.Fatal("b 应该释放成功")
+	}
+	if b.Unlock("app1:token:gen") {
+		t.Fatal("重复释放应该返回false")
+	}
+	if !a.Lock("app1:token:gen") {
+		t.Fatal("释放后 a 应该能重新加锁")
+	}
+	if b.LockWait("app1:token:gen", 100*time.Millisecond) {
+		t.Fatal("不过期的锁被持有时 b 应该等待超时")
+	}
+}
+
+// TestNatsLockRevision 加锁和释放都基于KV的revision，读到的版本已经被别人改过时操作失败
+func TestNatsLockRevision(t *testing.T) {
+	backend := openNatsLockTestBackend(t)
+	key := natsLockKey("order:1")
+	if ok, err := backend.TryAcquire("order:1", "a", 50*time.Millisecond); !ok || err != nil {
+		t.Fatalf("a 应该加锁成功: %v", err)
+	}
+	stale, err := backend.kv.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ok, err := backend.TryAcquire("order:1", "b", time.Minute); !ok || err != nil {
+		t.Fatalf("锁过期后 b 应该加锁成功: %v", err)
+	}
+	if _, err := backend.kv.Update(key, stale.Value(), stale.Revision()); err == nil {
+		t.Fatal("旧的revision不应该覆盖成功")
+	}
+	if err := backend.kv.Delete(key, nats.LastRevision(stale.Revision())); err == nil {
+		t.Fatal("旧的revision不应该删除成功")
+	}
+	if ok, _ := backend.Release("order:1", "a"); ok {
+		t.Fatal("a 的锁已经被接管，不应该释放成功")
+	}
+	if ok, err := backend.Release("order:1", "b"); !ok || err != nil {
+		t.Fatalf("b 应该释放成功: %v", err)
+	}
+	if ok, err := backend.TryAcquire("order:1", "a", 0); !ok || err != nil {
+		t.Fatalf("释放后 a 应该能重新加锁: %v", err)
+	}
+}
+
+func TestGetLockBackendWithoutNats(t *testing.T) {
+	t.Setenv("LOCK_BACKEND", LockBackendNats)
+	SetLockBackend(nil)
+	defer SetLockBackend(nil)
+	saved := r
+	r = newTestRunner()
+	defer func() { r = saved }()
+	if _, err := getLockBackend(); err == nil {
+		t.Fatal("没有NATS连接时应该返回错误而不是panic")
+	}
+}
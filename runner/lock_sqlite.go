@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// lockRecord 锁记录
+type lockRecord struct {
+	LockKey  string `gorm:"primaryKey;size:255"`
+	Owner    string `gorm:"size:64"`
+	ExpireAt int64  //过期时间，毫秒时间戳，0表示不过期
+}
+
+func (lockRecord) TableName() string {
+	return "_locks"
+}
+
+// SQLiteLockBackend 基于SQLite的锁后端，加锁是单条upsert语句，依赖SQLite的文件锁保证多进程之间的互斥
+type SQLiteLockBackend struct {
+	db *gorm.DB
+}
+
+func NewSQLiteLockBackend(db *gorm.DB) (*SQLiteLockBackend, error) {
+	if err := db.AutoMigrate(&lockRecord{}); err != nil {
+		return nil, err
+	}
+	return &SQLiteLockBackend{db: db}, nil
+}
+
+func (b *SQLiteLockBackend) TryAcquire(key string, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now().UnixMilli()
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = now + ttl.Milliseconds()
+	}
+	// 没有记录直接插入；有记录时只有自己持有或者已经过期才能覆盖
+	res := b.db.Exec(`INSERT INTO _locks (lock_key, owner, expire_at) VALUES (?, ?, ?)
+ON CONFLICT(lock_key) DO UPDATE SET owner = excluded.owner, expire_at = excluded.expire_at
+WHERE _locks.owner = excluded.owner OR (_locks.expire_at > 0 AND _locks.expire_at <= ?)`,
+		key, owner, expireAt, now)
+	if res.Error != nil {
+		if isSQLiteBusy(res.Error) { //其他进程正在写，当作没抢到锁
+			return false, nil
+		}
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+func (b *SQLiteLockBackend) Release(key string, owner string) (bool, error) {
+	res := b.db.Exec("DELETE FROM _locks WHERE lock_key = ? AND owner = ?", key, owner)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+func isSQLiteBusy(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "SQLITE_BUSY")
+}
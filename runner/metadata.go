@@ -126,6 +126,11 @@ func doCall(method string, meta *runtimeMeta, ctx *Context, resp *response.RunFu
 		}
 	}
 
+	// 校验 validate 标签，和前端规则保持一致
+	if fields := validateStruct(req); len(fields) > 0 {
+		return ValidationError(ctx, fields)
+	}
+
 	// 执行验证（如果需要的话，取消注释并实现）
 	if meta.hasValidate {
 		if v, ok := req.(Validatable); ok && v != nil {
@@ -0,0 +1,452 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/env"
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/pkg/logger"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// Migration 版本化的数据库迁移，按注册顺序执行，每个迁移在一个事务里执行并记录到目标库的 _migrations 表
+// 用法：
+//
+//	runner.RegisterMigration(runner.Migration{
+//		Version: "v12",
+//		Name:    "订单表增加备注字段",
+//		Up: func(tx *gorm.DB) error {
+//			return tx.Migrator().AddColumn(&Order{}, "Remark")
+//		},
+//		Down: func(tx *gorm.DB) error {
+//			return tx.Migrator().DropColumn(&Order{}, "Remark")
+//		},
+//	})
+type Migration struct {
+	Version string                  // 版本号，唯一
+	Name    string                  // 描述
+	DB      string                  // 目标数据库，对应 RegisterDB 的名称，空表示runner默认的数据库
+	Up      func(tx *gorm.DB) error // 升级
+	Down    func(tx *gorm.DB) error // 回滚，不填表示不支持回滚
+}
+
+// migrationRecord 已经执行的迁移
+type migrationRecord struct {
+	Version       string `gorm:"primaryKey;size:128"`
+	Name          string `gorm:"size:255"`
+	RunnerVersion string `gorm:"size:64"` //执行迁移时runner的版本
+	AppliedAt     int64  //毫秒时间戳
+}
+
+func (migrationRecord) TableName() string {
+	return "_migrations"
+}
+
+// MigrationStep 一个迁移的执行结果，SQL是执行的语句，dry-run时是预览的语句
+type MigrationStep struct {
+	Version string   `json:"version"`
+	Name    string   `json:"name"`
+	Action  string   `json:"action"` // up/down
+	SQL     []string `json:"sql"`
+	Cost    int64    `json:"cost"` // 毫秒
+}
+
+// MigrationResult 一次迁移/回滚的结果
+type MigrationResult struct {
+	DryRun bool             `json:"dry_run"`
+	Steps  []*MigrationStep `json:"steps"`
+}
+
+// MigrationStatus 迁移的状态
+type MigrationStatus struct {
+	Version       string `json:"version"`
+	Name          string `json:"name"`
+	DB            string `json:"db"`
+	Applied       bool   `json:"applied"`
+	AppliedAt     int64  `json:"applied_at"`
+	RunnerVersion string `json:"runner_version"`
+	Rollbackable  bool   `json:"rollbackable"`
+}
+
+var (
+	migrationLock = new(sync.Mutex) //注册和执行都需要加锁，避免并发执行同一个迁移
+	migrations    []*Migration
+)
+
+// RegisterMigration 注册迁移，一般在init或者main里调用，版本号重复会panic
+func RegisterMigration(ms ...Migration) {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	for i := range ms {
+		m := ms[i]
+		if m.Version == "" || m.Up == nil {
+			panic("migration 的 Version 和 Up 不能为空")
+		}
+		for _, exist := range migrations {
+			if exist.Version == m.Version {
+				panic(fmt.Sprintf("migration 版本重复: %s", m.Version))
+			}
+		}
+		migrations = append(migrations, &m)
+	}
+}
+
+func hasMigrations() bool {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	return len(migrations) > 0
+}
+
+// sqlRecorder 记录执行过的SQL，用于dry-run预览和执行结果
+type sqlRecorder struct {
+	mu  sync.Mutex
+	sql []string
+}
+
+func (l *sqlRecorder) LogMode(gormlogger.LogLevel) gormlogger.Interface { return l }
+func (l *sqlRecorder) Info(context.Context, string, ...interface{})     {}
+func (l *sqlRecorder) Warn(context.Context, string, ...interface{})     {}
+func (l *sqlRecorder) Error(context.Context, string, ...interface{})    {}
+func (l *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	l.mu.Lock()
+	l.sql = append(l.sql, sql)
+	l.mu.Unlock()
+}
+
+func (l *sqlRecorder) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.sql)
+}
+
+// since 第n条之后记录的SQL
+func (l *sqlRecorder) since(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.sql[n:]...)
+}
+
+func migrationDB(ctx *Context, m *Migration) (*gorm.DB, error) {
+	db, err := ctx.GetDB(m.DB)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.AutoMigrate(&migrationRecord{}); err != nil {
+		return nil, fmt.Errorf("创建 _migrations 表失败: %w", err)
+	}
+	return db, nil
+}
+
+func getMigrationRecord(db *gorm.DB, version string) (*migrationRecord, error) {
+	var rec migrationRecord
+	res := db.Where("version = ?", version).Limit(1).Find(&rec)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+// applyMigration 在tx里执行一个迁移并写入或删除迁移记录，返回迁移本身执行的SQL（不包括迁移记录）
+func applyMigration(tx *gorm.DB, recorder *sqlRecorder, m *Migration, action string) ([]string, error) {
+	offset := recorder.len()
+	var err error
+	if action == "up" {
+		err = m.Up(tx)
+	} else {
+		err = m.Down(tx)
+	}
+	sql := recorder.since(offset)
+	if err != nil {
+		return sql, err
+	}
+	if action == "up" {
+		err = tx.Create(&migrationRecord{
+			Version:       m.Version,
+			Name:          m.Name,
+			RunnerVersion: env.Version,
+			AppliedAt:     time.Now().UnixMilli(),
+		}).Error
+	} else {
+		err = tx.Where("version = ?", m.Version).Delete(&migrationRecord{}).Error
+	}
+	return sql, err
+}
+
+// runMigrationStep 在一个事务里执行一个迁移并提交
+func runMigrationStep(ctx *Context, db *gorm.DB, m *Migration, action string) (*MigrationStep, error) {
+	step := &MigrationStep{Version: m.Version, Name: m.Name, Action: action}
+	recorder := &sqlRecorder{}
+	start := time.Now()
+	err := db.Session(&gorm.Session{Logger: recorder}).Transaction(func(tx *gorm.DB) error {
+		sql, err := applyMigration(tx, recorder, m, action)
+		step.SQL = sql
+		return err
+	})
+	step.Cost = time.Since(start).Milliseconds()
+	if err != nil {
+		return step, fmt.Errorf("迁移 %s %s 失败: %w", m.Version, action, err)
+	}
+	logger.Infof(ctx, "迁移 %s %s 成功 耗时:%dms", m.Version, action, step.Cost)
+	return step, nil
+}
+
+// migrationRun 一次迁移或回滚，dry-run时每个数据库只开一个事务，所有迁移在这个事务里依次执行，
+// 后面的迁移能看到前面迁移的结果，结束时统一回滚（依赖SQLite的DDL可以在事务里回滚）
+type migrationRun struct {
+	ctx       *Context
+	dryRun    bool
+	txs       map[string]*gorm.DB
+	recorders map[string]*sqlRecorder
+}
+
+func newMigrationRun(ctx *Context, dryRun bool) *migrationRun {
+	return &migrationRun{ctx: ctx, dryRun: dryRun, txs: map[string]*gorm.DB{}, recorders: map[string]*sqlRecorder{}}
+}
+
+// db 迁移使用的数据库，dry-run时返回该数据库的事务
+func (b *migrationRun) db(m *Migration) (*gorm.DB, error) {
+	if !b.dryRun {
+		return migrationDB(b.ctx, m)
+	}
+	if tx, ok := b.txs[m.DB]; ok {
+		return tx, nil
+	}
+	db, err := migrationDB(b.ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	// MySQL等数据库的DDL会隐式提交事务，dry-run会真的修改表结构
+	if db.Dialector.Name() != DBDriverSQLite {
+		return nil, Err(b.ctx).Code("E_NOT_SUPPORTED").Msg("只有SQLite支持dry-run").
+			Detail(fmt.Sprintf("迁移 %s 的数据库驱动是 %s，DDL不能在事务里回滚", m.Version, db.Dialector.Name())).Build()
+	}
+	recorder := &sqlRecorder{}
+	tx := db.Session(&gorm.Session{Logger: recorder}).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("开启dry-run事务失败: %w", tx.Error)
+	}
+	b.txs[m.DB] = tx
+	b.recorders[m.DB] = recorder
+	return tx, nil
+}
+
+// step 执行一个迁移，dry-run时在db()返回的事务里执行，不提交
+func (b *migrationRun) step(db *gorm.DB, m *Migration, action string) (*MigrationStep, error) {
+	if !b.dryRun {
+		return runMigrationStep(b.ctx, db, m, action)
+	}
+	step := &MigrationStep{Version: m.Version, Name: m.Name, Action: action}
+	start := time.Now()
+	sql, err := applyMigration(db, b.recorders[m.DB], m, action)
+	step.SQL = sql
+	step.Cost = time.Since(start).Milliseconds()
+	if err != nil {
+		return step, fmt.Errorf("迁移 %s %s 失败: %w", m.Version, action, err)
+	}
+	return step, nil
+}
+
+// close 回滚dry-run的事务
+func (b *migrationRun) close() {
+	for _, tx := range b.txs {
+		tx.Rollback()
+	}
+}
+
+// RunMigrations 按注册顺序执行所有还没执行过的迁移，dryRun为true时只返回会执行的SQL，不会真正修改数据库
+func RunMigrations(ctx *Context, dryRun bool) (*MigrationResult, error) {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+
+	run := newMigrationRun(ctx, dryRun)
+	defer run.close()
+	result := &MigrationResult{DryRun: dryRun}
+	for _, m := range migrations {
+		db, err := run.db(m)
+		if err != nil {
+			return result, err
+		}
+		rec, err := getMigrationRecord(db, m.Version)
+		if err != nil {
+			return result, err
+		}
+		if rec != nil {
+			continue
+		}
+		step, err := run.step(db, m, "up")
+		if step != nil {
+			result.Steps = append(result.Steps, step)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// MigrationVersionZero 回滚到初始状态，即回滚全部迁移
+const MigrationVersionZero = "0"
+
+// RollbackMigrations 倒序回滚 toVersion 之后执行过的迁移（不包括toVersion本身），
+// toVersion 必须明确指定，MigrationVersionZero 表示全部回滚
+func RollbackMigrations(ctx *Context, toVersion string, dryRun bool) (*MigrationResult, error) {
+	if toVersion == "" {
+		return nil, Err(ctx).Validation().Field("to", "请指定回滚到的版本，全部回滚请传 "+MigrationVersionZero).Build()
+	}
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+
+	start := 0
+	if toVersion != MigrationVersionZero {
+		start = -1
+		for i, m := range migrations {
+			if m.Version == toVersion {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return nil, Err(ctx).Validation().Field("to", "版本不存在: "+toVersion).Build()
+		}
+	}
+
+	run := newMigrationRun(ctx, dryRun)
+	defer run.close()
+	result := &MigrationResult{DryRun: dryRun}
+	for i := len(migrations) - 1; i >= start; i-- {
+		m := migrations[i]
+		db, err := run.db(m)
+		if err != nil {
+			return result, err
+		}
+		rec, err := getMigrationRecord(db, m.Version)
+		if err != nil {
+			return result, err
+		}
+		if rec == nil {
+			continue
+		}
+		if m.Down == nil {
+			return result, Err(ctx).Code("E_MIGRATION_IRREVERSIBLE").Msg("迁移不支持回滚: " + m.Version).
+				Hint("请给该迁移实现 Down").Build()
+		}
+		step, err := run.step(db, m, "down")
+		if step != nil {
+			result.Steps = append(result.Steps, step)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// MigrationStatuses 所有注册的迁移以及是否已经执行
+func MigrationStatuses(ctx *Context) ([]*MigrationStatus, error) {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+
+	var list []*MigrationStatus
+	for _, m := range migrations {
+		db, err := migrationDB(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		rec, err := getMigrationRecord(db, m.Version)
+		if err != nil {
+			return nil, err
+		}
+		status := &MigrationStatus{Version: m.Version, Name: m.Name, DB: m.DB, Rollbackable: m.Down != nil}
+		if rec != nil {
+			status.Applied = true
+			status.AppliedAt = rec.AppliedAt
+			status.RunnerVersion = rec.RunnerVersion
+		}
+		list = append(list, status)
+	}
+	return list, nil
+}
+
+// autoRunMigrations 在 OnApiCreated/OnVersionChange 回调时自动执行迁移
+func autoRunMigrations(ctx *Context) error {
+	if !hasMigrations() {
+		return nil
+	}
+	result, err := RunMigrations(ctx, false)
+	if err != nil {
+		logger.Errorf(ctx, "自动执行迁移失败: %v", err)
+		return err
+	}
+	if len(result.Steps) > 0 {
+		logger.Infof(ctx, "自动执行迁移完成，共执行 %d 个", len(result.Steps))
+	}
+	return nil
+}
+
+// MigrateReq 执行迁移的请求
+type MigrateReq struct {
+	DryRun bool `json:"dry_run" form:"dry_run"`
+}
+
+// MigrateRollbackReq 回滚迁移的请求，To必填，"0"表示全部回滚
+type MigrateRollbackReq struct {
+	To     string `json:"to" form:"to"`
+	DryRun bool   `json:"dry_run" form:"dry_run"`
+}
+
+func (r *Runner) _migrations(ctx *Context, req *request.NoData, resp response.Response) error {
+	list, err := MigrationStatuses(ctx)
+	if err != nil {
+		return err
+	}
+	return resp.Form(map[string]interface{}{"migrations": list}).Build()
+}
+
+// _migrate 执行迁移会修改表结构，和回滚一样要求 RUNNER_MIGRATE_ROLE 角色，dry-run只预览不需要
+func (r *Runner) _migrate(ctx *Context, req *MigrateReq, resp response.Response) error {
+	if !req.DryRun {
+		if err := checkMigrateRole(ctx, "执行迁移"); err != nil {
+			return err
+		}
+	}
+	result, err := RunMigrations(ctx, req.DryRun)
+	if err != nil {
+		return err
+	}
+	return resp.Form(result).Build()
+}
+
+// migrateRole 可以通过接口执行和回滚迁移的角色，环境变量 RUNNER_MIGRATE_ROLE，默认admin
+func migrateRole() string {
+	return getEnvOrDefault("RUNNER_MIGRATE_ROLE", "admin")
+}
+
+// checkMigrateRole 内置路由不经过函数的权限检查，修改表结构的操作单独要求 migrateRole 角色
+func checkMigrateRole(ctx *Context, action string) error {
+	if user := ctx.UserInfo(); !user.IsLoggedIn || !user.HasRole(migrateRole()) {
+		return Err(ctx).Code("E_FORBIDDEN").Msg("没有权限").
+			Detail(action + "需要角色: " + migrateRole()).Build()
+	}
+	return nil
+}
+
+// _migrateRollback 回滚会删除表结构和数据，要求管理员角色
+func (r *Runner) _migrateRollback(ctx *Context, req *MigrateRollbackReq, resp response.Response) error {
+	if err := checkMigrateRole(ctx, "回滚迁移"); err != nil {
+		return err
+	}
+	result, err := RollbackMigrations(ctx, req.To, req.DryRun)
+	if err != nil {
+		return err
+	}
+	return resp.Form(result).Build()
+}
@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/pkg/trace"
+	"gorm.io/gorm"
+)
+
+type migrationOrder struct {
+	ID     int `gorm:"primaryKey"`
+	Amount int
+}
+
+type migrationOrderV2 struct {
+	ID     int `gorm:"primaryKey"`
+	Amount int
+	Remark string
+}
+
+func (migrationOrderV2) TableName() string {
+	return "migration_orders"
+}
+
+func TestMigrations(t *testing.T) {
+	migrationLock.Lock()
+	migrations = nil
+	migrationLock.Unlock()
+	t.Cleanup(func() {
+		migrationLock.Lock()
+		migrations = nil
+		migrationLock.Unlock()
+		CloseAllDBs()
+	})
+	if err := RegisterDB("migration_test", &DBConfig{DSN: filepath.Join(t.TempDir(), "m.db")}); err != nil {
+		t.Fatalf("注册数据库失败: %v", err)
+	}
+
+	RegisterMigration(
+		Migration{
+			Version: "v1",
+			Name:    "创建订单表",
+			DB:      "migration_test",
+			Up:      func(tx *gorm.DB) error { return tx.AutoMigrate(&migrationOrder{}) },
+			Down:    func(tx *gorm.DB) error { return tx.Migrator().DropTable(&migrationOrder{}) },
+		},
+		Migration{
+			Version: "v2",
+			Name:    "订单表增加备注",
+			DB:      "migration_test",
+			Up:      func(tx *gorm.DB) error { return tx.Migrator().AddColumn(&migrationOrderV2{}, "Remark") },
+			Down:    func(tx *gorm.DB) error { return tx.Migrator().DropColumn(&migrationOrderV2{}, "Remark") },
+		},
+	)
+	ctx := &Context{Context: context.Background()}
+	db := ctx.DB("migration_test")
+
+	// 整批预览在同一个事务里执行，v2能看到v1建的表，结束后统一回滚
+	preview, err := RunMigrations(ctx, true)
+	if err != nil || len(preview.Steps) != 2 || !strings.Contains(strings.Join(preview.Steps[1].SQL, ";"), "remark") {
+		t.Fatalf("整批预览失败: %v %+v", err, preview)
+	}
+	if db.Migrator().HasTable(&migrationOrder{}) {
+		t.Fatal("dry-run 不应该建表")
+	}
+
+	// 先只执行v1，再预览v2
+	if _, err := runMigrationStep(ctx, mustMigrationDB(t, ctx, migrations[0]), migrations[0], "up"); err != nil {
+		t.Fatalf("执行v1失败: %v", err)
+	}
+	preview, err = RunMigrations(ctx, true)
+	if err != nil {
+		t.Fatalf("预览失败: %v", err)
+	}
+	if len(preview.Steps) != 1 || preview.Steps[0].Version != "v2" || !strings.Contains(strings.Join(preview.Steps[0].SQL, ";"), "remark") {
+		t.Fatalf("预览结果不对: %+v", preview.Steps)
+	}
+	if db.Migrator().HasColumn(&migrationOrderV2{}, "Remark") {
+		t.Fatal("dry-run 不应该修改表结构")
+	}
+
+	var appErr *AppError
+	err = newTestRunner()._migrate(ctx, &MigrateReq{}, &response.RunFunctionResp{})
+	if !errors.As(err, &appErr) || appErr.Code != "E_FORBIDDEN" || db.Migrator().HasColumn(&migrationOrderV2{}, "Remark") {
+		t.Fatalf("没有登录不能通过接口执行迁移: %v", err)
+	}
+	if err := newTestRunner()._migrate(ctx, &MigrateReq{DryRun: true}, &response.RunFunctionResp{}); err != nil {
+		t.Fatalf("dry-run不需要管理员角色: %v", err)
+	}
+
+	result, err := RunMigrations(ctx, false)
+	if err != nil || len(result.Steps) != 1 {
+		t.Fatalf("执行迁移失败: %v %+v", err, result)
+	}
+	if !db.Migrator().HasColumn(&migrationOrderV2{}, "Remark") {
+		t.Fatal("v2 应该增加remark字段")
+	}
+	if result, _ = RunMigrations(ctx, false); len(result.Steps) != 0 {
+		t.Fatalf("重复执行不应该再执行迁移: %+v", result.Steps)
+	}
+
+	if _, err := RollbackMigrations(ctx, "v1", false); err != nil {
+		t.Fatalf("回滚失败: %v", err)
+	}
+	if db.Migrator().HasColumn(&migrationOrderV2{}, "Remark") {
+		t.Fatal("回滚到v1后remark字段应该被删除")
+	}
+	statuses, err := MigrationStatuses(ctx)
+	if err != nil {
+		t.Fatalf("查询状态失败: %v", err)
+	}
+	if !statuses[0].Applied || statuses[1].Applied {
+		t.Fatalf("回滚后v1已执行、v2未执行，实际: %+v %+v", statuses[0], statuses[1])
+	}
+
+	if _, err := RollbackMigrations(ctx, "", false); err == nil {
+		t.Fatal("没有指定版本不应该回滚")
+	}
+	ctx.FunctionMsg = &trace.FunctionMsg{RequestUser: `{"id":"1","roles":["sales"]}`}
+	err = newTestRunner()._migrateRollback(ctx, &MigrateRollbackReq{To: MigrationVersionZero}, &response.RunFunctionResp{})
+	if !errors.As(err, &appErr) || appErr.Code != "E_FORBIDDEN" || !db.Migrator().HasTable(&migrationOrder{}) {
+		t.Fatalf("非管理员不能回滚: %v", err)
+	}
+	if _, err := RollbackMigrations(ctx, MigrationVersionZero, false); err != nil || db.Migrator().HasTable(&migrationOrder{}) {
+		t.Fatalf("全部回滚后订单表应该被删除: %v", err)
+	}
+}
+
+func mustMigrationDB(t *testing.T, ctx *Context, m *Migration) *gorm.DB {
+	db, err := migrationDB(ctx, m)
+	if err != nil {
+		t.Fatalf("获取迁移数据库失败: %v", err)
+	}
+	return db
+}
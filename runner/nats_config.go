@@ -0,0 +1,297 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/pflag"
+	"github.com/yunhanshu-net/pkg/logger"
+)
+
+// NatsConfig runner连接NATS的配置，默认从环境变量读取，connect命令的flag可以覆盖
+//
+//	NATS_URL               服务地址，多个用逗号分隔，默认 nats://127.0.0.1:4222
+//	NATS_CREDS             .creds 凭证文件
+//	NATS_NKEY              NKey 种子文件
+//	NATS_TOKEN             token
+//	NATS_USER/NATS_PASSWORD 用户名密码
+//	NATS_TLS_CERT/NATS_TLS_KEY 客户端证书
+//	NATS_TLS_CA            CA证书
+//	NATS_MAX_RECONNECTS    断线后最大重连次数，默认10，-1表示一直重连
+//	NATS_RECONNECT_WAIT_MS 断线重连的初始间隔，默认1000毫秒，之后指数退避
+//	NATS_CONNECT_RETRIES   启动时连接的重试次数，默认3
+//	NATS_NAME_PREFIX       连接名前缀，默认 runner
+//	NATS_REPLY_MODE        响应方式 reply/publish，默认 publish（兼容旧版本的function-server），支持的部署配置为 reply，见 ReplyModeReply
+//	NATS_RESPONSE_SUBJECT  publish方式或者请求没有reply subject时响应推送的subject，默认 function-runner.sub
+//	NATS_CHUNK_SIZE        响应分片的大小（字节），默认按服务端的 max_payload 计算
+//	NATS_RESPONSE_BUCKET   响应过大且调用方不支持分片时保存响应的对象存储，默认 runner_responses
+type NatsConfig struct {
+	URLs          []string
+	CredsFile     string
+	NKeyFile      string
+	Token         string
+	User          string
+	Password      string
+	TLSCert       string
+	TLSKey        string
+	TLSCA         string
+	MaxReconnects int
+	ReconnectWait time.Duration
+	MaxBackoff    time.Duration // 指数退避的最大间隔
+	ConnectRetry  int
+	NamePrefix    string
+
+	ReplyMode       string
+	ResponseSubject string
+	ChunkSize       int
+	ResponseBucket  string
+}
+
+func loadNatsConfig() *NatsConfig {
+	return &NatsConfig{
+		URLs:          splitNatsURLs(getEnvOrDefault("NATS_URL", nats.DefaultURL)),
+		CredsFile:     getEnvOrDefault("NATS_CREDS", ""),
+		NKeyFile:      getEnvOrDefault("NATS_NKEY", ""),
+		Token:         getEnvOrDefault("NATS_TOKEN", ""),
+		User:          getEnvOrDefault("NATS_USER", ""),
+		Password:      getEnvOrDefault("NATS_PASSWORD", ""),
+		TLSCert:       getEnvOrDefault("NATS_TLS_CERT", ""),
+		TLSKey:        getEnvOrDefault("NATS_TLS_KEY", ""),
+		TLSCA:         getEnvOrDefault("NATS_TLS_CA", ""),
+		MaxReconnects: getEnvIntOrDefault("NATS_MAX_RECONNECTS", 10),
+		ReconnectWait: time.Duration(getEnvIntOrDefault("NATS_RECONNECT_WAIT_MS", 1000)) * time.Millisecond,
+		MaxBackoff:    30 * time.Second,
+		ConnectRetry:  getEnvIntOrDefault("NATS_CONNECT_RETRIES", 3),
+		NamePrefix:    getEnvOrDefault("NATS_NAME_PREFIX", "runner"),
+
+		ReplyMode:       getEnvOrDefault("NATS_REPLY_MODE", ReplyModePublish),
+		ResponseSubject: getEnvOrDefault("NATS_RESPONSE_SUBJECT", "function-runner.sub"),
+		ChunkSize:       getEnvIntOrDefault("NATS_CHUNK_SIZE", 0),
+		ResponseBucket:  getEnvOrDefault("NATS_RESPONSE_BUCKET", "runner_responses"),
+	}
+}
+
+func splitNatsURLs(s string) []string {
+	var urls []string
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// addNatsFlags 给connect命令添加NATS相关的flag，不填时使用环境变量的值
+func addNatsFlags(flags *pflag.FlagSet) {
+	flags.String("nats_url", "", "NATS地址，多个用逗号分隔")
+	flags.String("nats_creds", "", "NATS .creds 凭证文件")
+	flags.String("nats_nkey", "", "NATS NKey 种子文件")
+	flags.String("nats_token", "", "NATS token")
+	flags.String("nats_user", "", "NATS 用户名")
+	flags.String("nats_password", "", "NATS 密码")
+	flags.String("nats_tls_cert", "", "TLS客户端证书")
+	flags.String("nats_tls_key", "", "TLS客户端私钥")
+	flags.String("nats_tls_ca", "", "TLS CA证书")
+	flags.Int("nats_max_reconnects", 0, "最大重连次数，-1表示一直重连")
+	flags.Duration("nats_reconnect_wait", 0, "重连初始间隔")
+	flags.Int("nats_connect_retries", 0, "启动时连接的重试次数")
+	flags.String("nats_name_prefix", "", "连接名前缀")
+	flags.String("nats_reply_mode", "", "响应方式 reply/publish")
+}
+
+// applyFlags 用命令行显式传入的flag覆盖配置
+func (c *NatsConfig) applyFlags(flags *pflag.FlagSet) {
+	strFlags := map[string]*string{
+		"nats_creds":       &c.CredsFile,
+		"nats_nkey":        &c.NKeyFile,
+		"nats_token":       &c.Token,
+		"nats_user":        &c.User,
+		"nats_password":    &c.Password,
+		"nats_tls_cert":    &c.TLSCert,
+		"nats_tls_key":     &c.TLSKey,
+		"nats_tls_ca":      &c.TLSCA,
+		"nats_name_prefix": &c.NamePrefix,
+		"nats_reply_mode":  &c.ReplyMode,
+	}
+	for name, p := range strFlags {
+		if flags.Changed(name) {
+			*p, _ = flags.GetString(name)
+		}
+	}
+	if flags.Changed("nats_url") {
+		v, _ := flags.GetString("nats_url")
+		c.URLs = splitNatsURLs(v)
+	}
+	if flags.Changed("nats_max_reconnects") {
+		c.MaxReconnects, _ = flags.GetInt("nats_max_reconnects")
+	}
+	if flags.Changed("nats_reconnect_wait") {
+		c.ReconnectWait, _ = flags.GetDuration("nats_reconnect_wait")
+	}
+	if flags.Changed("nats_connect_retries") {
+		c.ConnectRetry, _ = flags.GetInt("nats_connect_retries")
+	}
+}
+
+// backoff 第attempt次(从0开始)重试的等待时间，按ReconnectWait指数增长，不超过MaxBackoff
+func (c *NatsConfig) backoff(attempt int) time.Duration {
+	wait := c.ReconnectWait
+	if wait <= 0 {
+		wait = time.Second
+	}
+	for i := 0; i < attempt && wait < c.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if c.MaxBackoff > 0 && wait > c.MaxBackoff {
+		wait = c.MaxBackoff
+	}
+	return wait
+}
+
+// authMethod 使用的认证方式，用于展示，不包含敏感信息
+func (c *NatsConfig) authMethod() string {
+	switch {
+	case c.CredsFile != "":
+		return "creds"
+	case c.NKeyFile != "":
+		return "nkey"
+	case c.Token != "":
+		return "token"
+	case c.User != "":
+		return "user"
+	}
+	return "none"
+}
+
+func (c *NatsConfig) tlsEnabled() bool {
+	if c.TLSCert != "" || c.TLSCA != "" {
+		return true
+	}
+	for _, u := range c.URLs {
+		if strings.HasPrefix(u, "tls://") {
+			return true
+		}
+	}
+	return false
+}
+
+// options 根据配置生成连接选项，name会拼在前缀后面
+func (c *NatsConfig) options(name string) ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.Name(c.NamePrefix + "_" + name),
+		nats.MaxReconnects(c.MaxReconnects),
+		nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			return c.backoff(attempts - 1)
+		}),
+	}
+	switch {
+	case c.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(c.CredsFile))
+	case c.NKeyFile != "":
+		opt, err := nats.NkeyOptionFromSeed(c.NKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取NKey失败: %w", err)
+		}
+		opts = append(opts, opt)
+	case c.Token != "":
+		opts = append(opts, nats.Token(c.Token))
+	case c.User != "":
+		opts = append(opts, nats.UserInfo(c.User, c.Password))
+	}
+	if c.TLSCert != "" || c.TLSKey != "" {
+		if c.TLSCert == "" || c.TLSKey == "" {
+			return nil, fmt.Errorf("TLS证书和私钥需要同时配置")
+		}
+		opts = append(opts, nats.ClientCert(c.TLSCert, c.TLSKey))
+	}
+	if c.TLSCA != "" {
+		opts = append(opts, nats.RootCAs(c.TLSCA))
+	}
+	return opts, nil
+}
+
+func (c *NatsConfig) url() string {
+	return strings.Join(c.URLs, ",")
+}
+
+// connect 连接NATS，失败时按指数退避重试ConnectRetry次
+func (c *NatsConfig) connect(ctx context.Context, name string, extra ...nats.Option) (*nats.Conn, error) {
+	opts, err := c.options(name)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, extra...)
+
+	retries := c.ConnectRetry
+	if retries <= 0 {
+		retries = 1
+	}
+	for i := 0; i < retries; i++ {
+		logger.Infof(ctx, "正在连接NATS服务器 %s (尝试: %d/%d)", redactNatsURLs(c.URLs), i+1, retries)
+		var nc *nats.Conn
+		nc, err = nats.Connect(c.url(), opts...)
+		if err == nil {
+			return nc, nil
+		}
+		if i < retries-1 {
+			wait := c.backoff(i)
+			logger.Warnf(ctx, "NATS连接失败，将在%v后重试: %v", wait, err)
+			time.Sleep(wait)
+		}
+	}
+	return nil, err
+}
+
+// redactNatsURLs 去掉地址里的密码，用于日志和状态展示
+func redactNatsURLs(urls []string) string {
+	list := make([]string, 0, len(urls))
+	for _, s := range urls {
+		if u, err := url.Parse(s); err == nil && u.User != nil {
+			s = u.Redacted()
+		}
+		list = append(list, s)
+	}
+	return strings.Join(list, ",")
+}
+
+var (
+	natsConfigLock = new(sync.Mutex)
+	natsConfig     *NatsConfig
+)
+
+// getNatsConfig 进程内共用一份配置，connect命令解析flag后会覆盖
+func getNatsConfig() *NatsConfig {
+	natsConfigLock.Lock()
+	defer natsConfigLock.Unlock()
+	if natsConfig == nil {
+		natsConfig = loadNatsConfig()
+	}
+	return natsConfig
+}
+
+// natsStatus 当前NATS连接的状态，展示在 /_env
+func natsStatus(nc *nats.Conn) map[string]interface{} {
+	cfg := getNatsConfig()
+	status := map[string]interface{}{
+		"urls":   redactNatsURLs(cfg.URLs),
+		"auth":   cfg.authMethod(),
+		"tls":    cfg.tlsEnabled(),
+		"reply":  cfg.ReplyMode,
+		"status": "DISCONNECTED",
+	}
+	if nc == nil {
+		return status
+	}
+	status["status"] = nc.Status().String()
+	status["connected_url"] = nc.ConnectedUrlRedacted()
+	status["reconnects"] = nc.Stats().Reconnects
+	if err := nc.LastError(); err != nil {
+		status["last_error"] = err.Error()
+	}
+	return status
+}
@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/pflag"
+	"github.com/yunhanshu-net/pkg/constants"
+)
+
+func TestNatsConfig(t *testing.T) {
+	t.Setenv("NATS_URL", "nats://a:4222, nats://user:secret@b:4222")
+	t.Setenv("NATS_TOKEN", "t1")
+	cfg := loadNatsConfig()
+	if len(cfg.URLs) != 2 || cfg.authMethod() != "token" {
+		t.Fatalf("环境变量解析不对: %+v", cfg)
+	}
+	if got := redactNatsURLs(cfg.URLs); got != "nats://a:4222,nats://user:xxxxx@b:4222" {
+		t.Fatalf("密码应该被隐藏: %s", got)
+	}
+
+	flags := pflag.NewFlagSet("connect", pflag.ContinueOnError)
+	addNatsFlags(flags)
+	if err := flags.Parse([]string{"--nats_url=tls://c:4222", "--nats_creds=/x.creds", "--nats_reconnect_wait=100ms"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg.applyFlags(flags)
+	if cfg.url() != "tls://c:4222" || cfg.authMethod() != "creds" || !cfg.tlsEnabled() || cfg.Token != "t1" {
+		t.Fatalf("flag应该覆盖环境变量，未传的保持不变: %+v", cfg)
+	}
+
+	cfg.MaxBackoff = time.Second
+	var waits []time.Duration
+	for i := 0; i < 6; i++ {
+		waits = append(waits, cfg.backoff(i))
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Fatalf("指数退避不对: %v", waits)
+		}
+	}
+
+	cfg.TLSCert = "cert.pem"
+	if _, err := cfg.options("x"); err == nil {
+		t.Fatal("只配置证书没有私钥应该报错")
+	}
+
+	t.Setenv("NATS_USER", "env-user")
+	cfg = loadNatsConfig()
+	flags = pflag.NewFlagSet("connect", pflag.ContinueOnError)
+	addNatsFlags(flags)
+	if err := flags.Parse([]string{"--nats_user=u1", "--nats_password=p1"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg.applyFlags(flags)
+	if cfg.User != "u1" || cfg.Password != "p1" {
+		t.Fatalf("用户名密码的flag应该覆盖环境变量: %+v", cfg)
+	}
+	if status := newTestRunner().natsStatus(); status != nats.CLOSED {
+		t.Fatalf("没有连接时应该是CLOSED: %v", status)
+	}
+}
+
+func TestNatsReply(t *testing.T) {
+	t.Setenv("NATS_REPLY_MODE", "")
+	cfg := loadNatsConfig()
+	if cfg.ReplyMode != ReplyModePublish {
+		t.Fatalf("默认应该是publish方式: %s", cfg.ReplyMode)
+	}
+	if got := cfg.replySubject(&nats.Msg{Reply: "_INBOX.1"}); got != "function-runner.sub" {
+		t.Fatalf("publish方式总是推送到固定subject: %s", got)
+	}
+	cfg.ReplyMode = ReplyModeReply
+	if got := cfg.replySubject(&nats.Msg{Reply: "_INBOX.1"}); got != "_INBOX.1" {
+		t.Fatalf("有reply subject时应该直接回复: %s", got)
+	}
+	if got := cfg.replySubject(&nats.Msg{}); got != "function-runner.sub" {
+		t.Fatalf("没有reply subject时应该推送到固定subject: %s", got)
+	}
+
+	if acceptsHeader(&nats.Msg{}, natsHeaderAcceptChunked) || !acceptsHeader(&nats.Msg{Header: nats.Header{natsHeaderAcceptChunked: []string{"true"}}}, natsHeaderAcceptChunked) {
+		t.Fatal("只有声明了 accept-chunked 的调用方才分片")
+	}
+
+	req := &nats.Msg{Header: nats.Header{"user": []string{"u1"}}}
+	h := responseHeader(req)
+	setResponseHeader(h, "0", "", "trace-1")
+	if req.Header.Get(natsHeaderCode) != "" {
+		t.Fatal("不应该修改请求头")
+	}
+	if h.Get("user") != "u1" || h.Get(natsHeaderCode) != "0" || h.Get(constants.TraceID) != "trace-1" {
+		t.Fatalf("响应头不对: %v", h)
+	}
+}
@@ -0,0 +1,170 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/yunhanshu-net/pkg/constants"
+	"github.com/yunhanshu-net/pkg/logger"
+)
+
+// 响应的回复方式，见 NatsConfig.ReplyMode
+const (
+	ReplyModeReply   = "reply"   // 有 msg.Reply 时回复到请求的reply subject，没有时退回到固定subject
+	ReplyModePublish = "publish" // 总是推送到固定subject（fire-and-forget），兼容旧版本的function-server
+)
+
+// 响应头，所有响应都会带上 code/msg/trace_id
+const (
+	natsHeaderCode = "code" // 0成功，-1失败，429繁忙
+	natsHeaderMsg  = "msg"
+
+	// 响应超过单条消息的最大长度时，请求头 accept-chunked=true 的调用方收到分片，所有分片发到同一个subject，按 chunk-index 拼接
+	natsHeaderAcceptChunked = "accept-chunked"
+	natsHeaderChunkID       = "chunk-id"    // 同一个响应的分片id相同，使用trace id
+	natsHeaderChunkIndex    = "chunk-index" // 从0开始
+	natsHeaderChunkTotal    = "chunk-total"
+
+	// 不支持分片的调用方（例如普通的 nc.Request）只会收到一条消息，响应保存到对象存储，消息体为空，
+	// payload-ref 是 bucket/name，调用方从对象存储读取，对象10分钟后过期
+	natsHeaderPayloadRef  = "payload-ref"
+	natsHeaderPayloadSize = "payload-size"
+)
+
+// acceptsHeader 请求头里的开关，例如 accept-chunked、accept-stream
+func acceptsHeader(req *nats.Msg, key string) bool {
+	ok, _ := strconv.ParseBool(req.Header.Get(key))
+	return ok
+}
+
+// setResponseHeader 设置标准的响应头
+func setResponseHeader(h nats.Header, code string, msg string, traceID string) {
+	h.Set(natsHeaderCode, code)
+	if msg != "" {
+		h.Set(natsHeaderMsg, msg)
+	}
+	if traceID != "" {
+		h.Set(constants.TraceID, traceID)
+	}
+}
+
+// replySubject 响应要发到的subject
+func (c *NatsConfig) replySubject(req *nats.Msg) string {
+	if c.ReplyMode != ReplyModePublish && req.Reply != "" {
+		return req.Reply
+	}
+	return c.ResponseSubject
+}
+
+// chunkLimit 单条消息data的最大长度，预留一部分给消息头
+func (c *NatsConfig) chunkLimit(nc *nats.Conn) int {
+	if c.ChunkSize > 0 {
+		return c.ChunkSize
+	}
+	limit := int(nc.MaxPayload()) - 8*1024
+	if limit <= 0 {
+		limit = int(nc.MaxPayload())
+	}
+	return limit
+}
+
+// splitChunks 按limit把data切成多段，limit<=0或者data不超过limit时只有一段
+func splitChunks(data []byte, limit int) [][]byte {
+	if limit <= 0 || len(data) <= limit {
+		return [][]byte{data}
+	}
+	chunks := make([][]byte, 0, (len(data)+limit-1)/limit)
+	for len(data) > limit {
+		chunks = append(chunks, data[:limit])
+		data = data[limit:]
+	}
+	return append(chunks, data)
+}
+
+// respond 把响应发给请求方，响应头沿用请求头，超过最大长度时按调用方是否支持分片，分片发送或者保存到对象存储
+func (r *Runner) respond(ctx context.Context, req *nats.Msg, header nats.Header, data []byte) error {
+	nc := r.conn()
+	if nc == nil {
+		return nats.ErrConnectionClosed
+	}
+	cfg := getNatsConfig()
+	subject := cfg.replySubject(req)
+
+	chunks := splitChunks(data, cfg.chunkLimit(nc))
+	if len(chunks) == 1 {
+		msg := nats.NewMsg(subject)
+		msg.Header = header
+		msg.Data = data
+		return nc.PublishMsg(msg)
+	}
+	if !acceptsHeader(req, natsHeaderAcceptChunked) {
+		return r.respondRef(ctx, nc, cfg, subject, header, data)
+	}
+
+	chunkID := header.Get(constants.TraceID)
+	for i, chunk := range chunks {
+		msg := nats.NewMsg(subject)
+		for k, v := range header {
+			msg.Header[k] = v
+		}
+		msg.Header.Set(natsHeaderChunkID, chunkID)
+		msg.Header.Set(natsHeaderChunkIndex, strconv.Itoa(i))
+		msg.Header.Set(natsHeaderChunkTotal, strconv.Itoa(len(chunks)))
+		msg.Data = chunk
+		if err := nc.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// respondRef 把过大的响应保存到对象存储，只回复一条带 payload-ref 的消息，保存失败时回复错误
+func (r *Runner) respondRef(ctx context.Context, nc *nats.Conn, cfg *NatsConfig, subject string, header nats.Header, data []byte) error {
+	name := header.Get(constants.TraceID)
+	if name == "" {
+		name = uuid.NewString()
+	}
+	msg := nats.NewMsg(subject)
+	msg.Header = header
+	if err := putResponseObject(nc, cfg.ResponseBucket, name, data); err != nil {
+		logger.Errorf(ctx, "保存过大的响应失败: %v", err)
+		err = fmt.Errorf("响应过大(%d字节)，调用方不支持分片并且保存到对象存储失败: %w", len(data), err)
+		setResponseHeader(msg.Header, "-1", err.Error(), header.Get(constants.TraceID))
+		msg.Data, _ = json.Marshal(newErrorResp(err))
+		return nc.PublishMsg(msg)
+	}
+	msg.Header.Set(natsHeaderPayloadRef, cfg.ResponseBucket+"/"+name)
+	msg.Header.Set(natsHeaderPayloadSize, strconv.Itoa(len(data)))
+	return nc.PublishMsg(msg)
+}
+
+func putResponseObject(nc *nats.Conn, bucket string, name string, data []byte) error {
+	js, err := nc.JetStream()
+	if err != nil {
+		return err
+	}
+	store, err := js.ObjectStore(bucket)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		store, err = js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: bucket, Description: "runner large responses", TTL: 10 * time.Minute})
+	}
+	if err != nil {
+		return err
+	}
+	_, err = store.PutBytes(name, data)
+	return err
+}
+
+// responseHeader 响应头沿用请求头，function-server靠请求头里的信息匹配请求
+func responseHeader(req *nats.Msg) nats.Header {
+	h := nats.Header{}
+	for k, v := range req.Header {
+		h[k] = v
+	}
+	return h
+}
@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/yunhanshu-net/pkg/constants"
+)
+
+// useTestNatsConfig 测试期间替换进程内共用的NATS配置
+func useTestNatsConfig(t *testing.T, cfg *NatsConfig) {
+	natsConfigLock.Lock()
+	old := natsConfig
+	natsConfig = cfg
+	natsConfigLock.Unlock()
+	t.Cleanup(func() {
+		natsConfigLock.Lock()
+		natsConfig = old
+		natsConfigLock.Unlock()
+	})
+}
+
+func TestSplitChunks(t *testing.T) {
+	cases := []struct {
+		name  string
+		data  string
+		limit int
+		want  []string
+	}{
+		{"空数据", "", 4, []string{""}},
+		{"不限制大小", "abcdef", 0, []string{"abcdef"}},
+		{"正好等于limit", "abcd", 4, []string{"abcd"}},
+		{"超过limit一个字节", "abcde", 4, []string{"abcd", "e"}},
+		{"正好是limit的整数倍", "abcdefgh", 4, []string{"abcd", "efgh"}},
+		{"最后一段不满", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+	}
+	for _, c := range cases {
+		chunks := splitChunks([]byte(c.data), c.limit)
+		got := make([]string, 0, len(chunks))
+		for _, chunk := range chunks {
+			got = append(got, string(chunk))
+		}
+		if strings.Join(got, "|") != strings.Join(c.want, "|") || len(got) != len(c.want) {
+			t.Fatalf("%s: 分片不对 %q，期望 %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRespondChunked(t *testing.T) {
+	nc := connectTestNats(t)
+	r := newTestRunner()
+	r.natsConn.Store(nc)
+	useTestNatsConfig(t, &NatsConfig{ReplyMode: ReplyModeReply, ResponseSubject: "test.response", ChunkSize: 4})
+
+	sub, err := nc.SubscribeSync("_INBOX.chunked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &nats.Msg{Reply: "_INBOX.chunked", Header: nats.Header{natsHeaderAcceptChunked: []string{"true"}, constants.TraceID: []string{"trace-1"}}}
+	header := responseHeader(req)
+	setResponseHeader(header, "0", "", "trace-1")
+	if err := r.respond(context.Background(), req, header, []byte("abcdefghij")); err != nil {
+		t.Fatalf("发送分片失败: %v", err)
+	}
+
+	var data []byte
+	for i := 0; i < 3; i++ {
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("没有收到第%d个分片: %v", i, err)
+		}
+		if msg.Header.Get(natsHeaderChunkID) != "trace-1" || msg.Header.Get(natsHeaderChunkIndex) != strconv.Itoa(i) ||
+			msg.Header.Get(natsHeaderChunkTotal) != "3" || msg.Header.Get(natsHeaderCode) != "0" {
+			t.Fatalf("第%d个分片的响应头不对: %v", i, msg.Header)
+		}
+		data = append(data, msg.Data...)
+	}
+	if string(data) != "abcdefghij" {
+		t.Fatalf("分片拼接后的数据不对: %s", data)
+	}
+	if header.Get(natsHeaderChunkIndex) != "" {
+		t.Fatal("分片头不应该写回原来的响应头")
+	}
+}
+
+func TestRespondPayloadRef(t *testing.T) {
+	nc := connectTestNats(t)
+	r := newTestRunner()
+	r.natsConn.Store(nc)
+	useTestNatsConfig(t, &NatsConfig{ReplyMode: ReplyModePublish, ResponseSubject: "test.response", ResponseBucket: "responses_test", ChunkSize: 4})
+
+	sub, err := nc.SubscribeSync("test.response")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &nats.Msg{Reply: "_INBOX.ref", Header: nats.Header{constants.TraceID: []string{"trace-2"}}}
+	header := responseHeader(req)
+	setResponseHeader(header, "0", "", "trace-2")
+	if err := r.respond(context.Background(), req, header, []byte("abcdefghij")); err != nil {
+		t.Fatalf("发送响应失败: %v", err)
+	}
+
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("publish方式应该推送到固定subject: %v", err)
+	}
+	if len(msg.Data) != 0 || msg.Header.Get(natsHeaderPayloadRef) != "responses_test/trace-2" ||
+		msg.Header.Get(natsHeaderPayloadSize) != "10" || msg.Header.Get(natsHeaderChunkTotal) != "" {
+		t.Fatalf("不支持分片的调用方应该收到payload-ref: %v %q", msg.Header, msg.Data)
+	}
+	if _, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Fatal("不支持分片的调用方只应该收到一条消息")
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := js.ObjectStore("responses_test")
+	if err != nil {
+		t.Fatalf("对象存储不存在: %v", err)
+	}
+	data, err := store.GetBytes("trace-2")
+	if err != nil || string(data) != "abcdefghij" {
+		t.Fatalf("从对象存储读取的响应不对: %q %v", data, err)
+	}
+}
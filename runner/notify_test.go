@@ -0,0 +1,214 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyChannel 前failures次发送失败
+type flakyChannel struct {
+	name     string
+	failures int32
+	calls    atomic.Int32
+}
+
+func (c *flakyChannel) Name() string { return c.name }
+
+func (c *flakyChannel) Send(ctx context.Context, n *Notification) error {
+	if c.calls.Add(1) <= c.failures {
+		return errors.New("暂时不可用")
+	}
+	return nil
+}
+
+func TestNotifyTemplate(t *testing.T) {
+	mem := &MemoryChannel{ChannelName: fmt.Sprintf("mem_%d", time.Now().UnixNano())}
+	RegisterNotifyChannel(mem)
+	if err := RegisterNotifyTemplate("order_paid", "订单{{.no}}已支付", "金额 {{.amount}} 元"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewContext(context.Background(), "POST", "/order/pay", nil)
+
+	err := ctx.Notify().Channel(mem.Name()).To("a@b.com").
+		Template("order_paid", map[string]interface{}{"no": "N1", "amount": 9.5}).SendSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.Notify().Channel(mem.Name()).Subject("你好 {{.name}}").Body("router: {{.router}}").Var("name", "beiluo").SendSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := mem.Sent()
+	if len(sent) != 2 || sent[0].Subject != "订单N1已支付" || sent[0].Body != "金额 9.5 元" {
+		t.Fatalf("模板渲染错误: %+v", sent)
+	}
+	if sent[1].Subject != "你好 beiluo" || sent[1].Body != "router: /order/pay" {
+		t.Errorf("内联变量替换错误: %+v", sent[1])
+	}
+
+	var appErr *AppError
+	err = ctx.Notify().Channel(mem.Name()).Template("order_paid", map[string]interface{}{"no": "N1"}).SendSync()
+	if !errors.As(err, &appErr) || appErr.Code != "E_NOTIFY_TEMPLATE" {
+		t.Errorf("缺少变量应该报错: %v", err)
+	}
+	err = ctx.Notify().Channel("not_exist").Body("x").Send()
+	if !errors.As(err, &appErr) || appErr.Code != "E_NOTIFY_CHANNEL" {
+		t.Errorf("渠道不存在应该报错: %v", err)
+	}
+}
+
+func TestNotifyAsyncRetry(t *testing.T) {
+	t.Setenv("NOTIFY_RETRY_BACKOFF_MS", "1")
+	t.Setenv("NOTIFY_MAX_RETRIES", "2")
+	ctx := NewContext(context.Background(), "POST", "/x", nil)
+
+	ok := &flakyChannel{name: fmt.Sprintf("flaky_%d", time.Now().UnixNano()), failures: 2}
+	RegisterNotifyChannel(ok)
+	if err := ctx.GetMessageService().Send(ok.name, []string{"u1"}, "标题", "内容"); err != nil {
+		t.Fatal(err)
+	}
+	flushNotifications(context.Background(), time.Now().Add(5*time.Second))
+	if ok.calls.Load() != 3 {
+		t.Errorf("失败2次后第3次应该成功，实际发送%d次", ok.calls.Load())
+	}
+
+	fail := &flakyChannel{name: fmt.Sprintf("fail_%d", time.Now().UnixNano()), failures: 100}
+	RegisterNotifyChannel(fail)
+	if err := ctx.Notify().Channel(fail.name).Body("x").SendSync(); err == nil {
+		t.Error("一直失败应该返回错误")
+	}
+	if fail.calls.Load() != 3 {
+		t.Errorf("最多重试2次，实际发送%d次", fail.calls.Load())
+	}
+}
+
+func TestNotifyWebhookAndFile(t *testing.T) {
+	var got Notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Token") != "t1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(req.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	n := &Notification{ID: "1", To: []string{"u1"}, Subject: "s", Body: "b"}
+	if err := (&WebhookChannel{URL: srv.URL}).Send(context.Background(), n); err == nil {
+		t.Error("非2xx应该返回错误")
+	}
+	if err := (&WebhookChannel{URL: srv.URL, Headers: map[string]string{"X-Token": "t1"}}).Send(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "1" || got.Subject != "s" || len(got.To) != 1 {
+		t.Errorf("webhook收到: %+v", got)
+	}
+
+	path := t.TempDir() + "/notify/out.jsonl"
+	file := &FileChannel{Path: path}
+	for i := 0; i < 2; i++ {
+		if err := file.Send(context.Background(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("文件应该有2行: %s", data)
+	}
+}
+
+// fakeSMTPServer 最简单的SMTP服务器，hang为true时只接受连接不响应，收到的邮件写入mails
+func fakeSMTPServer(t *testing.T, hang bool, mails chan<- string) (string, int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				if hang {
+					_, _ = io.Copy(io.Discard, conn)
+					return
+				}
+				rd := bufio.NewReader(conn)
+				fmt.Fprint(conn, "220 fake\r\n")
+				var data strings.Builder
+				inData := false
+				for {
+					line, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if inData {
+						if line == ".\r\n" {
+							inData = false
+							mails <- data.String()
+							fmt.Fprint(conn, "250 ok\r\n")
+						} else {
+							data.WriteString(line)
+						}
+						continue
+					}
+					switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+					case "EHLO":
+						fmt.Fprint(conn, "250 fake\r\n")
+					case "DATA":
+						inData = true
+						fmt.Fprint(conn, "354 go\r\n")
+					case "QUIT":
+						fmt.Fprint(conn, "221 bye\r\n")
+						return
+					default:
+						fmt.Fprint(conn, "250 ok\r\n")
+					}
+				}
+			}(conn)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestSMTPChannelSend(t *testing.T) {
+	mails := make(chan string, 1)
+	host, port := fakeSMTPServer(t, false, mails)
+	ch := &SMTPChannel{Host: host, Port: port, From: "a@example.com"}
+	if err := ch.Send(context.Background(), &Notification{To: []string{"b@example.com"}, Subject: "hi", Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if mail := <-mails; !strings.Contains(mail, "hello") {
+		t.Fatalf("邮件内容不对: %s", mail)
+	}
+
+	// 服务器不响应时按ctx超时返回，不能一直阻塞
+	host, port = fakeSMTPServer(t, true, nil)
+	ch = &SMTPChannel{Host: host, Port: port, From: "a@example.com"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := ch.Send(ctx, &Notification{To: []string{"b@example.com"}, Subject: "hi", Body: "hello"})
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 2*time.Second {
+		t.Fatalf("应该按ctx超时返回: %v %v", err, time.Since(start))
+	}
+}
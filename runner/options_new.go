@@ -1,11 +1,8 @@
 package runner
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
-	"reflect"
 )
 
 // ==================== 函数组配置 ====================
@@ -64,13 +61,16 @@ type BaseConfig struct {
 	Group *FunctionGroup `json:"group"` // 函数组配置
 
 	// 执行配置
-	Async        bool         `json:"async"`
-	FunctionType FunctionType `json:"function_type"` // 函数类型：static/dynamic/pure
-	Timeout      int          `json:"timeout"`
+	Async          bool         `json:"async"`
+	FunctionType   FunctionType `json:"function_type"` // 函数类型：static/dynamic/pure
+	Timeout        int          `json:"timeout"`
+	MaxConcurrency int          `json:"max_concurrency"` // 单个路由的最大并发，超过时直接响应繁忙，0表示不限制
 
-	MustLogin bool `json:"must_login"`
+	MustLogin bool `json:"must_login"` // 需要登录，未登录时返回 E_UNAUTHORIZED
 	// 权限配置
-	IsPublicApi bool `json:"is_public_api"`
+	IsPublicApi bool         `json:"is_public_api"` // 公共api，不需要登录（包括 RUNNER_MUST_LOGIN=true 时）
+	Roles       []string     `json:"roles"`         // 允许调用的角色，为空表示不限制，见 permission.go
+	Policy      AccessPolicy `json:"-"`             // 自定义权限判断，在Roles之后执行
 
 	// 请求响应
 	Request  interface{} `json:"-"`
@@ -138,13 +138,16 @@ type TableFunctionOptions struct {
 	OnInputValidateMap map[string]OnInputValidate `json:"-"`
 
 	// 表格专用回调
-	OnTableDeleteRows OnTableDeleteRows `json:"-"`
-	OnTableUpdateRows OnTableUpdateRows `json:"-"`
-	OnTableAddRows    OnTableAddRows    `json:"-"`
-	OnTableSearch     OnTableSearch     `json:"-"`
+	OnTableDeleteRows  OnTableDeleteRows  `json:"-"`
+	OnTableUpdateRows  OnTableUpdateRows  `json:"-"`
+	OnTableAddRows     OnTableAddRows     `json:"-"`
+	OnTableSearch      OnTableSearch      `json:"-"`
+	OnTableRestoreRows OnTableRestoreRows `json:"-"` // 恢复软删除的数据，可选
 
 	// 表格特有配置
 	AutoCrudTable interface{} `json:"-"`
+	// EnableHistory 记录AutoCrudTable通过默认增删改产生的每一次变更到 <table>_history，支持查看行的历史和回滚到指定版本
+	EnableHistory bool `json:"enable_history"`
 }
 
 // ==================== 接口实现 ====================
@@ -374,6 +377,9 @@ func (opt *TableFunctionOptions) GetCallbacks() map[string]interface{} {
 	if opt.OnTableSearch != nil {
 		callbacks["OnTableSearch"] = opt.OnTableSearch
 	}
+	if opt.OnTableRestoreRows != nil {
+		callbacks[CallbackTypeOnTableRestoreRows] = opt.OnTableRestoreRows
+	}
 
 	return callbacks
 }
@@ -423,51 +429,14 @@ func (opt *TableFunctionOptions) GetOnInputValidateMap() map[string]interface{}
 	return result
 }
 
-func (opt *TableFunctionOptions) defaultDeleteRows(ctx *Context, req *usercall.OnTableDeleteRowsReq) error {
-	return ctx.MustGetOrInitDB().Model(opt.AutoCrudTable).Delete("id in ?", req.Ids).Error
+func (opt *TableFunctionOptions) defaultDeleteRows(ctx *Context, req *usercall.OnTableDeleteRowsReq) (*usercall.OnTableDeleteRowsResp, error) {
+	return autoCrudDeleteRows(ctx, opt.AutoCrudTable, req, opt.EnableHistory)
 }
 
 func (opt *TableFunctionOptions) defaultUpdateRows(ctx *Context, req *usercall.OnTableUpdateRowsReq) error {
-	for k, field := range req.Fields {
-		switch field.(type) {
-		case map[string]interface{}:
-			marshal, err := json.Marshal(field)
-			if err != nil {
-				return err
-			}
-			req.Fields[k] = json.RawMessage(marshal)
-		}
-	}
-
-	return ctx.MustGetOrInitDB().Model(opt.AutoCrudTable).Where("id in ?", req.Ids).Updates(req.Fields).Error
+	return autoCrudUpdateRows(ctx, opt.AutoCrudTable, req, opt.EnableHistory)
 }
 
 func (opt *TableFunctionOptions) defaultAddRows(ctx *Context, req *usercall.OnTableAddRowsReq) error {
-	//  获取模型的类型
-	modelType := reflect.TypeOf(opt.AutoCrudTable)
-	if modelType.Kind() == reflect.Ptr {
-		modelType = modelType.Elem()
-	}
-
-	//  创建可寻址的slice
-	sliceType := reflect.SliceOf(modelType)
-	slicePtr := reflect.New(sliceType) // 创建slice指针
-	slice := slicePtr.Elem()           // 获取slice值
-
-	//  解码数据到slice中
-	err := req.DecodeBy(slicePtr.Interface())
-	if err != nil {
-		ctx.Logger.Errorf("DecodeBy failed: %v", err)
-		return err
-	}
-
-	ctx.Logger.Infof("defaultAddRows slice: %+v", slice.Interface())
-
-	// 检查是否有数据
-	if slice.Len() == 0 {
-		return fmt.Errorf("没有要添加的数据")
-	}
-
-	//  执行数据库插入
-	return ctx.MustGetOrInitDB().Create(slicePtr.Interface()).Error
+	return autoCrudAddRows(ctx, opt.AutoCrudTable, req, opt.EnableHistory)
 }
@@ -0,0 +1,255 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/api"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+)
+
+// 基于角色的权限控制，用户和角色见 UserInfo：
+//
+//   - 函数级别：BaseConfig.Roles 限定可以调用的角色，BaseConfig.Policy 自定义判断，执行处理函数和回调前检查
+//   - 字段级别：permission 标签的每个权限可以用=限定角色，多个角色用|分隔
+//
+//	Cost float64 `json:"cost" runner:"code:cost;name:成本" permission:"read=finance|admin,update=finance"`
+//
+// 没有读权限的字段会从 _getApiInfo(s) 和响应数据中隐藏，没有新增/修改权限的字段在 _getApiInfo(s) 中锁定，
+// AutoCrud 写入时返回 E_FORBIDDEN
+
+// AccessPolicy 自定义的函数权限判断，返回nil表示允许，返回的 AppError 会直接响应，其他错误转换成 E_FORBIDDEN
+type AccessPolicy func(ctx *Context, user *UserInfo) error
+
+// permissionRule permission标签解析结果，action -> 限定的角色，没有限定角色表示所有用户都有该权限
+// 为nil表示没有permission标签，拥有全部权限
+type permissionRule map[string][]string
+
+// parsePermissionTag 解析 permission:"read,create,update=admin|finance"
+func parsePermissionTag(tag string, ok bool) permissionRule {
+	if !ok {
+		return nil
+	}
+	rule := make(permissionRule)
+	for _, item := range strings.Split(tag, ",") {
+		name, roles, _ := strings.Cut(strings.TrimSpace(item), "=")
+		if name == "" {
+			continue
+		}
+		var list []string
+		for _, role := range strings.Split(roles, "|") {
+			if role = strings.TrimSpace(role); role != "" {
+				list = append(list, role)
+			}
+		}
+		rule[name] = list
+	}
+	return rule
+}
+
+// allow 用户是否有该权限，user为nil表示没有任何角色
+func (p permissionRule) allow(action string, user *UserInfo) bool {
+	if p == nil {
+		return true
+	}
+	roles, ok := p[action]
+	if !ok {
+		return false
+	}
+	return len(roles) == 0 || (user != nil && user.HasAnyRole(roles...))
+}
+
+// restricted 该权限是否限定了角色
+func (p permissionRule) restricted(action string) bool {
+	return len(p[action]) > 0
+}
+
+func (p permissionRule) hasRoles() bool {
+	for _, roles := range p {
+		if len(roles) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize 执行处理函数前检查登录和函数级别的权限，见 BaseConfig.MustLogin/IsPublicApi/Roles/Policy
+func authorize(ctx *Context, worker *routerInfo) error {
+	if worker.IsDefaultRouter() || worker.Option == nil {
+		return nil
+	}
+	config := worker.Option.GetBaseConfig()
+	if config == nil {
+		return nil
+	}
+	user := ctx.UserInfo()
+	needLogin := len(config.Roles) > 0 || (!config.IsPublicApi && (config.MustLogin || mustLogin()))
+	if needLogin && !user.IsLoggedIn {
+		return Err(ctx).Code("E_UNAUTHORIZED").Msg("请先登录").
+			Detail(fmt.Sprintf("[%s] %s 需要登录后才能访问", worker.Method, worker.Router)).Build()
+	}
+	if len(config.Roles) > 0 && !user.HasAnyRole(config.Roles...) {
+		return Err(ctx).Code("E_FORBIDDEN").Msg("没有权限").
+			Detail(fmt.Sprintf("[%s] %s 需要角色: %s", worker.Method, worker.Router, strings.Join(config.Roles, ","))).Build()
+	}
+	if config.Policy != nil {
+		if err := config.Policy(ctx, user); err != nil {
+			var appErr *AppError
+			if errors.As(err, &appErr) {
+				return err
+			}
+			return Err(ctx).Code("E_FORBIDDEN").Msg("没有权限").Detail(err.Error()).Build()
+		}
+	}
+	return nil
+}
+
+// fieldForbidden 没有字段的新增/修改权限
+func fieldForbidden(ctx *Context, action string, codes []string) error {
+	b := Err(ctx).Code("E_FORBIDDEN").Msg("没有权限").
+		Detail(fmt.Sprintf("当前用户没有字段的%s权限: %s", action, strings.Join(codes, ",")))
+	for _, code := range codes {
+		b.Field(code, "没有权限")
+	}
+	return b.Build()
+}
+
+// fieldRule 结构体字段的权限，key是字段的code，json是json名称
+type fieldRule struct {
+	code string
+	json string
+	rule permissionRule
+}
+
+var fieldRulesCache sync.Map // reflect.Type -> []*fieldRule
+
+// fieldRulesOf 解析类型上带permission标签的字段，支持指针、切片、匿名嵌入和带 Items 字段的表格响应
+func fieldRulesOf(t reflect.Type) []*fieldRule {
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	if v, ok := fieldRulesCache.Load(t); ok {
+		return v.([]*fieldRule)
+	}
+	var rules []*fieldRule
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous || sf.Name == "Items" {
+			rules = append(rules, fieldRulesOf(sf.Type)...)
+			continue
+		}
+		tag, ok := sf.Tag.Lookup("permission")
+		if !ok || !sf.IsExported() {
+			continue
+		}
+		jsonName := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if jsonName == "" {
+			jsonName = sf.Name
+		}
+		code := runnerCode(sf.Tag.Get("runner"))
+		if code == "" {
+			code = jsonName
+		}
+		rules = append(rules, &fieldRule{code: code, json: jsonName, rule: parsePermissionTag(tag, true)})
+	}
+	fieldRulesCache.Store(t, rules)
+	return rules
+}
+
+// routerFieldRules 函数请求、响应和AutoCrud模型上限定了角色的字段权限，key包括code和json名称
+func routerFieldRules(worker *routerInfo) map[string]*fieldRule {
+	if worker.Option == nil {
+		return nil
+	}
+	config := worker.Option.GetBaseConfig()
+	if config == nil {
+		return nil
+	}
+	rules := make(map[string]*fieldRule)
+	for _, el := range []interface{}{config.Request, config.Response, worker.Option.GetAutoCrudTable()} {
+		if el == nil {
+			continue
+		}
+		for _, r := range fieldRulesOf(reflect.TypeOf(el)) {
+			if r.rule.hasRoles() {
+				rules[r.code] = r
+				rules[r.json] = r
+			}
+		}
+	}
+	return rules
+}
+
+// hiddenFields 用户没有读权限的字段，返回code和json名称
+func hiddenFields(worker *routerInfo, user *UserInfo) []string {
+	var keys []string
+	seen := make(map[*fieldRule]bool)
+	for _, r := range routerFieldRules(worker) {
+		if seen[r] || !r.rule.restricted(permissionRead) || r.rule.allow(permissionRead, user) {
+			continue
+		}
+		seen[r] = true
+		keys = append(keys, r.code)
+		if r.json != r.code {
+			keys = append(keys, r.json)
+		}
+	}
+	return keys
+}
+
+// hideResponseFields 从响应数据中删除用户没有读权限的字段
+func hideResponseFields(ctx *Context, worker *routerInfo, rsp *response.RunFunctionResp) {
+	if rsp == nil || worker.IsDefaultRouter() {
+		return
+	}
+	if keys := hiddenFields(worker, ctx.UserInfo()); len(keys) > 0 {
+		rsp.HideFields(keys...)
+	}
+}
+
+// filterApiInfo 按当前用户隐藏没有读权限的字段、锁定没有新增/修改权限的字段
+// 请求没有用户信息时按没有任何角色的用户处理，平台注册函数使用 apis 命令（getApiInfos）获取完整的信息
+func filterApiInfo(ctx *Context, worker *routerInfo, info *api.Info) {
+	user := ctx.UserInfo()
+	rules := routerFieldRules(worker)
+	if len(rules) == 0 {
+		return
+	}
+	filter := func(fields []*api.FieldInfo) []*api.FieldInfo {
+		out := make([]*api.FieldInfo, 0, len(fields))
+		for _, f := range fields {
+			r, ok := rules[f.Code]
+			if !ok {
+				out = append(out, f)
+				continue
+			}
+			if r.rule.restricted(permissionRead) && !r.rule.allow(permissionRead, user) {
+				continue
+			}
+			f.Permission = &api.PermissionConfig{
+				Read:   r.rule.allow(permissionRead, user),
+				Create: r.rule.allow(permissionCreate, user),
+				Update: r.rule.allow(permissionUpdate, user),
+			}
+			out = append(out, f)
+		}
+		return out
+	}
+	for _, params := range []interface{}{info.ParamsIn, info.ParamsOut} {
+		switch p := params.(type) {
+		case *api.FormConfig:
+			p.Fields = filter(p.Fields)
+		case *api.TableConfig:
+			p.Columns = filter(p.Columns)
+		case *api.UnifiedAPIResponse:
+			p.Fields = filter(p.Fields)
+			p.Columns = filter(p.Columns)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/api"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
+	consts "github.com/yunhanshu-net/pkg/constants/usercall"
+	"github.com/yunhanshu-net/pkg/trace"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type financeRow struct {
+	ID    int     `json:"id" gorm:"primaryKey" runner:"code:id;name:ID" permission:"read"`
+	Name  string  `json:"name" runner:"code:name;name:名称"`
+	Price float64 `json:"price" runner:"code:price;name:售价" permission:"read,create,update=admin"`
+	Cost  float64 `json:"cost" runner:"code:cost;name:成本" permission:"read=finance|admin,update=finance"`
+}
+
+func TestPermissionRule(t *testing.T) {
+	rule := parsePermissionTag("read, update=admin|finance", true)
+	sales := &UserInfo{Roles: []string{"sales"}}
+	finance := &UserInfo{Roles: []string{"finance"}}
+	if !rule.allow(permissionRead, sales) || rule.allow(permissionCreate, finance) {
+		t.Errorf("read所有人都有，create没有配置: %v", rule)
+	}
+	if rule.allow(permissionUpdate, sales) || !rule.allow(permissionUpdate, finance) || rule.allow(permissionUpdate, nil) {
+		t.Errorf("update只有admin和finance: %v", rule)
+	}
+	if !rule.restricted(permissionUpdate) || rule.restricted(permissionRead) {
+		t.Errorf("restricted: %v", rule)
+	}
+	if !parsePermissionTag("", false).allow(permissionUpdate, nil) {
+		t.Error("没有permission标签表示全部权限")
+	}
+}
+
+func TestFunctionRoles(t *testing.T) {
+	r := newTestRunner()
+	prefix := fmt.Sprintf("/rbac_%d", time.Now().UnixNano())
+	rolesOpt := &FormFunctionOptions{}
+	rolesOpt.Roles = []string{"admin", "finance"}
+	rolesOpt.Response = &financeRow{}
+	policyOpt := &FormFunctionOptions{}
+	policyOpt.Policy = func(ctx *Context, user *UserInfo) error {
+		if user.Tenant != "t1" {
+			return errors.New("只允许租户t1访问")
+		}
+		return nil
+	}
+	handler := func(ctx *Context, req *serveReq, resp response.Response) error {
+		return resp.Form(&financeRow{ID: 1, Name: "a", Price: 10, Cost: 6}).Build()
+	}
+	r.get(prefix+"/report", handler, rolesOpt)
+	r.get(prefix+"/tenant", handler, policyOpt)
+
+	t.Setenv("RUNNER_SERVE_TOKEN", "test-token")
+	srv := httptest.NewServer(r.httpHandler())
+	defer srv.Close()
+	call := func(path string, user string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+prefix+path, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		if user != "" {
+			req.Header.Set(httpHeaderUser, user)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var body map[string]interface{}
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		marshal, _ := json.Marshal(body)
+		return res.StatusCode, string(marshal)
+	}
+
+	if code, _ := call("/report", ""); code != http.StatusUnauthorized {
+		t.Errorf("未登录: %d", code)
+	}
+	if code, body := call("/report", `{"id":"1","roles":["sales"]}`); code != http.StatusForbidden || !strings.Contains(body, "E_FORBIDDEN") {
+		t.Errorf("没有角色: %d %s", code, body)
+	}
+	if code, body := call("/report", `{"id":"1","roles":["finance"]}`); code != http.StatusOK || !strings.Contains(body, `"cost":6`) {
+		t.Errorf("finance可以看到成本: %d %s", code, body)
+	}
+	if code, body := call("/report", `{"id":"1","roles":["admin"]}`); code != http.StatusOK || !strings.Contains(body, `"cost":6`) {
+		t.Errorf("admin可以看到成本: %d %s", code, body)
+	}
+	if code, body := call("/tenant", `{"id":"1","tenant":"t2"}`); code != http.StatusForbidden || !strings.Contains(body, "只允许租户t1访问") {
+		t.Errorf("Policy拒绝: %d %s", code, body)
+	}
+	if code, _ := call("/tenant", `{"id":"1","tenant":"t1"}`); code != http.StatusOK {
+		t.Errorf("Policy允许: %d", code)
+	}
+}
+
+func TestHideFieldsByRole(t *testing.T) {
+	r := newTestRunner()
+	router := fmt.Sprintf("/rbac_hide_%d", time.Now().UnixNano())
+	opt := &FormFunctionOptions{}
+	opt.Response = &financeRow{}
+	r.get(router, func(ctx *Context, req *serveReq, resp response.Response) error {
+		return resp.Form(&financeRow{ID: 1, Name: "a", Price: 10, Cost: 6}).Build()
+	}, opt)
+	worker, _ := r.getRouter(router, "GET")
+
+	sales := NewContext(context.Background(), "GET", router, r)
+	sales.FunctionMsg = &trace.FunctionMsg{RequestUser: `{"id":"2","roles":["sales"]}`}
+
+	rsp := &response.RunFunctionResp{}
+	_ = rsp.Form(&financeRow{ID: 1, Cost: 6}).Build()
+	hideResponseFields(sales, worker, rsp)
+	if data, _ := json.Marshal(rsp.Data); strings.Contains(string(data), "cost") {
+		t.Errorf("sales不应该看到成本: %s", data)
+	}
+
+	info := &api.Info{ParamsOut: &api.UnifiedAPIResponse{Columns: []*api.FieldInfo{{Code: "id"}, {Code: "price"}, {Code: "cost"}}}}
+	filterApiInfo(sales, worker, info)
+	columns := info.ParamsOut.(*api.UnifiedAPIResponse).Columns
+	if len(columns) != 2 || columns[1].Code != "price" || columns[1].Permission.Update || !columns[1].Permission.Read {
+		t.Errorf("sales应该看不到成本，售价只读: %+v", columns)
+	}
+
+	anonymous := NewContext(context.Background(), "GET", router, r)
+	info = &api.Info{ParamsOut: &api.UnifiedAPIResponse{Columns: []*api.FieldInfo{{Code: "id"}, {Code: "price"}, {Code: "cost"}}}}
+	filterApiInfo(anonymous, worker, info)
+	columns = info.ParamsOut.(*api.UnifiedAPIResponse).Columns
+	if len(columns) != 2 || columns[1].Code != "price" || columns[1].Permission.Update {
+		t.Errorf("没有用户信息时按没有角色处理: %+v", columns)
+	}
+}
+
+func TestCrudFieldForbidden(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	m, err := parseCrudModel(db, &financeRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.user = &UserInfo{Roles: []string{"sales"}}
+	errs := make(map[string]string)
+	allowed := m.checkFields(map[string]interface{}{"name": "b", "cost": 1, "price": 2}, permissionUpdate, "", errs)
+	if len(errs) != 0 || len(allowed) != 1 || strings.Join(m.forbidden, ",") == "" {
+		t.Fatalf("限定角色的字段应该记录为无权限: errs=%v allowed=%v forbidden=%v", errs, allowed, m.forbidden)
+	}
+
+	err = fieldForbidden(NewContext(context.Background(), "POST", "/x", nil), permissionUpdate, m.forbidden)
+	var appErr *AppError
+	if !errors.As(err, &appErr) || appErr.Code != "E_FORBIDDEN" || httpStatus(err) != http.StatusForbidden {
+		t.Fatalf("应该返回 E_FORBIDDEN: %v", err)
+	}
+}
+
+// TestAutoCrudUpdateUnchangedReadOnly 前端提交整行时，值没有变化的只读字段和限定角色的字段直接忽略
+func TestAutoCrudUpdateUnchangedReadOnly(t *testing.T) {
+	ctx := newHistoryTestContext(t)
+	ctx.FunctionMsg = &trace.FunctionMsg{RequestUser: `{"id":"2","roles":["sales"]}`}
+	db := ctx.MustGetOrInitDB()
+	if err := db.AutoMigrate(&financeRow{}); err != nil {
+		t.Fatalf("建表失败: %v", err)
+	}
+	db.Create(&financeRow{ID: 1, Name: "a", Price: 10, Cost: 6})
+
+	update := func(fields map[string]interface{}) error {
+		return autoCrudUpdateRows(ctx, &financeRow{}, &usercall.OnTableUpdateRowsReq{Keys: []interface{}{1}, Fields: fields}, false)
+	}
+	if err := update(map[string]interface{}{"id": 1, "name": "b", "price": 10.0, "cost": 6}); err != nil {
+		t.Fatalf("没有变化的只读字段应该忽略: %v", err)
+	}
+	var row financeRow
+	db.First(&row, 1)
+	if row.Name != "b" || row.Price != 10 || row.Cost != 6 {
+		t.Fatalf("只应该更新name: %+v", row)
+	}
+
+	var appErr *AppError
+	if err := update(map[string]interface{}{"name": "c", "price": 11}); !errors.As(err, &appErr) || appErr.Code != "E_FORBIDDEN" {
+		t.Fatalf("修改了没有权限的字段应该返回 E_FORBIDDEN: %v", err)
+	}
+	if err := update(map[string]interface{}{"id": 2}); !errors.As(err, &appErr) || appErr.Code != "E_VALIDATION" {
+		t.Fatalf("修改了只读字段应该返回 E_VALIDATION: %v", err)
+	}
+}
+
+// TestPlatformCallbackWithoutUser 平台的生命周期回调不带用户信息，开启强制登录后也要能正常建表
+func TestPlatformCallbackWithoutUser(t *testing.T) {
+	t.Setenv("RUNNER_MUST_LOGIN", "true")
+	ctx := newHistoryTestContext(t)
+	r := newTestRunner()
+	router := fmt.Sprintf("/platform_callback_%d", time.Now().UnixNano())
+	r.get(router, func(ctx *Context, req *serveReq, resp response.Response) error {
+		return nil
+	}, &TableFunctionOptions{AutoCrudTable: &deleteHardRow{}, BaseConfig: BaseConfig{CreateTables: []interface{}{&deleteHardRow{}}}})
+
+	call := func(typ string) error {
+		req := &usercall.Request{Method: "GET", Router: router, Type: typ, Body: map[string]interface{}{"ids": []int{1}}}
+		return r._callback(ctx, req, &response.RunFunctionResp{})
+	}
+	if err := call(consts.CallbackTypeOnCreateTables); err != nil {
+		t.Fatalf("建表回调不需要登录: %v", err)
+	}
+	if !ctx.MustGetOrInitDB().Migrator().HasTable(&deleteHardRow{}) {
+		t.Fatal("建表回调没有建表")
+	}
+	var appErr *AppError
+	if err := call(consts.CallbackTypeOnTableDeleteRows); !errors.As(err, &appErr) || appErr.Code != "E_UNAUTHORIZED" {
+		t.Fatalf("用户触发的回调仍然需要登录: %v", err)
+	}
+}
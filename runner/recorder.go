@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
+	"github.com/yunhanshu-net/pkg/constants"
+	"github.com/yunhanshu-net/pkg/logger"
+	"github.com/yunhanshu-net/pkg/trace"
+)
+
+// Recording 一次请求的录制结果，按trace_id保存，run --replay 可以重新执行，runner/testkit 可以把录制当作golden文件做回归测试
+type Recording struct {
+	TraceID      string                    `json:"trace_id"`
+	Method       string                    `json:"method"`
+	Router       string                    `json:"router"`
+	FunctionType FunctionType              `json:"function_type"`
+	Request      *request.RunFunctionReq   `json:"request"`
+	Config       *usercall.ConfigData      `json:"config,omitempty"` // 配置了AutoUpdateConfig的函数录制时的配置快照
+	Response     *response.RunFunctionResp `json:"response,omitempty"`
+	Error        string                    `json:"error,omitempty"`
+	RecordedAt   time.Time                 `json:"recorded_at"`
+}
+
+// replayTracePrefix 回放请求的trace_id前缀，回放的请求不会再被录制
+const replayTracePrefix = "replay_"
+
+// recordDir 录制文件的目录，环境变量 RUNNER_RECORD_DIR，为空表示不录制
+func recordDir() string {
+	return getEnvOrDefault("RUNNER_RECORD_DIR", "")
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+func recordingPath(dir string, traceID string) string {
+	return filepath.Join(dir, unsafeFileChars.ReplaceAllString(traceID, "_")+".json")
+}
+
+// record 录制一次请求，内置路由、回放的请求和没有trace_id的请求不录制，录制失败只记录日志不影响请求
+// 异步函数在任务执行结束后录制最终结果，不录制提交时返回的任务id，见 runAsyncJob
+func (r *Runner) record(ctx *Context, worker *routerInfo, req *request.RunFunctionReq, rsp *response.RunFunctionResp, err error) {
+	dir := recordDir()
+	traceID := ctx.getTraceId()
+	if dir == "" || traceID == "" || strings.HasPrefix(traceID, replayTracePrefix) || worker.IsDefaultRouter() {
+		return
+	}
+	rec := &Recording{
+		TraceID:    traceID,
+		Method:     worker.Method,
+		Router:     worker.Router,
+		Request:    req,
+		Response:   rsp,
+		RecordedAt: time.Now(),
+	}
+	if worker.Option != nil {
+		rec.FunctionType = worker.Option.GetFunctionType()
+		if config := worker.Option.GetBaseConfig(); config != nil && config.AutoUpdateConfig != nil {
+			rec.Config = GetConfigManager().GetByKey(ctx, usercall.GenerateConfigKey(worker.Router, worker.Method))
+		}
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if e := SaveRecording(dir, rec); e != nil {
+		logger.Warnf(ctx, "录制请求失败: %v", e)
+	}
+}
+
+// SaveRecording 保存录制，相同trace_id的录制会被覆盖
+func SaveRecording(dir string, rec *Recording) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(recordingPath(dir, rec.TraceID), data, 0644)
+}
+
+// LoadRecording 读取trace_id对应的录制
+func LoadRecording(dir string, traceID string) (*Recording, error) {
+	data, err := os.ReadFile(recordingPath(dir, traceID))
+	if err != nil {
+		return nil, fmt.Errorf("读取录制失败: %w", err)
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("解析录制失败: %w", err)
+	}
+	return &rec, nil
+}
+
+// ListRecordings 读取目录下的所有录制，按路由和trace_id排序
+func ListRecordings(dir string) ([]*Recording, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	recs := make([]*Recording, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("读取录制失败: %w", err)
+		}
+		var rec Recording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, fmt.Errorf("解析录制 %s 失败: %w", filepath.Base(file), err)
+		}
+		recs = append(recs, &rec)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].Router != recs[j].Router {
+			return recs[i].Router < recs[j].Router
+		}
+		return recs[i].TraceID < recs[j].TraceID
+	})
+	return recs, nil
+}
+
+// restoreConfig 恢复录制时的配置快照，Replay 和 run --replay 都要先恢复，回放结果才和录制时一致
+func (rec *Recording) restoreConfig() {
+	if rec.Config != nil {
+		GetConfigManager().setCache(usercall.GenerateConfigKey(rec.Router, rec.Method), rec.Config)
+	}
+}
+
+// Replay 在当前进程内重新执行录制的请求，录制时有配置快照的会先恢复配置，供 runner/testkit 使用
+// 处理函数需要已经通过 runner.Get/Post 等注册到当前进程
+func Replay(ctx context.Context, rec *Recording) (*response.RunFunctionResp, error) {
+	initRunner()
+	if rec.Request == nil {
+		return nil, fmt.Errorf("录制 %s 没有请求内容", rec.TraceID)
+	}
+	rec.restoreConfig()
+	req := *rec.Request
+	traceID := replayTracePrefix + rec.TraceID
+	req.TraceID = traceID
+	c := context.WithValue(ctx, constants.TraceID, traceID)
+	c = context.WithValue(c, trace.FunctionMsgKey, createFunctionMsg(traceID, req.Method, req.Router))
+	runCtx := NewContext(c, req.Method, req.Router, r)
+	defer runCtx.cleanupTemp()
+	return r.runFunctionV2(runCtx, &req)
+}
@@ -3,6 +3,7 @@ package runner
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"runtime"
 	"runtime/debug"
@@ -13,14 +14,22 @@ import (
 	"github.com/yunhanshu-net/pkg/logger"
 )
 
-// runRequest 执行请求
-func (r *Runner) runFunction(ctx context.Context, req *request.RunFunctionReq) (*response.RunFunctionResp, error) {
+// runFunction 执行请求（run命令使用），交给runFunctionV2处理，流式响应的分片输出为<Chunk>标签，进度输出为<Progress>标签
+func (r *Runner) runFunction(c *Context, req *request.RunFunctionReq) (*response.RunFunctionResp, error) {
+	c.streamSink = cliStreamSink
+	c.progressSink = cliProgressSink
+	return r.runFunctionV2(c, req)
+}
+
+func (r *Runner) runFunctionV2(ctx *Context, req *request.RunFunctionReq) (rsp *response.RunFunctionResp, err error) {
 
+	if !r.inflight.enter() {
+		return nil, runnerClosing(ctx)
+	}
 	logger.Infof(ctx, "run function request %+v\n runningCount++", req)
-	r.AddRunningCount(1)
 	defer func() {
 		logger.Infof(ctx, "run function request %+v\n runningCount--", req)
-		r.SubRunningCount(1)
+		r.inflight.exit()
 	}()
 
 	router, exist := r.getRouter(req.Router, req.Method)
@@ -30,111 +39,111 @@ func (r *Runner) runFunction(ctx context.Context, req *request.RunFunctionReq) (
 		return nil, fmt.Errorf("路由未找到: [%s] %s", req.Method, req.Router)
 	}
 
-	// 使用defer-recover处理panic
-	var result *response.RunFunctionResp
-	var err error
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				stack := debug.Stack()
-				errMsg := fmt.Sprintf("请求处理panic: %v", r)
-				logger.Errorf(ctx, "%s\n调用栈: %s", errMsg, stack)
-				err = fmt.Errorf(errMsg)
-				// 这里打印是方便我出现错误时候可以直接在控制台看到日志
-				fmt.Printf("err: %s\n调用栈: %s\n", errMsg, stack)
-			}
-		}()
-
-		if req.IsMethodGet() {
-			req.Body = req.UrlQuery
-		}
-		start := time.Now()
-		var mStart runtime.MemStats
-		var mEnd runtime.MemStats
-		runtime.ReadMemStats(&mStart)
-		newContext := NewContext(ctx, req.Method, req.Router, r)
-		//ctx1 := &Context{Context: ctx, user: r.detail.User, name: r.detail.Name, version: r.detail.Version}
-		_, rsp, callErr := router.call(newContext, req.Body)
-		runtime.ReadMemStats(&mEnd)
-		if callErr != nil {
-			err = fmt.Errorf("%w", callErr)
-			return
-		}
-
-		// 记录执行时间
-		elapsed := time.Since(start)
-		if rsp.MetaData == nil {
-			rsp.MetaData = make(map[string]interface{})
-		}
-		rsp.MetaData["cost"] = elapsed.String()
-		rsp.MetaData["memory"] = getMemoryUsage()
-		rsp.MetaData["cost_memory"] = fmt.Sprintf("%v", mEnd.Alloc-mStart.Alloc)
+	if req.IsMethodGet() {
+		req.Body = req.UrlQuery
+	}
+	if err := authorize(ctx, router); err != nil {
+		return nil, err
+	}
+	if r.asyncEnabled && router.isAsync() {
+		return r.submitAsyncTask(ctx, router, req)
+	}
+	// 配置了 RUNNER_RECORD_DIR 时录制请求和响应，见 run --replay
+	defer func() { r.record(ctx, router, req, rsp, err) }()
 
-		result = rsp
-	}()
+	timeout := router.timeout()
+	if timeout <= 0 {
+		return r.invoke(ctx, router, req)
+	}
+	return r.invokeWithTimeout(ctx, router, req, timeout)
+}
 
-	return result, err
+// callResult 处理函数的执行结果
+type callResult struct {
+	rsp *response.RunFunctionResp
+	err error
 }
-func (r *Runner) runFunctionV2(ctx *Context, req *request.RunFunctionReq) (*response.RunFunctionResp, error) {
 
-	logger.Infof(ctx, "run function request %+v\n runningCount++", req)
-	r.AddRunningCount(1)
-	defer func() {
-		logger.Infof(ctx, "run function request %+v\n runningCount--", req)
-		r.SubRunningCount(1)
+// invokeWithTimeout 在超时时间内执行处理函数
+// 处理函数使用带截止时间的Context副本，超时后副本会被取消并立即返回超时错误，调用方的ctx不受影响；
+// 不响应取消信号的处理函数会被分离，等它结束后再记录日志
+func (r *Runner) invokeWithTimeout(ctx *Context, router *routerInfo, req *request.RunFunctionReq, timeout time.Duration) (*response.RunFunctionResp, error) {
+	deadlineCtx, cancel := context.WithTimeout(ctx.Context, timeout)
+	defer cancel()
+	handlerCtx := ctx.withContext(deadlineCtx)
+
+	done := make(chan callResult, 1)
+	go func() {
+		rsp, err := r.invoke(handlerCtx, router, req)
+		done <- callResult{rsp: rsp, err: err}
 	}()
 
-	router, exist := r.getRouter(req.Router, req.Method)
-	if !exist {
-		routersJSON, _ := json.Marshal(r.routerMap)
-		logger.Errorf(ctx, "可用路由: %s", string(routersJSON))
-		return nil, fmt.Errorf("路由未找到: [%s] %s", req.Method, req.Router)
+	select {
+	case res := <-done:
+		return res.rsp, res.err
+	case <-deadlineCtx.Done():
+		// 处理函数可能恰好在超时的同时完成，优先使用其结果
+		select {
+		case res := <-done:
+			return res.rsp, res.err
+		default:
+		}
+		r.detach(ctx, router, timeout, done)
+		return nil, ExecTimeout(ctx, timeout)
 	}
+}
 
-	// 使用defer-recover处理panic
-	var result *response.RunFunctionResp
-	var err error
-
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				stack := debug.Stack()
-				errMsg := fmt.Sprintf("请求处理panic: %v", r)
-				logger.Errorf(ctx, "%s\n调用栈: %s", errMsg, stack)
-				err = fmt.Errorf(errMsg)
-				// 这里打印是方便我出现错误时候可以直接在控制台看到日志
-				fmt.Printf("err: %s\n调用栈: %s\n", errMsg, stack)
-			}
-		}()
-
-		if req.IsMethodGet() {
-			req.Body = req.UrlQuery
-		}
-		start := time.Now()
-		var mStart runtime.MemStats
-		var mEnd runtime.MemStats
-		runtime.ReadMemStats(&mStart)
-		//newContext := NewContext(ctx, req.Method, req.Router, r)
-		//ctx1 := &Context{Context: ctx, user: r.detail.User, name: r.detail.Name, version: r.detail.Version}
-		_, rsp, callErr := router.call(ctx, req.Body)
-		runtime.ReadMemStats(&mEnd)
-		if callErr != nil {
-			err = fmt.Errorf("%w", callErr)
-			return
-		}
+// detach 分离超时后仍在运行的处理函数，等它真正结束时上报并删除临时目录
+// 分离的处理函数仍然计入正在处理的请求，关闭和空闲退出会等它结束（关闭最多等 drainTimeout）
+func (r *Runner) detach(ctx *Context, router *routerInfo, timeout time.Duration, done <-chan callResult) {
+	r.inflight.hold()
+	ctx.holdTemp()
+	r.AddDetachedCount(1)
+	logger.Errorf(ctx, "函数执行超时(%v)，处理函数未响应取消信号已被分离: [%s] %s 当前分离数: %d",
+		timeout, router.Method, router.Router, r.GetDetachedCount())
+	start := time.Now()
+	go func() {
+		res := <-done
+		ctx.releaseTemp()
+		r.SubDetachedCount(1)
+		r.inflight.exit()
+		logger.Warnf(ctx, "已分离的处理函数执行结束: [%s] %s 超时后额外耗时: %v err: %v",
+			router.Method, router.Router, time.Since(start), res.err)
+	}()
+}
 
-		// 记录执行时间
-		elapsed := time.Since(start)
-		if rsp.MetaData == nil {
-			rsp.MetaData = make(map[string]interface{})
+// invoke 调用处理函数并记录耗时与内存，panic会被转换为错误
+// 请求的临时目录由调用方在响应发送后删除，见 cleanupTemp
+func (r *Runner) invoke(ctx *Context, router *routerInfo, req *request.RunFunctionReq) (result *response.RunFunctionResp, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			errMsg := fmt.Sprintf("请求处理panic: %v", r)
+			logger.Errorf(ctx, "%s\n调用栈: %s", errMsg, stack)
+			err = errors.New(errMsg)
+			// 这里打印是方便我出现错误时候可以直接在控制台看到日志
+			fmt.Printf("err: %s\n调用栈: %s\n", errMsg, stack)
 		}
-		rsp.MetaData["cost"] = elapsed.String()
-		rsp.MetaData["memory"] = getMemoryUsage()
-		rsp.MetaData["cost_memory"] = fmt.Sprintf("%v", mEnd.Alloc-mStart.Alloc)
-
-		result = rsp
 	}()
 
-	return result, err
+	start := time.Now()
+	var mStart runtime.MemStats
+	var mEnd runtime.MemStats
+	runtime.ReadMemStats(&mStart)
+	_, rsp, callErr := router.call(ctx, req.Body)
+	runtime.ReadMemStats(&mEnd)
+	if callErr != nil {
+		return nil, fmt.Errorf("%w", callErr)
+	}
+	hideResponseFields(ctx, router, rsp)
+
+	// 记录执行时间
+	elapsed := time.Since(start)
+	if rsp.MetaData == nil {
+		rsp.MetaData = make(map[string]interface{})
+	}
+	rsp.MetaData["cost"] = elapsed.String()
+	rsp.MetaData["memory"] = getMemoryUsage()
+	rsp.MetaData["cost_memory"] = fmt.Sprintf("%v", mEnd.Alloc-mStart.Alloc)
+	return rsp, nil
 }
@@ -0,0 +1,202 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/pkg/constants"
+	"github.com/yunhanshu-net/pkg/logger"
+)
+
+const (
+	// natsCodeBusy runner繁忙时响应头里的code，平台收到后可以把请求转给其他实例
+	natsCodeBusy = "429"
+	// natsHeaderRetryAfter 繁忙时建议的重试间隔，单位毫秒
+	natsHeaderRetryAfter = "retry-after"
+)
+
+// requestPool 处理NATS请求的有界协程池，避免突发流量把内存和数据库连接打满
+// worker数量、队列长度、繁忙时建议的重试间隔可以通过环境变量 RUNNER_WORKERS/RUNNER_QUEUE_SIZE/RUNNER_RETRY_AFTER_MS 配置
+type requestPool struct {
+	once       sync.Once
+	workers    int
+	jobs       chan *nats.Msg
+	retryAfter time.Duration
+
+	running  int64 // 正在处理的请求数
+	rejected int64 // 因为繁忙被拒绝的请求数
+
+	routerLock    sync.Mutex
+	routerRunning map[string]int // 每个路由正在处理的请求数，用于单路由并发限制
+
+	stopLock sync.Mutex
+	stopped  bool // 关闭时不再接收新的请求，见 stop
+}
+
+func newRequestPool() *requestPool {
+	workers := getEnvIntOrDefault("RUNNER_WORKERS", 16)
+	if workers <= 0 {
+		workers = 1
+	}
+	queueSize := getEnvIntOrDefault("RUNNER_QUEUE_SIZE", 256)
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &requestPool{
+		workers:       workers,
+		jobs:          make(chan *nats.Msg, queueSize),
+		retryAfter:    time.Duration(getEnvIntOrDefault("RUNNER_RETRY_AFTER_MS", 1000)) * time.Millisecond,
+		routerRunning: make(map[string]int),
+	}
+}
+
+// start 启动worker，只会执行一次
+func (p *requestPool) start(handle func(msg *nats.Msg)) {
+	p.once.Do(func() {
+		for i := 0; i < p.workers; i++ {
+			go func() {
+				for msg := range p.jobs {
+					atomic.AddInt64(&p.running, 1)
+					handle(msg)
+					atomic.AddInt64(&p.running, -1)
+				}
+			}()
+		}
+	})
+}
+
+// submit 把请求放入队列，队列满了或者已经关闭返回false，不会阻塞NATS的回调
+func (p *requestPool) submit(msg *nats.Msg) bool {
+	p.stopLock.Lock()
+	defer p.stopLock.Unlock()
+	if !p.stopped {
+		select {
+		case p.jobs <- msg:
+			return true
+		default:
+		}
+	}
+	atomic.AddInt64(&p.rejected, 1)
+	return false
+}
+
+// isStopped 是否已经停止接收请求
+func (p *requestPool) isStopped() bool {
+	p.stopLock.Lock()
+	defer p.stopLock.Unlock()
+	return p.stopped
+}
+
+// stop 停止接收新的请求，取出还在排队的请求由调用方响应繁忙
+// 关闭连接前必须调用，否则排队的请求会在连接关闭后才被worker取到，响应不出去
+func (p *requestPool) stop() []*nats.Msg {
+	p.stopLock.Lock()
+	p.stopped = true
+	p.stopLock.Unlock()
+	var msgs []*nats.Msg
+	for {
+		select {
+		case msg := <-p.jobs:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+// acquireRouter 占用路由的一个并发名额，limit<=0表示不限制，超过限制返回false
+func (p *requestPool) acquireRouter(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+	p.routerLock.Lock()
+	defer p.routerLock.Unlock()
+	if p.routerRunning[key] >= limit {
+		atomic.AddInt64(&p.rejected, 1)
+		return false
+	}
+	p.routerRunning[key]++
+	return true
+}
+
+func (p *requestPool) releaseRouter(key string, limit int) {
+	if limit <= 0 {
+		return
+	}
+	p.routerLock.Lock()
+	defer p.routerLock.Unlock()
+	if p.routerRunning[key]--; p.routerRunning[key] <= 0 {
+		delete(p.routerRunning, key)
+	}
+}
+
+// RequestPoolMetrics 请求池的指标，通过 /_metrics 暴露
+type RequestPoolMetrics struct {
+	Workers       int            `json:"workers"`
+	QueueSize     int            `json:"queue_size"`
+	QueueDepth    int            `json:"queue_depth"` // 排队中的请求数
+	Running       int64          `json:"running"`
+	Rejected      int64          `json:"rejected"`
+	RouterRunning map[string]int `json:"router_running"`
+}
+
+func (p *requestPool) metrics() *RequestPoolMetrics {
+	m := &RequestPoolMetrics{
+		Workers:       p.workers,
+		QueueSize:     cap(p.jobs),
+		QueueDepth:    len(p.jobs),
+		Running:       atomic.LoadInt64(&p.running),
+		Rejected:      atomic.LoadInt64(&p.rejected),
+		RouterRunning: make(map[string]int),
+	}
+	p.routerLock.Lock()
+	for k, v := range p.routerRunning {
+		m.RouterRunning[k] = v
+	}
+	p.routerLock.Unlock()
+	return m
+}
+
+// maxConcurrency 获取路由配置的最大并发（BaseConfig.MaxConcurrency），0表示不限制
+func (r *routerInfo) maxConcurrency() int {
+	if r.Option == nil {
+		return 0
+	}
+	config := r.Option.GetBaseConfig()
+	if config == nil {
+		return 0
+	}
+	return config.MaxConcurrency
+}
+
+// replyBusy 告诉平台当前runner繁忙，平台可以根据retry-after重试或者转给其他实例
+func (r *Runner) replyBusy(ctx context.Context, msg *nats.Msg, reason string) {
+	retryAfter := r.reqPool.retryAfter
+	appErr := &AppError{
+		Code:      "E_BUSY",
+		Message:   "runner繁忙，请稍后重试",
+		Detail:    reason,
+		Hint:      "请求已被拒绝，可以稍后重试或者转给其他实例",
+		TraceID:   msg.Header.Get(constants.TraceID),
+		Retryable: true,
+	}
+	header := responseHeader(msg)
+	setResponseHeader(header, natsCodeBusy, appErr.Message, appErr.TraceID)
+	header.Set(natsHeaderRetryAfter, strconv.FormatInt(retryAfter.Milliseconds(), 10))
+	data, _ := json.Marshal(newErrorResp(appErr))
+	logger.Warnf(ctx, "runner繁忙，拒绝请求: %s", reason)
+	if err := r.respond(ctx, msg, header, data); err != nil {
+		logger.Errorf(ctx, "响应繁忙失败: %v", err)
+	}
+}
+
+func (r *Runner) _metrics(ctx *Context, req *request.NoData, resp response.Response) error {
+	return resp.Form(map[string]interface{}{"request_pool": r.reqPool.metrics()}).Build()
+}
@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestRequestPool(t *testing.T) {
+	t.Setenv("RUNNER_WORKERS", "1")
+	t.Setenv("RUNNER_QUEUE_SIZE", "1")
+	p := newRequestPool()
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	p.start(func(msg *nats.Msg) {
+		defer wg.Done()
+		if msg.Subject == "first" {
+			close(started)
+			<-block
+		}
+	})
+
+	if !p.submit(&nats.Msg{Subject: "first"}) {
+		t.Fatal("第一个请求应该被接收")
+	}
+	<-started
+	if !p.submit(&nats.Msg{Subject: "second"}) {
+		t.Fatal("第二个请求应该进入队列")
+	}
+	if p.submit(&nats.Msg{Subject: "third"}) {
+		t.Fatal("队列满了应该拒绝")
+	}
+	if m := p.metrics(); m.QueueDepth != 1 || m.Running != 1 || m.Rejected != 1 {
+		t.Fatalf("指标不对: %+v", m)
+	}
+	close(block)
+	wg.Wait()
+
+	if !p.acquireRouter("/a.POST", 1) {
+		t.Fatal("第一个请求应该拿到路由名额")
+	}
+	if p.acquireRouter("/a.POST", 1) {
+		t.Fatal("超过路由并发限制应该拒绝")
+	}
+	if !p.acquireRouter("/b.POST", 1) || !p.acquireRouter("/a.POST", 0) {
+		t.Fatal("其他路由和不限制并发的路由不受影响")
+	}
+	p.releaseRouter("/a.POST", 1)
+	if !p.acquireRouter("/a.POST", 1) {
+		t.Fatal("释放后应该可以再次获取")
+	}
+	if m := p.metrics(); m.RouterRunning["/a.POST"] != 1 || m.Rejected != 2 {
+		t.Fatalf("路由指标不对: %+v", m)
+	}
+}
+
+// TestRequestPoolStop 关闭时取出排队的请求，之后不再接收新的请求
+func TestRequestPoolStop(t *testing.T) {
+	t.Setenv("RUNNER_QUEUE_SIZE", "4")
+	p := newRequestPool() //没有启动worker，请求一直排队
+	p.submit(&nats.Msg{Subject: "a"})
+	p.submit(&nats.Msg{Subject: "b"})
+
+	msgs := p.stop()
+	if len(msgs) != 2 || msgs[0].Subject != "a" || len(p.jobs) != 0 {
+		t.Fatalf("应该取出所有排队的请求: %d", len(msgs))
+	}
+	if p.submit(&nats.Msg{Subject: "c"}) || !p.isStopped() {
+		t.Fatal("关闭后不应该再接收请求")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/yunhanshu-net/pkg/logger"
 	"gorm.io/gorm/schema"
 	"strings"
+	"time"
 )
 
 type routerInfo struct {
@@ -25,7 +26,7 @@ func (r *routerInfo) CreateTables(ctx *Context) error {
 		return nil
 	}
 	for _, table := range r.Option.GetCreateTables() {
-		err := ctx.MustGetOrInitDB().AutoMigrate(table)
+		err := ctx.dbFor(table).AutoMigrate(table)
 		if err != nil {
 			logger.Errorf(ctx, "create table %+v  error: %v", table, err)
 		}
@@ -40,6 +41,27 @@ func (r *routerInfo) CreateTables(ctx *Context) error {
 
 }
 
+// timeout 获取路由配置的超时时间（BaseConfig.Timeout，单位毫秒），0表示不超时
+func (r *routerInfo) timeout() time.Duration {
+	if r.Option == nil {
+		return 0
+	}
+	config := r.Option.GetBaseConfig()
+	if config == nil || config.Timeout <= 0 {
+		return 0
+	}
+	return time.Duration(config.Timeout) * time.Millisecond
+}
+
+// isAsync 路由是否配置了异步执行（BaseConfig.Async）
+func (r *routerInfo) isAsync() bool {
+	if r.Option == nil {
+		return false
+	}
+	config := r.Option.GetBaseConfig()
+	return config != nil && config.Async
+}
+
 func fmtKey(router string, method string) string {
 	if !strings.HasPrefix(router, "/") {
 		router = "/" + router
@@ -84,6 +106,7 @@ func (r *routerInfo) call(ctx *Context, reqBody interface{}) (req *request.RunFu
 	}
 	req = new(request.RunFunctionReq)
 	resp = new(response.RunFunctionResp)
+	resp.SetStreamSink(ctx.streamSink)
 	//ctx1 := &Context{Context: ctx}
 	err = doCall(r.Method, meta.meta, ctx, resp, reqBody)
 	if err != nil {
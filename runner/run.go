@@ -3,17 +3,15 @@ package runner
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/nats-io/nats.go"
 	"runtime"
 	"time"
 
-	"github.com/yunhanshu-net/pkg/trace"
-
 	"github.com/spf13/cobra"
 	"github.com/yunhanshu-net/function-go/pkg/dto/request"
 	"github.com/yunhanshu-net/function-go/pkg/dto/response"
-	"github.com/yunhanshu-net/pkg/constants"
 	"github.com/yunhanshu-net/pkg/logger"
 	"github.com/yunhanshu-net/pkg/x/jsonx"
 )
@@ -26,8 +24,15 @@ func (r *Runner) connectCmd(cmd *cobra.Command, args []string) {
 		writeString(err.Error())
 		return
 	}
+	getNatsConfig().applyFlags(cmd.Flags())
 	ctx := context.Background()
+	if err := checkConnectUploadConfig(ctx); err != nil {
+		writeString(err.Error())
+		return
+	}
 	r.uuid = runnerId
+	r.asyncEnabled = true
+	r.touch()
 	err = r.connectNats(ctx)
 	if err != nil {
 		writeString(err.Error())
@@ -37,9 +42,11 @@ func (r *Runner) connectCmd(cmd *cobra.Command, args []string) {
 	}
 
 	ticker := time.NewTicker(time.Second * 1)
+	stopJanitor := r.startTempJanitor(ctx)
 	logger.Infof(ctx, "listen uuid:%s\n", r.uuid)
 	defer func() {
 		ticker.Stop()
+		stopJanitor()
 		// 使用统一的Shutdown函数而不是单独关闭资源
 		//Shutdown()
 		//logger.Info(context.Background(), "开始执行系统关闭...")
@@ -58,12 +65,14 @@ func (r *Runner) connectCmd(cmd *cobra.Command, args []string) {
 		select {
 		case <-r.down:
 			logger.Infof(ctx, "%s runcher发起关闭请求，关闭连接", r.uuid)
+			r.setCloseReason(CloseReasonDown)
 			return
 		case <-ticker.C:
-			status := r.natsConn.Status()
-			if status != nats.CONNECTED {
+			// 断线重连(RECONNECTING)期间不退出，重连次数用完连接变成CLOSED之后才退出
+			if status := r.natsStatus(); status == nats.CLOSED {
 				if r.GetRunningCount() == 0 {
 					logger.Errorf(ctx, "%v 当前连接不正常，已经自己释放连接，已经结束进程", status)
+					r.setCloseReason(CloseReasonNatsLost)
 					return
 				} else {
 					logger.Errorf(ctx, "%v 当前连接不正常，还存在%v个任务在处理中，先处理完再退出", status, r.GetRunningCount())
@@ -71,10 +80,10 @@ func (r *Runner) connectCmd(cmd *cobra.Command, args []string) {
 			}
 
 			if r.idle > 0 {
-				ts := time.Now().Unix()
-				d := ts - r.lastHandelTs.Unix()
-				if (ts - r.lastHandelTs.Unix()) > r.idle { //超过指定空闲时间的话需要释放进程
+				d := r.idleTime()
+				if d > time.Duration(r.idle)*time.Second && r.GetRunningCount() == 0 { //超过指定空闲时间的话需要释放进程
 					logger.Infof(ctx, " %v没有处理消息，runner 自动关闭连接 idle config：%v", d, r.idle)
+					r.setCloseReason(CloseReasonIdle)
 					return
 				}
 			}
@@ -103,9 +112,20 @@ func runCmd(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 
-	ctx := context.WithValue(context.Background(), constants.TraceID, traceID)
-	ctx = context.WithValue(ctx, "trace_id", traceID)
-	ctx = context.WithValue(ctx, trace.FunctionMsgKey, createFunctionMsg(traceID, "", ""))
+	batch, _ := cmd.Flags().GetString("batch")
+	replay, _ := cmd.Flags().GetString("replay")
+	switch {
+	case batch != "":
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		output, _ := cmd.Flags().GetString("output")
+		r.runBatchCmd(batch, output, parallel)
+		return
+	case replay != "":
+		r.replayCmd(replay)
+		return
+	}
+
+	ctx := newRunContext(traceID)
 	var req request.RunFunctionReq
 	err = jsonx.UnmarshalFromFile(file, &req)
 	if err != nil {
@@ -138,6 +158,23 @@ func getMemoryUsage() string {
 	return fmt.Sprintf("%.2f MB", float64(m.Alloc)/1024/1024)
 }
 
+// newErrorResp 根据错误构建失败响应，结构化错误(AppError)会原样放入meta_data.app_error
+func newErrorResp(err error) *response.RunFunctionResp {
+	errorResp := &response.RunFunctionResp{
+		Msg:  err.Error(),
+		Code: -1,
+		MetaData: map[string]interface{}{
+			"error": err.Error(),
+		},
+	}
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		errorResp.TraceID = appErr.TraceID
+		errorResp.MetaData["app_error"] = appErr
+	}
+	return errorResp
+}
+
 // run 运行单次请求
 func (r *Runner) runCmd(ctx context.Context, req *request.RunFunctionReq) {
 	//ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -148,16 +185,11 @@ func (r *Runner) runCmd(ctx context.Context, req *request.RunFunctionReq) {
 	//	}
 	//}()
 
-	resp, err := r.runFunction(ctx, req)
+	c := NewContext(ctx, req.Method, req.Router, r)
+	defer c.cleanupTemp() //输出响应之后再删除临时目录
+	resp, err := r.runFunction(c, req)
 	if err != nil {
-		errorResp := &response.RunFunctionResp{
-			Msg:  err.Error(),
-			Code: -1,
-			MetaData: map[string]interface{}{
-				"error": err.Error(),
-			},
-		}
-		marshal, _ := json.Marshal(errorResp)
+		marshal, _ := json.Marshal(newErrorResp(err))
 		fmt.Println("<Response>" + string(marshal) + "</Response>")
 		return
 	}
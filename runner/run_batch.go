@@ -0,0 +1,206 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/pkg/constants"
+	"github.com/yunhanshu-net/pkg/trace"
+)
+
+// newRunContext run命令执行请求使用的上下文
+func newRunContext(traceID string) context.Context {
+	ctx := context.WithValue(context.Background(), constants.TraceID, traceID)
+	ctx = context.WithValue(ctx, "trace_id", traceID)
+	return context.WithValue(ctx, trace.FunctionMsgKey, createFunctionMsg(traceID, "", ""))
+}
+
+// BatchResult 批量执行中一个请求的结果，按行号顺序写入结果文件
+type BatchResult struct {
+	Line     int                       `json:"line"`
+	TraceID  string                    `json:"trace_id"`
+	Method   string                    `json:"method"`
+	Router   string                    `json:"router"`
+	Success  bool                      `json:"success"`
+	Cost     string                    `json:"cost"`
+	Error    string                    `json:"error,omitempty"`
+	Response *response.RunFunctionResp `json:"response,omitempty"`
+}
+
+// BatchSummary 批量执行的汇总，失败的请求不包含响应
+type BatchSummary struct {
+	Total    int            `json:"total"`
+	Success  int            `json:"success"`
+	Failed   int            `json:"failed"`
+	Cost     string         `json:"cost"`
+	Output   string         `json:"output"`
+	Failures []*BatchResult `json:"failures"`
+}
+
+// runBatchCmd run --batch，执行JSONL文件里的每个请求，结果写入output，最后输出汇总
+func (r *Runner) runBatchCmd(file string, output string, parallel int) {
+	if output == "" {
+		output = strings.TrimSuffix(file, ".jsonl") + ".results.jsonl"
+	}
+	summary, err := r.runBatch(file, output, parallel)
+	if err != nil {
+		writeJSON(newErrorResp(err))
+		return
+	}
+	writeJSON(summary)
+}
+
+func (r *Runner) runBatch(file string, output string, parallel int) (*BatchSummary, error) {
+	items, err := readBatchFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	start := time.Now()
+	results := make([]*BatchResult, len(items))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = r.runBatchItem(items[i])
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	summary := &BatchSummary{Total: len(results), Output: output, Failures: []*BatchResult{}}
+	for _, res := range results {
+		if res.Success {
+			summary.Success++
+			continue
+		}
+		summary.Failed++
+		failure := *res
+		failure.Response = nil
+		summary.Failures = append(summary.Failures, &failure)
+	}
+	summary.Cost = time.Since(start).String()
+	if err := writeBatchResults(output, results); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// batchItem 批量文件里的一行，解析失败时err不为空
+type batchItem struct {
+	line int
+	req  *request.RunFunctionReq
+	err  error
+}
+
+// readBatchFile 读取JSONL文件，每行一个RunFunctionReq，空行跳过
+func readBatchFile(file string) ([]*batchItem, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取批量请求文件失败: %w", err)
+	}
+	defer f.Close()
+
+	var items []*batchItem
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		item := &batchItem{line: line, req: &request.RunFunctionReq{}}
+		if err := json.Unmarshal([]byte(text), item.req); err != nil {
+			item.err = fmt.Errorf("第%d行解析失败: %w", line, err)
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取批量请求文件失败: %w", err)
+	}
+	return items, nil
+}
+
+// runBatchItem 执行一个请求，没有trace_id时按行号生成，流式响应只保留聚合结果
+func (r *Runner) runBatchItem(item *batchItem) *BatchResult {
+	req := item.req
+	res := &BatchResult{Line: item.line, TraceID: req.TraceID, Method: req.Method, Router: req.Router}
+	if item.err != nil {
+		res.Error = item.err.Error()
+		return res
+	}
+	if res.TraceID == "" {
+		res.TraceID = fmt.Sprintf("batch_%d_%d", time.Now().UnixNano(), item.line)
+	}
+
+	start := time.Now()
+	ctx := NewContext(newRunContext(res.TraceID), req.Method, req.Router, r)
+	defer ctx.cleanupTemp()
+	rsp, err := r.runFunctionV2(ctx, req)
+	res.Cost = time.Since(start).String()
+	if err != nil {
+		res.Error = err.Error()
+		res.Response = newErrorResp(err)
+		return res
+	}
+	res.Success = true
+	res.Response = rsp
+	return res
+}
+
+func writeBatchResults(output string, results []*BatchResult) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("创建结果文件失败: %w", err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, res := range results {
+		if err := enc.Encode(res); err != nil {
+			return fmt.Errorf("写入结果文件失败: %w", err)
+		}
+	}
+	return w.Flush()
+}
+
+// replayCmd run --replay，从录制目录读取trace_id对应的请求重新执行，新的trace_id为 replay_<原trace_id>
+// 和 Replay 一样先恢复录制时的配置快照
+func (r *Runner) replayCmd(traceID string) {
+	dir := recordDir()
+	if dir == "" {
+		writeJSON(newErrorResp(fmt.Errorf("没有配置录制目录，请设置环境变量 RUNNER_RECORD_DIR")))
+		return
+	}
+	rec, err := LoadRecording(dir, traceID)
+	if err != nil {
+		writeJSON(newErrorResp(err))
+		return
+	}
+	if rec.Request == nil {
+		writeJSON(newErrorResp(fmt.Errorf("录制 %s 没有请求内容", traceID)))
+		return
+	}
+	rec.restoreConfig()
+	replayID := replayTracePrefix + traceID
+	rec.Request.TraceID = replayID
+	r.runCmd(newRunContext(replayID), rec.Request)
+}
@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/function-go/pkg/dto/usercall"
+)
+
+func TestRunBatchAndRecording(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("RUNNER_RECORD_DIR", filepath.Join(dir, "recordings"))
+	r := newTestRunner()
+	router := fmt.Sprintf("/batch_%d", time.Now().UnixNano())
+	r.post(router, func(ctx *Context, req *slowReq, resp response.Response) error {
+		if req.N < 0 {
+			return fmt.Errorf("n不能小于0")
+		}
+		return resp.Form(map[string]int{"double": req.N * 2}).Build()
+	}, &FormFunctionOptions{})
+
+	file := filepath.Join(dir, "reqs.jsonl")
+	lines := fmt.Sprintf(`{"method":"POST","router":"%[1]s","trace_id":"t1","body":{"n":1}}
+
+{"method":"POST","router":"%[1]s","trace_id":"t2","body":{"n":-1}}
+not json
+{"method":"POST","router":"%[1]s","trace_id":"t3","body":{"n":3}}
+`, router)
+	if err := os.WriteFile(file, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "out.jsonl")
+	summary, err := r.runBatch(file, output, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Total != 4 || summary.Success != 2 || summary.Failed != 2 {
+		t.Fatalf("汇总不对: %+v", summary)
+	}
+	if summary.Failures[0].Line != 3 || summary.Failures[1].Line != 4 {
+		t.Fatalf("失败的行号不对: %+v %+v", summary.Failures[0], summary.Failures[1])
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var results []*BatchResult
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var res BatchResult
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, &res)
+	}
+	if len(results) != 4 || results[0].TraceID != "t1" || results[3].TraceID != "t3" {
+		t.Fatalf("结果应该按行号顺序写入: %+v", results)
+	}
+
+	rec, err := LoadRecording(recordDir(), "t3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Router != router || rec.Response == nil || rec.Error != "" {
+		t.Fatalf("录制内容不对: %+v", rec)
+	}
+	if rec, err := LoadRecording(recordDir(), "t2"); err != nil || rec.Error == "" {
+		t.Fatalf("失败的请求也要录制错误: %+v %v", rec, err)
+	}
+}
+
+// TestRecordAsyncAndReplayConfig 异步函数录制最终结果，run --replay 和 Replay 一样恢复配置快照
+func TestRecordAsyncAndReplayConfig(t *testing.T) {
+	chdirTemp(t)
+	t.Cleanup(CloseAllDBs)
+	dir := t.TempDir()
+	t.Setenv("RUNNER_RECORD_DIR", dir)
+	r := newTestRunner()
+	router := fmt.Sprintf("/record_async_%d", time.Now().UnixNano())
+	opt := &FormFunctionOptions{}
+	opt.Async = true
+	r.post(router, func(ctx *Context, req *slowReq, resp response.Response) error {
+		return resp.Form(map[string]int{"double": req.N * 2}).Build()
+	}, opt)
+
+	file := filepath.Join(dir, "reqs.jsonl")
+	line := fmt.Sprintf(`{"method":"POST","router":"%s","trace_id":"async1","body":{"n":2}}`, router)
+	if err := os.WriteFile(file, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.runBatch(file, filepath.Join(dir, "out.jsonl"), 1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		rec, err := LoadRecording(dir, "async1")
+		if err == nil {
+			data, _ := json.Marshal(rec.Response)
+			if !strings.Contains(string(data), `"double":4`) {
+				t.Fatalf("异步函数应该录制最终结果: %s", data)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("异步任务结束后应该有录制: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	syncRouter := router + "/sync"
+	r.post(syncRouter, func(ctx *Context, req *slowReq, resp response.Response) error {
+		return resp.Form(map[string]int{"n": req.N}).Build()
+	}, &FormFunctionOptions{})
+	config := &usercall.ConfigData{Data: map[string]interface{}{"rate": 0.5}}
+	err := SaveRecording(dir, &Recording{TraceID: "cfg1", Method: "POST", Router: syncRouter, Config: config,
+		Request: &request.RunFunctionReq{Method: "POST", Router: syncRouter, Body: map[string]int{"n": 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := captureStdout(t, func() { r.replayCmd("cfg1") })
+	if !strings.Contains(out, `"n":1`) {
+		t.Fatalf("回放结果不对: %s", out)
+	}
+	ctx := NewContext(context.Background(), "POST", syncRouter, r)
+	got := GetConfigManager().GetByKey(ctx, usercall.GenerateConfigKey(syncRouter, "POST"))
+	if data, _ := json.Marshal(got); string(data) != `{"data":{"rate":0.5}}` {
+		t.Fatalf("run --replay 应该恢复录制时的配置: %+v", got)
+	}
+}
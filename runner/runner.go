@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/yunhanshu-net/pkg/trace"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -19,7 +20,7 @@ var conn *nats.Conn
 
 func getConn() *nats.Conn {
 	if conn == nil {
-		connect, err := nats.Connect(nats.DefaultURL)
+		connect, err := getNatsConfig().connect(context.Background(), fmt.Sprintf("%s_%s", env.User, env.Name))
 		if err != nil {
 			panic(err)
 		}
@@ -44,8 +45,11 @@ func New() *Runner {
 	return &Runner{
 		idle:      0,
 		detail:    runner,
+		inflight:  &inflightTracker{},
 		routerMap: make(map[string]*routerInfo),
 		down:      make(chan struct{}, 1),
+		asyncPool: newAsyncPool(),
+		reqPool:   newRequestPool(),
 	}
 }
 
@@ -55,36 +59,57 @@ type Runner struct {
 	detail        *runnerproject.Runner
 	uuid          string
 	idle          int64
-	lastHandelTs  time.Time
-	isClosed      bool
-	natsConn      *nats.Conn
-	natsSubscribe *nats.Subscription
+	lastHandleTs  int64                  // 最后一次收到请求的时间（UnixNano），多个协程读写，用原子操作
+	closed        int32                  // 1表示已经关闭
+	closeReason   atomic.Pointer[string] // 关闭原因，见 CloseReasonXxx
+	natsConn      atomic.Pointer[nats.Conn]
+	natsSubscribe atomic.Pointer[nats.Subscription]
 	routerMap     map[string]*routerInfo
 	down          chan struct{}
-	runningCount  *uint
+	inflight      *inflightTracker // 正在处理的请求
+	detachedCount int64            // 超时后被分离但仍在运行的处理函数数量
+	asyncPool     *asyncPool
+	reqPool       *requestPool // 处理NATS请求的协程池
+	asyncEnabled  bool         // 常驻进程(connect)模式下才开启异步执行，run单次执行模式进程结束后任务会丢失
 }
 
+// GetRunningCount 获取正在处理的请求数量，包括超时后被分离但仍在运行的处理函数
 func (r *Runner) GetRunningCount() uint {
-	if r.runningCount == nil {
-		r.runningCount = new(uint)
-		return *r.runningCount
-	}
-	return *r.runningCount
+	return uint(r.inflight.running())
 }
-func (r *Runner) AddRunningCount(count uint) {
-	if r.runningCount == nil {
-		var a uint = 1
-		r.runningCount = &a
-		return
-	}
-	*r.runningCount += count
+
+// conn 当前的NATS连接，没有连接或者已经关闭时返回nil
+func (r *Runner) conn() *nats.Conn {
+	return r.natsConn.Load()
 }
-func (r *Runner) SubRunningCount(count uint) {
-	if r.runningCount == nil {
-		var a uint = 0
-		r.runningCount = &a
+
+// natsStatus 当前NATS连接的状态，没有连接时返回CLOSED
+func (r *Runner) natsStatus() nats.Status {
+	if nc := r.conn(); nc != nil {
+		return nc.Status()
 	}
-	*r.runningCount -= count
+	return nats.CLOSED
+}
+
+// touch 记录最后一次收到请求的时间，用于空闲退出
+func (r *Runner) touch() {
+	atomic.StoreInt64(&r.lastHandleTs, time.Now().UnixNano())
+}
+
+// idleTime 距离最后一次收到请求过去了多久
+func (r *Runner) idleTime() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&r.lastHandleTs)))
+}
+
+// GetDetachedCount 获取超时后被分离、仍未结束的处理函数数量
+func (r *Runner) GetDetachedCount() int64 {
+	return atomic.LoadInt64(&r.detachedCount)
+}
+func (r *Runner) AddDetachedCount(count int64) {
+	atomic.AddInt64(&r.detachedCount, count)
+}
+func (r *Runner) SubDetachedCount(count int64) {
+	atomic.AddInt64(&r.detachedCount, -count)
 }
 
 func (r *Runner) call(ctx *Context, req *request.RunFunctionReq) ([]byte, error) {
@@ -115,122 +140,50 @@ func (r *Runner) connectNats(ctx context.Context) error {
 	now := time.Now()
 	subject := r.detail.GetRequestSubject()
 
-	// 增加NATS连接选项
-	opts := []nats.Option{
-		nats.Name(fmt.Sprintf("runner_%s_%s_%s", r.detail.User, r.detail.Name, r.uuid)),
-		nats.ReconnectWait(time.Second),
-		nats.MaxReconnects(10),
+	// 地址、认证、TLS和重连策略见 NatsConfig
+	connect, err := getNatsConfig().connect(ctx, fmt.Sprintf("%s_%s_%s", r.detail.User, r.detail.Name, r.uuid),
 		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
 			logger.Warnf(ctx, "NATS连接断开: %v", err)
 		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
-			logger.Infof(ctx, "NATS已重新连接")
+			logger.Infof(ctx, "NATS已重新连接: %s", nc.ConnectedUrlRedacted())
 		}),
 		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
 			logger.Errorf(ctx, "NATS错误: %v", err)
 		}),
-	}
-
-	// 尝试连接NATS，带重试逻辑
-	var connect *nats.Conn
-	var err error
-	maxRetries := 3
-
-	for i := 0; i < maxRetries; i++ {
-		logger.Infof(ctx, "正在连接NATS服务器 (尝试: %d/%d)", i+1, maxRetries)
-		connect, err = nats.Connect(nats.DefaultURL, opts...)
-		if err == nil {
-			break
-		}
-		logger.Warnf(ctx, "NATS连接失败，将在1秒后重试: %v", err)
-		time.Sleep(time.Second)
-	}
-
+	)
 	if err != nil {
 		return fmt.Errorf("无法连接NATS服务器: %w", err)
 	}
 
-	r.natsConn = connect
-
-	// 设置消息处理
-	subscribe, err := r.natsConn.QueueSubscribe(subject, subject, func(msg *nats.Msg) {
-		go func() {
-			r.lastHandelTs = time.Now()
-			start := time.Now()
-
-			// 创建响应消息
-			respMsg := nats.NewMsg("function-runner.sub")
-			//ctx1 := context.WithValue(context.Background(), constants.TraceID, msg.Header.Get(constants.TraceID))
-
-			data := msg.Data
-			var req request.RunFunctionReq
-			err1 := json.Unmarshal(data, &req)
-			if err1 != nil {
-				logger.Errorf(ctx, "call  json.Unmarshal(data, &req) err,req:%+v err:%s", req, err1.Error())
-				return
-			}
+	r.natsConn.Store(connect)
 
-			// 创建FunctionMsg
-			functionMsg := &trace.FunctionMsg{
-				User:         env.User,
-				Runner:       env.Name,
-				Version:      env.Version,
-				Method:       req.Method,
-				Router:       req.Router,
-				TraceID:      msg.Header.Get(constants.TraceID),
-				RequestUser:  msg.Header.Get(constants.RequestUserInfo),
-				UploadConfig: getUploadConfig(),
-			}
-			logger.Infof(ctx, "call RunFunction RequestUser:%s", functionMsg.RequestUser)
-
-			// 设置多个TraceID键，确保各种场景都能正确获取
-			c := context.WithValue(ctx, trace.FunctionMsgKey, functionMsg)
-			ctx2 := context.WithValue(c, constants.TraceID, functionMsg.TraceID)
-			//// 同时设置pkg/logger期望的键
-			//c = logger.WithContext(ctx, functionMsg.TraceID)
-			newContext := NewContext(ctx2, req.Method, req.Router, r)
-			newContext.FunctionMsg = functionMsg
-
-			rspData, err := r.call(newContext, &req)
-
-			respMsg.Header = msg.Header
-			if err != nil {
-				respMsg.Header.Set("code", "-1")
-				respMsg.Header.Set("msg", err.Error())
-				logger.Errorf(ctx, "处理请求失败: %v", err)
-			} else {
-				respMsg.Data = rspData
-				respMsg.Header.Set("code", "0")
-			}
-
-			//推送消息给function-server 不经过runtime
-			err = r.natsConn.PublishMsg(respMsg)
-			if err != nil {
-				logger.Errorf(ctx, "响应请求失败: %v", err)
+	// 设置消息处理，请求先进入有界队列，队列满了直接响应繁忙
+	r.reqPool.start(func(msg *nats.Msg) {
+		r.handleNatsMsg(ctx, msg)
+	})
+	subscribe, err := connect.QueueSubscribe(subject, subject, func(msg *nats.Msg) {
+		if !r.reqPool.submit(msg) {
+			reason := "请求队列已满"
+			if r.reqPool.isStopped() {
+				reason = "runner正在关闭"
 			}
-			//logger.Infof(ctx, "响应消息成功：%s", respMsg.Data)
-			// 响应请求
-			//if err := msg.RespondMsg(respMsg); err != nil {
-			//	logger.Errorf(ctx, "响应请求失败: %v", err)
-			//	return
-			//}
-
-			logger.Debugf(ctx, "请求处理完成，耗时: %v", time.Since(start))
-		}()
+			r.replyBusy(ctx, msg, reason)
+		}
 	})
 	logger.Infof(ctx, "已连接到NATS服务器，监听主题: %s", subject)
 	if err != nil {
 		return fmt.Errorf("无法订阅主题 %s: %w", subject, err)
 	}
 
-	r.natsSubscribe = subscribe
+	r.natsSubscribe.Store(subscribe)
 
 	logger.Infof(ctx, "uuid: %s", r.uuid)
 	// 发送就绪消息
 	msg := nats.NewMsg(r.uuid)
 	msg.Header.Set("code", "0")
 
-	respMsg, err := r.natsConn.RequestMsg(msg, time.Second*5)
+	respMsg, err := connect.RequestMsg(msg, time.Second*5)
 
 	if err != nil {
 		return fmt.Errorf("无法发送就绪消息: %w", err)
@@ -246,24 +199,107 @@ func (r *Runner) connectNats(ctx context.Context) error {
 	return nil
 }
 
+// handleNatsMsg 处理一个NATS请求并把结果响应给请求方，见 respond
+func (r *Runner) handleNatsMsg(ctx context.Context, msg *nats.Msg) {
+	r.touch()
+	start := time.Now()
+	if r.inflight.isDraining() {
+		r.replyBusy(ctx, msg, "runner正在关闭")
+		return
+	}
+
+	data := msg.Data
+	var req request.RunFunctionReq
+	err1 := json.Unmarshal(data, &req)
+	if err1 != nil {
+		logger.Errorf(ctx, "call  json.Unmarshal(data, &req) err,req:%+v err:%s", req, err1.Error())
+		header := responseHeader(msg)
+		setResponseHeader(header, "-1", "请求格式错误: "+err1.Error(), msg.Header.Get(constants.TraceID))
+		body, _ := json.Marshal(newErrorResp(err1))
+		if err := r.respond(ctx, msg, header, body); err != nil {
+			logger.Errorf(ctx, "响应请求失败: %v", err)
+		}
+		return
+	}
+
+	// 创建FunctionMsg
+	functionMsg := &trace.FunctionMsg{
+		User:         env.User,
+		Runner:       env.Name,
+		Version:      env.Version,
+		Method:       req.Method,
+		Router:       req.Router,
+		TraceID:      msg.Header.Get(constants.TraceID),
+		RequestUser:  msg.Header.Get(constants.RequestUserInfo),
+		UploadConfig: getUploadConfig(),
+	}
+	logger.Infof(ctx, "call RunFunction RequestUser:%s", functionMsg.RequestUser)
+
+	// 设置多个TraceID键，确保各种场景都能正确获取
+	c := context.WithValue(ctx, trace.FunctionMsgKey, functionMsg)
+	ctx2 := context.WithValue(c, constants.TraceID, functionMsg.TraceID)
+	//// 同时设置pkg/logger期望的键
+	//c = logger.WithContext(ctx, functionMsg.TraceID)
+	newContext := NewContext(ctx2, req.Method, req.Router, r)
+	newContext.FunctionMsg = functionMsg
+	defer newContext.cleanupTemp() //响应发送之后再删除临时目录
+	var streamed int32
+	newContext.streamSink = r.natsStreamSink(msg, functionMsg.TraceID, &streamed)
+
+	// 单个路由的并发限制
+	if worker, ok := r.getRouter(req.Router, req.Method); ok {
+		if limit := worker.maxConcurrency(); limit > 0 {
+			if !r.reqPool.acquireRouter(worker.key, limit) {
+				r.replyBusy(ctx, msg, fmt.Sprintf("路由 %s 超过最大并发 %d", req.Router, limit))
+				return
+			}
+			defer r.reqPool.releaseRouter(worker.key, limit)
+		}
+	}
+
+	rspData, err := r.call(newContext, &req)
+
+	header := responseHeader(msg)
+	if err != nil {
+		setResponseHeader(header, "-1", err.Error(), functionMsg.TraceID)
+		rspData, _ = json.Marshal(newErrorResp(err))
+		logger.Errorf(ctx, "处理请求失败: %v", err)
+	} else {
+		setResponseHeader(header, "0", "", functionMsg.TraceID)
+	}
+	if atomic.LoadInt32(&streamed) == 1 {
+		header.Set(natsHeaderStream, streamEnd)
+	}
+
+	//有reply subject时直接回复给请求方，否则推送给function-server 不经过runtime
+	if err := r.respond(ctx, msg, header, rspData); err != nil {
+		logger.Errorf(ctx, "响应请求失败: %v", err)
+	}
+
+	logger.Debugf(ctx, "请求处理完成，耗时: %v", time.Since(start))
+}
+
+// publish 通过当前连接发送消息，连接已经关闭时返回错误
+func (r *Runner) publish(msg *nats.Msg) error {
+	nc := r.conn()
+	if nc == nil {
+		return nats.ErrConnectionClosed
+	}
+	return nc.PublishMsg(msg)
+}
+
 // close 安全关闭连接和订阅
 func (r *Runner) close(ctx context.Context) error {
 	// 防止重复关闭
-	if r.isClosed {
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
 		logger.Debugf(ctx, "Runner已经关闭，跳过")
 		return nil
 	}
 
-	// 标记为已关闭
-	r.isClosed = true
-
 	var closeErr error
 
 	// 1. 先尝试清理订阅
-	if r.natsSubscribe != nil {
-		subToClose := r.natsSubscribe
-		r.natsSubscribe = nil // 立即置空，防止重复关闭
-
+	if subToClose := r.natsSubscribe.Swap(nil); subToClose != nil {
 		if err := subToClose.Drain(); err != nil {
 			logger.Debugf(ctx, "清理订阅时出错: %v", err)
 			closeErr = fmt.Errorf("清理订阅错误: %w", err)
@@ -272,9 +308,7 @@ func (r *Runner) close(ctx context.Context) error {
 	}
 
 	// 2. 处理NATS连接
-	if r.natsConn != nil {
-		connToClose := r.natsConn
-		r.natsConn = nil // 立即置空，防止重复关闭
+	if connToClose := r.natsConn.Swap(nil); connToClose != nil {
 
 		// 发送关闭通知（尽最大努力）
 		if r.detail != nil { // 检查detail是否为nil
@@ -0,0 +1,323 @@
+package runner
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/pkg/constants"
+	"github.com/yunhanshu-net/pkg/logger"
+	"github.com/yunhanshu-net/pkg/trace"
+)
+
+// HTTP模式的请求头
+const (
+	httpHeaderTraceID = "X-Trace-Id"
+	httpHeaderUser    = "X-Request-User" // 请求用户信息，等价于NATS请求头里的 constants.RequestUserInfo，只有配置了访问令牌才会使用
+)
+
+// serveCmd 本地HTTP模式，不依赖平台直接通过HTTP调用所有路由，例如：
+//
+//	./app serve --addr 127.0.0.1:8080
+//	curl 'localhost:8080/user/list?page=1'
+//	curl -X POST localhost:8080/user/create -d '{"name":"张三"}'
+//
+// 默认没有鉴权，所有请求按未登录用户处理，只能监听本机地址。
+// 配置 RUNNER_SERVE_TOKEN 后请求需要带上 Authorization: Bearer <token>，由前面的网关通过 X-Request-User 传递用户信息，
+// 这时才可以监听其他地址
+func (r *Runner) serveCmd(cmd *cobra.Command, args []string) {
+	addr, err := cmd.Flags().GetString("addr")
+	if err != nil {
+		writeString(err.Error())
+		return
+	}
+	if err := checkListenAddr(addr); err != nil {
+		writeString(err.Error())
+		return
+	}
+	r.asyncEnabled = true
+	r.touch()
+	r.listenHTTP(context.Background(), addr, r.httpHandler())
+}
+
+// listenHTTP 启动HTTP服务直到进程收到退出信号，退出前停止接收新连接并等待处理中的请求结束，之后由Shutdown执行关闭回调和释放资源
+func (r *Runner) listenHTTP(ctx context.Context, addr string, handler http.Handler) {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+	logger.Infof(ctx, "HTTP服务已启动，监听地址: %s", addr)
+	stopJanitor := r.startTempJanitor(ctx)
+	defer stopJanitor()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf(ctx, "HTTP服务异常退出: %v", err)
+			writeString(err.Error())
+		}
+		return
+	case s := <-sig:
+		logger.Infof(ctx, "收到信号 %v，停止HTTP服务", s)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, drainTimeout())
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf(ctx, "停止HTTP服务失败: %v", err)
+	}
+}
+
+// serveToken HTTP模式的访问令牌，环境变量 RUNNER_SERVE_TOKEN
+func serveToken() string {
+	return getEnvOrDefault("RUNNER_SERVE_TOKEN", "")
+}
+
+// checkListenAddr 没有配置访问令牌时只允许监听本机地址
+func checkListenAddr(addr string) error {
+	if serveToken() != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("监听地址不合法: %s", addr)
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	return fmt.Errorf("没有配置 RUNNER_SERVE_TOKEN 时HTTP模式没有鉴权，只能监听本机地址（例如 127.0.0.1:8080），当前: %s", addr)
+}
+
+// httpRequestUser 校验访问令牌并返回请求用户，没有配置令牌时不信任 X-Request-User，按未登录用户处理
+func httpRequestUser(hr *http.Request) (string, bool) {
+	token := serveToken()
+	if token == "" {
+		return "", true
+	}
+	got, ok := strings.CutPrefix(hr.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		return "", false
+	}
+	return hr.Header.Get(httpHeaderUser), true
+}
+
+// httpHandler 所有路由按相同的方法语义暴露为HTTP接口，另外提供 /_healthz 和 /_readyz 健康检查
+// 使用本地存储时，上传的文件通过 UPLOAD_LOCAL_BASE_URL（默认 /_files）下载
+func (r *Runner) httpHandler() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_healthz", func(w http.ResponseWriter, req *http.Request) {
+		writeHTTPJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/_readyz", func(w http.ResponseWriter, req *http.Request) {
+		if r.inflight.isDraining() {
+			writeHTTPJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+			return
+		}
+		writeHTTPJSON(w, http.StatusOK, map[string]interface{}{"status": "ready", "running": r.GetRunningCount()})
+	})
+	if storage, err := getStorageProvider(); err == nil {
+		if local, ok := storage.(*LocalStorage); ok && strings.HasPrefix(local.BaseURL, "/") {
+			mux.Handle(local.BaseURL+"/", http.StripPrefix(local.BaseURL, http.FileServer(http.Dir(local.Dir))))
+		}
+	}
+	mux.HandleFunc("/", r.serveHTTP)
+	return mux
+}
+
+// serveHTTP 把HTTP请求转换成RunFunctionReq执行，GET使用查询参数，其他方法使用JSON body
+// 请求头 Accept: text/event-stream 时流式响应的分片以SSE的方式输出
+func (r *Runner) serveHTTP(w http.ResponseWriter, hr *http.Request) {
+	r.touch()
+	req := &request.RunFunctionReq{Method: hr.Method, Router: hr.URL.Path, UrlQuery: hr.URL.RawQuery}
+	traceID := hr.Header.Get(httpHeaderTraceID)
+	if traceID == "" {
+		traceID = uuid.New().String()
+	}
+	req.TraceID = traceID
+	w.Header().Set(httpHeaderTraceID, traceID)
+
+	requestUser, ok := httpRequestUser(hr)
+	if !ok {
+		writeHTTPJSON(w, http.StatusUnauthorized, newErrorResp(&AppError{
+			Code:    "E_UNAUTHORIZED",
+			Message: "访问令牌错误",
+			Hint:    "请求头需要带上 Authorization: Bearer <RUNNER_SERVE_TOKEN>",
+			TraceID: traceID,
+		}))
+		return
+	}
+	if _, ok := r.getRouter(req.Router, req.Method); !ok {
+		writeHTTPJSON(w, http.StatusNotFound, newErrorResp(&AppError{
+			Code:    "E_NOT_FOUND",
+			Message: fmt.Sprintf("路由未找到: [%s] %s", req.Method, req.Router),
+			Hint:    "可以通过 /_help 查看所有路由",
+			TraceID: traceID,
+		}))
+		return
+	}
+	if !req.IsMethodGet() {
+		body, err := io.ReadAll(hr.Body)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		if len(strings.TrimSpace(string(body))) > 0 {
+			var m map[string]interface{}
+			if err := json.Unmarshal(body, &m); err != nil {
+				writeHTTPJSON(w, http.StatusBadRequest, newErrorResp(&AppError{
+					Code:    "E_VALIDATION",
+					Message: "请求体不是合法的JSON对象",
+					Detail:  err.Error(),
+					TraceID: traceID,
+				}))
+				return
+			}
+			req.Body = m
+		}
+	}
+
+	functionMsg := createFunctionMsg(traceID, req.Method, req.Router)
+	functionMsg.RequestUser = requestUser
+	c := context.WithValue(hr.Context(), trace.FunctionMsgKey, functionMsg)
+	c = context.WithValue(c, constants.TraceID, traceID)
+	ctx := NewContext(c, req.Method, req.Router, r)
+	ctx.FunctionMsg = functionMsg
+	defer ctx.cleanupTemp() //响应写完之后再删除临时目录
+
+	var sse *sseWriter
+	if strings.Contains(hr.Header.Get("Accept"), "text/event-stream") {
+		if flusher, ok := w.(http.Flusher); ok {
+			sse = &sseWriter{w: w, flusher: flusher}
+			ctx.streamSink = sse.chunk
+			ctx.progressSink = sse.progress
+		}
+	}
+
+	resp, err := r.runFunctionV2(ctx, req)
+	if sse != nil && sse.finish(resp, err) {
+		return
+	}
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, resp)
+}
+
+// httpStatus 根据结构化错误的错误码返回对应的HTTP状态码
+func httpStatus(err error) int {
+	var appErr *AppError
+	if !errors.As(err, &appErr) {
+		return http.StatusInternalServerError
+	}
+	switch appErr.Code {
+	case "E_VALIDATION":
+		return http.StatusBadRequest
+	case "E_UNAUTHORIZED":
+		return http.StatusUnauthorized
+	case "E_FORBIDDEN":
+		return http.StatusForbidden
+	case "E_NOT_FOUND", "E_TASK_NOT_FOUND":
+		return http.StatusNotFound
+	case "E_BUSY", "E_ASYNC_QUEUE_FULL":
+		return http.StatusTooManyRequests
+	case "E_RUNNER_CLOSING", "E_DEP_NOT_READY":
+		return http.StatusServiceUnavailable
+	case "E_TIMEOUT":
+		return http.StatusGatewayTimeout
+	case "E_NOT_SUPPORTED":
+		return http.StatusNotImplemented
+	case "E_TEMP_QUOTA":
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	writeHTTPJSON(w, httpStatus(err), newErrorResp(err))
+}
+
+func writeHTTPJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// sseWriter 以SSE输出流式响应，每个分片是一个chunk事件，进度是progress事件，最后输出一个response事件
+// 超时被分离的处理函数可能在响应结束后继续写分片，结束后的分片直接丢弃
+type sseWriter struct {
+	lock     sync.Mutex
+	w        http.ResponseWriter
+	flusher  http.Flusher
+	started  bool
+	finished bool
+}
+
+func (s *sseWriter) chunk(chunk *response.StreamChunk) error {
+	return s.event(streamChunk, chunk)
+}
+
+// progress 进度事件，见 Context.Progress
+func (s *sseWriter) progress(event *ProgressEvent) error {
+	return s.event("progress", event)
+}
+
+// event 输出一个事件，第一个事件写出SSE的响应头
+func (s *sseWriter) event(event string, v interface{}) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.finished {
+		return errors.New("响应已经结束")
+	}
+	if !s.started {
+		s.w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	return s.write(event, v)
+}
+
+// finish 结束流式输出，输出过事件时把最终结果作为response事件输出并返回true，否则按普通JSON响应
+func (s *sseWriter) finish(resp *response.RunFunctionResp, err error) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.finished = true
+	if !s.started {
+		return false
+	}
+	if err != nil {
+		resp = newErrorResp(err)
+	}
+	_ = s.write("response", resp)
+	return true
+}
+
+func (s *sseWriter) write(event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
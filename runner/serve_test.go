@@ -0,0 +1,165 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+)
+
+type serveReq struct {
+	Name string `json:"name" form:"name"`
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := newTestRunner()
+	prefix := fmt.Sprintf("/serve_%d", time.Now().UnixNano())
+	r.get(prefix+"/hello", func(ctx *Context, req *serveReq, resp response.Response) error {
+		return resp.Form(map[string]string{"hello": req.Name}).Build()
+	}, &FormFunctionOptions{})
+	r.post(prefix+"/hello", func(ctx *Context, req *serveReq, resp response.Response) error {
+		if req.Name == "" {
+			return ValidationError(ctx, map[string]string{"name": "必填"})
+		}
+		return resp.Form(map[string]string{"hello": req.Name}).Build()
+	}, &FormFunctionOptions{})
+	r.post(prefix+"/chat", func(ctx *Context, req *serveReq, resp response.Response) error {
+		stream := resp.Stream()
+		_ = stream.Text("a")
+		_ = stream.Text("b")
+		return stream.Build()
+	}, &FormFunctionOptions{})
+
+	srv := httptest.NewServer(r.httpHandler())
+	defer srv.Close()
+
+	decode := func(res *http.Response) map[string]interface{} {
+		defer res.Body.Close()
+		var m map[string]interface{}
+		if err := json.NewDecoder(res.Body).Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	res, err := http.Get(srv.URL + prefix + "/hello?name=go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := decode(res); res.StatusCode != http.StatusOK || m["data"].(map[string]interface{})["hello"] != "go" {
+		t.Fatalf("GET应该使用查询参数: %d %v", res.StatusCode, m)
+	}
+	if res.Header.Get(httpHeaderTraceID) == "" {
+		t.Fatal("没有传trace id时应该自动生成")
+	}
+
+	res, _ = http.Post(srv.URL+prefix+"/hello", "application/json", strings.NewReader(`{"name":"post"}`))
+	if m := decode(res); res.StatusCode != http.StatusOK || m["data"].(map[string]interface{})["hello"] != "post" {
+		t.Fatalf("POST应该使用JSON body: %d %v", res.StatusCode, m)
+	}
+	res, _ = http.Post(srv.URL+prefix+"/hello", "application/json", strings.NewReader(`{}`))
+	if decode(res); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("校验失败应该返回400: %d", res.StatusCode)
+	}
+	res, _ = http.Post(srv.URL+prefix+"/missing", "application/json", nil)
+	if decode(res); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("路由不存在应该返回404: %d", res.StatusCode)
+	}
+	res, _ = http.Get(srv.URL + "/_healthz")
+	if decode(res); res.StatusCode != http.StatusOK {
+		t.Fatalf("健康检查失败: %d", res.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+prefix+"/chat", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if strings.Count(string(body), "event: chunk") != 2 || !strings.Contains(string(body), "event: response") {
+		t.Fatalf("SSE输出不对: %s", body)
+	}
+}
+
+func TestServeToken(t *testing.T) {
+	r := newTestRunner()
+	router := fmt.Sprintf("/serve_token_%d", time.Now().UnixNano())
+	r.get(router, func(ctx *Context, req *serveReq, resp response.Response) error {
+		return resp.Form(map[string]interface{}{"admin": ctx.UserInfo().HasRole("admin")}).Build()
+	}, &FormFunctionOptions{})
+	srv := httptest.NewServer(r.httpHandler())
+	defer srv.Close()
+	call := func(token string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+router, nil)
+		req.Header.Set(httpHeaderUser, `{"id":"1","roles":["admin"]}`)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	if code, body := call(""); code != http.StatusOK || !strings.Contains(body, `"admin":false`) {
+		t.Errorf("没有配置令牌时不应该信任 X-Request-User: %d %s", code, body)
+	}
+	if err := checkListenAddr(":8080"); err == nil {
+		t.Error("没有配置令牌时不应该允许监听所有地址")
+	}
+	if err := checkListenAddr("127.0.0.1:8080"); err != nil {
+		t.Error(err)
+	}
+
+	t.Setenv("RUNNER_SERVE_TOKEN", "secret")
+	if code, _ := call("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("令牌错误应该返回401: %d", code)
+	}
+	if code, body := call("secret"); code != http.StatusOK || !strings.Contains(body, `"admin":true`) {
+		t.Errorf("令牌正确时使用 X-Request-User: %d %s", code, body)
+	}
+	if err := checkListenAddr(":8080"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDevConsole(t *testing.T) {
+	r := newTestRunner()
+	srv := httptest.NewServer(r.devHandler())
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/_dev/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "/_getApiInfos") {
+		t.Fatalf("控制台页面不对: %d", res.StatusCode)
+	}
+
+	path := t.TempDir() + "/test.log"
+	content := "a trace-1 第一行\nb trace-2\nc trace-1 第二行\nd trace-1 第三行\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := grepLogFile(path, "trace-1", 2)
+	if err != nil || len(lines) != 2 || !strings.Contains(lines[1], "第三行") {
+		t.Fatalf("应该只保留最后2行: %v %v", lines, err)
+	}
+	if lines, err := grepLogFile(path+".missing", "trace-1", 2); err != nil || len(lines) != 0 {
+		t.Fatalf("日志文件不存在时返回空: %v %v", lines, err)
+	}
+}
@@ -17,6 +17,9 @@ func writeString(msg string) {
 	fmt.Println("<Response>" + msg + "</Response>")
 }
 
+// logFile 日志文件，dev控制台会按trace_id从这里读取请求的日志
+const logFile = "logs/function-go.log"
+
 var cmd *cobra.Command
 var r *Runner
 
@@ -37,8 +40,13 @@ func newCmd() *cobra.Command {
 	run.Flags().String("method", "POST", "HTTP 方法") // 长格式 --method
 	run.Flags().String("router", "", "请求路由路径")      // 长格式 --router
 	run.Flags().String("trace_id", "", "请求跟踪ID")    // 长格式 --trace_id
+	run.Flags().String("batch", "", "批量执行，每行一个请求的JSONL文件")
+	run.Flags().Int("parallel", 4, "批量执行的并发数")
+	run.Flags().String("output", "", "批量执行结果的JSONL文件，默认 <batch>.results.jsonl")
+	run.Flags().String("replay", "", "重新执行录制的请求，值为trace_id，录制目录见 RUNNER_RECORD_DIR")
 	connect := &cobra.Command{Use: "connect", Short: "建立连接", Run: r.connectCmd}
 	connect.Flags().String("runner_id", "", "runnerId") // 长格式 --connect_id
+	addNatsFlags(connect.Flags())
 	//syscall := &cobra.Command{Use: "syscall", Short: "系统回调", Run: r.syscallCmd}
 	userCall := &cobra.Command{Use: "usercall", Short: "用户回调", Run: r.userCallCmd}
 	userCall.Flags().String("type", "", "回调类型")
@@ -47,11 +55,19 @@ func newCmd() *cobra.Command {
 	userCall.Flags().String("file", "", "回调body文件路径")
 	userCall.Flags().String("trace_id", "", "请求跟踪ID") // 长格式 --trace_id
 
+	serve := &cobra.Command{Use: "serve", Short: "本地HTTP服务", Run: r.serveCmd}
+	serve.Flags().String("addr", "127.0.0.1:8080", "HTTP监听地址，监听其他地址需要配置 RUNNER_SERVE_TOKEN")
+
+	dev := &cobra.Command{Use: "dev", Short: "本地开发控制台", Run: r.devCmd}
+	dev.Flags().String("addr", "127.0.0.1:7070", "控制台监听地址")
+
 	apis := &cobra.Command{Use: "apis", Short: "apis", Run: r.apisCmd}
 
 	app.AddCommand(run)
 	app.AddCommand(apis)
 	app.AddCommand(connect)
+	app.AddCommand(serve)
+	app.AddCommand(dev)
 	app.AddCommand(userCall)
 	return app
 }
@@ -62,7 +78,7 @@ func Run() error {
 	// 初始化日志
 	logCfg := logger.Config{
 		Level:      "debug",
-		Filename:   "logs/function-go.log",
+		Filename:   logFile,
 		MaxSize:    100,
 		MaxBackups: 10,
 		MaxAge:     30,
@@ -82,13 +98,13 @@ func Run() error {
 	return nil
 }
 
-// Shutdown 统一的资源关闭入口，处理所有资源的释放
+// Shutdown 统一的资源关闭入口，处理所有资源的释放，常驻进程会在关闭连接前后执行所有路由的 BeforeRunnerClose/AfterRunnerClose
 func Shutdown() {
 	shutdownOnce.Do(func() {
-		logger.Info(context.Background(), "开始执行系统关闭...")
+		logger.Infof(context.Background(), "开始执行系统关闭... reason:%s", r.getCloseReason())
 
-		// 1. 先关闭Runner连接，包括NATS连接等
-		if err := r.close(context.Background()); err != nil {
+		// 1. 先停止接收请求并等待处理中的请求结束，再关闭Runner连接，包括NATS连接等
+		if err := r.drain(context.Background(), drainTimeout()); err != nil {
 			logger.Errorf(context.Background(), "关闭Runner连接失败: %v", err)
 		}
 
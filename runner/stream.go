@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/pkg/x/jsonx"
+)
+
+// 流式响应的消息头，请求头 accept-stream=true 的调用方才会收到分片，分片和最终响应发到同一个subject
+// 分片消息 stream=chunk 并带上 stream-seq，最终响应 stream=end；其他调用方只收到一条聚合后的最终响应
+const (
+	natsHeaderAcceptStream = "accept-stream"
+	natsHeaderStream       = "stream"
+	natsHeaderStreamSeq    = "stream-seq"
+
+	streamChunk = "chunk"
+	streamEnd   = "end"
+)
+
+// cliStreamSink run命令模式下每个分片输出一个<Chunk>标签，最后仍然输出<Response>
+func cliStreamSink(chunk *response.StreamChunk) error {
+	fmt.Println("<Chunk>" + jsonx.String(chunk) + "</Chunk>")
+	return nil
+}
+
+// natsStreamSink connect模式下每个分片推送一条NATS消息，streamed标记是否推送过分片，最终响应据此带上 stream=end
+// 调用方没有声明 accept-stream 时返回nil，只做聚合
+func (r *Runner) natsStreamSink(req *nats.Msg, traceID string, streamed *int32) response.StreamSink {
+	if !acceptsHeader(req, natsHeaderAcceptStream) {
+		return nil
+	}
+	return func(chunk *response.StreamChunk) error {
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		msg := nats.NewMsg(getNatsConfig().replySubject(req))
+		msg.Header = responseHeader(req)
+		setResponseHeader(msg.Header, "0", "", traceID)
+		msg.Header.Set(natsHeaderStream, streamChunk)
+		msg.Header.Set(natsHeaderStreamSeq, strconv.Itoa(chunk.Seq))
+		msg.Data = data
+		atomic.StoreInt32(streamed, 1)
+		return r.publish(msg)
+	}
+}
@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+)
+
+func TestStreamResponse(t *testing.T) {
+	r := newTestRunner()
+	router := fmt.Sprintf("/stream_%d", time.Now().UnixNano())
+	r.post(router, func(ctx *Context, req *slowReq, resp response.Response) error {
+		stream := resp.Stream()
+		for _, delta := range []string{"你", "好"} {
+			if err := stream.Text(delta); err != nil {
+				return err
+			}
+		}
+		if err := stream.Rows(map[string]int{"n": req.N}); err != nil {
+			return err
+		}
+		if err := stream.Log("done"); err != nil {
+			return err
+		}
+		if err := stream.Build(); err != nil {
+			return err
+		}
+		if err := stream.Text("x"); err == nil {
+			t.Error("Build之后不应该再写入")
+		}
+		return nil
+	}, &FormFunctionOptions{})
+
+	var chunks []*response.StreamChunk
+	ctx := NewContext(context.Background(), "POST", router, r)
+	ctx.streamSink = func(chunk *response.StreamChunk) error {
+		chunks = append(chunks, chunk)
+		return nil
+	}
+	resp, err := r.runFunctionV2(ctx, &request.RunFunctionReq{Method: "POST", Router: router, Body: map[string]int{"n": 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 4 || chunks[0].Type != response.StreamChunkText || chunks[3].Seq != 3 || chunks[3].Type != response.StreamChunkLog {
+		t.Fatalf("分片不对: %+v", chunks)
+	}
+	result, ok := resp.Data.(*response.StreamResult)
+	if !ok || resp.RenderType != response.RenderTypeStream {
+		t.Fatalf("最终结果应该是聚合后的StreamResult: %T %s", resp.Data, resp.RenderType)
+	}
+	if result.Text != "你好" || len(result.Rows) != 1 || len(result.Logs) != 1 || result.Chunks != 4 {
+		t.Fatalf("聚合结果不对: %+v", result)
+	}
+}
+
+func TestNatsStreamOptIn(t *testing.T) {
+	r := newTestRunner()
+	var streamed int32
+	if sink := r.natsStreamSink(&nats.Msg{Reply: "_INBOX.1"}, "t1", &streamed); sink != nil {
+		t.Fatal("普通的 nc.Request 调用方不应该收到分片，只收到聚合后的最终响应")
+	}
+	req := &nats.Msg{Reply: "_INBOX.1", Header: nats.Header{natsHeaderAcceptStream: []string{"true"}}}
+	if sink := r.natsStreamSink(req, "t1", &streamed); sink == nil {
+		t.Fatal("声明了 accept-stream 时应该推送分片")
+	}
+}
@@ -0,0 +1,279 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yunhanshu-net/pkg/logger"
+)
+
+// 临时目录 ./temp/<router>/<traceID>/... 的生命周期：
+//   - 请求的响应发送之后删除，超时后被分离的处理函数在它真正结束时删除，调用 ctx.FS().KeepTemp() 的除外
+//   - 常驻进程（connect/serve/dev）后台定期删除超过 RUNNER_TEMP_MAX_AGE_HOURS（默认24小时）没有修改的目录
+//   - 通过 ctx.FS().CreateTempFile/WriteTempFile 写入的数据受 RUNNER_TEMP_QUOTA_MB（默认1024，0表示不限制）限制，
+//     直接用os写入的文件不会立即检查，下次创建TempFile时才计入已使用的空间
+const (
+	tempDirName         = "temp"
+	tempJanitorInterval = 10 * time.Minute
+)
+
+// requestTemp 请求的临时目录状态，处理函数使用的Context副本（见 withContext）和原Context共享
+type requestTemp struct {
+	mu   sync.Mutex
+	base string       // 当前请求的临时目录，见 TempBaseDir
+	keep bool         // 请求结束后保留临时目录，见 ctx.FS().KeepTemp()
+	held bool         // 处理函数超时后被分离，等它结束时再删除，见 holdTemp
+	used atomic.Int64 // 临时目录已使用的字节数，见 TempFile
+}
+
+// activeTempDirs 正在处理的请求的临时目录，后台清理时跳过
+var activeTempDirs sync.Map
+
+// tempRouterDir 路由对应的目录名，/api/export 对应 api.export
+func tempRouterDir(router string) string {
+	router = strings.Trim(router, "/")
+	if router == "" {
+		return "_"
+	}
+	return unsafeFileChars.ReplaceAllString(strings.ReplaceAll(router, "/", "."), "_")
+}
+
+func tempQuota() int64 {
+	return int64(getEnvIntOrDefault("RUNNER_TEMP_QUOTA_MB", 1024)) << 20
+}
+
+func tempMaxAge() time.Duration {
+	return time.Duration(getEnvIntOrDefault("RUNNER_TEMP_MAX_AGE_HOURS", 24)) * time.Hour
+}
+
+func (c *Context) keepTemp() {
+	c.temp.mu.Lock()
+	defer c.temp.mu.Unlock()
+	c.temp.keep = true
+}
+
+// holdTemp 处理函数超时后被分离时调用，响应发送后的 cleanupTemp 不再删除临时目录，
+// 分离的处理函数结束时调用 releaseTemp 删除
+func (c *Context) holdTemp() {
+	c.temp.mu.Lock()
+	defer c.temp.mu.Unlock()
+	c.temp.held = true
+}
+
+func (c *Context) releaseTemp() {
+	c.temp.mu.Lock()
+	c.temp.held = false
+	c.temp.mu.Unlock()
+	c.cleanupTemp()
+}
+
+// cleanupTemp 请求的响应发送后删除临时目录，空的路由目录由后台清理任务删除
+// 在 handleNatsMsg/serveHTTP 等发送响应的地方调用，处理函数返回的文件在响应发送前都可以访问
+func (c *Context) cleanupTemp() {
+	t := c.temp
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.base == "" || t.held {
+		return
+	}
+	activeTempDirs.Delete(t.base)
+	if t.keep {
+		logger.Infof(c, "保留临时目录: %s", t.base)
+		return
+	}
+	if err := os.RemoveAll(t.base); err != nil {
+		logger.Warnf(c, "删除临时目录失败: %v", err)
+		return
+	}
+	t.base = ""
+	t.used.Store(0)
+}
+
+// reserveTemp 预占临时目录配额，超出时返回 E_TEMP_QUOTA
+func (c *Context) reserveTemp(n int64) error {
+	quota := tempQuota()
+	if quota <= 0 {
+		return nil
+	}
+	used := c.temp.used.Add(n)
+	if used <= quota {
+		return nil
+	}
+	c.temp.used.Add(-n)
+	return Err(c).Code("E_TEMP_QUOTA").Msg("临时目录超出配额").
+		Detail(fmt.Sprintf("当前请求的临时文件已使用 %d 字节，本次写入 %d 字节，配额 %d 字节", used-n, n, quota)).
+		Hint("请删除不需要的临时文件，或调大 RUNNER_TEMP_QUOTA_MB").Build()
+}
+
+func (c *Context) createTempFile(name string) (*TempFile, error) {
+	base, err := c.TempBaseDir()
+	if err != nil {
+		return nil, err
+	}
+	p := filepath.Join(base, filepath.Clean(string(filepath.Separator)+name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, fmt.Errorf("创建临时子目录失败: %w", err)
+	}
+	// 直接用os写入的文件不经过 reserveTemp，在创建TempFile时按目录的实际大小补记，
+	// 已使用的空间只增不减，不会覆盖其他 TempFile 并发预占的配额
+	measured, err := dirSize(base)
+	if err != nil {
+		return nil, fmt.Errorf("统计临时目录大小失败: %w", err)
+	}
+	for {
+		used := c.temp.used.Load()
+		if measured <= used || c.temp.used.CompareAndSwap(used, measured) {
+			break
+		}
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	return &TempFile{File: f, ctx: c}, nil
+}
+
+// TempFile 临时目录下的文件，写入前检查当前请求的临时目录配额
+type TempFile struct {
+	*os.File
+	ctx *Context
+}
+
+func (f *TempFile) Write(p []byte) (int, error) {
+	if err := f.ctx.reserveTemp(int64(len(p))); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *TempFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// ReadFrom 覆盖 os.File 的 ReadFrom，保证 io.Copy 也经过配额检查
+func (f *TempFile) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{f}, r)
+}
+
+// dirSize 目录下所有文件的大小
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// lastModified 目录下最近一次修改的时间
+func lastModified(dir string) (time.Time, error) {
+	var last time.Time
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+		return nil
+	})
+	return last, err
+}
+
+// startTempJanitor 常驻进程启动后台清理任务，返回停止函数
+func (r *Runner) startTempJanitor(ctx context.Context) (stop func()) {
+	maxAge := tempMaxAge()
+	if maxAge <= 0 {
+		return func() {}
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		logger.Warnf(ctx, "获取工作目录失败，不清理临时目录: %v", err)
+		return func() {}
+	}
+	root := filepath.Join(workDir, tempDirName)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(tempJanitorInterval)
+		defer ticker.Stop()
+		for {
+			if n := purgeTemp(ctx, root, maxAge, time.Now()); n > 0 {
+				logger.Infof(ctx, "已清理 %d 个过期的临时目录", n)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// purgeTemp 删除 root/<router>/<traceID> 中超过maxAge没有修改且不在处理中的目录，返回删除的数量
+func purgeTemp(ctx context.Context, root string, maxAge time.Duration, now time.Time) int {
+	routers, err := os.ReadDir(root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf(ctx, "读取临时目录失败: %v", err)
+		}
+		return 0
+	}
+	removed := 0
+	for _, router := range routers {
+		routerDir := filepath.Join(root, router.Name())
+		if !router.IsDir() {
+			if info, err := router.Info(); err == nil && now.Sub(info.ModTime()) > maxAge {
+				_ = os.Remove(routerDir)
+			}
+			continue
+		}
+		traces, err := os.ReadDir(routerDir)
+		if err != nil {
+			continue
+		}
+		for _, t := range traces {
+			dir := filepath.Join(routerDir, t.Name())
+			if _, ok := activeTempDirs.Load(dir); ok {
+				continue
+			}
+			last, err := lastModified(dir)
+			if err != nil || now.Sub(last) <= maxAge {
+				continue
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				logger.Warnf(ctx, "删除过期临时目录失败: %v", err)
+				continue
+			}
+			removed++
+		}
+		// 路由目录本身也过期了才删除，避免和正在创建临时目录的请求冲突
+		if info, err := os.Stat(routerDir); err == nil && now.Sub(info.ModTime()) > maxAge {
+			_ = os.Remove(routerDir)
+		}
+	}
+	return removed
+}
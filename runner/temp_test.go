@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/pkg/constants"
+)
+
+// 临时目录基于工作目录，测试在临时目录下执行
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	wd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	return dir
+}
+
+func TestTempLifecycle(t *testing.T) {
+	dir := chdirTemp(t)
+	r := newTestRunner()
+	router := fmt.Sprintf("/temp/export_%d", time.Now().UnixNano())
+	var dirs []string
+	r.post(router, func(ctx *Context, req *slowReq, resp response.Response) error {
+		d := ctx.FS().GetTempUniqueDir("xlsx")
+		if d == "" {
+			return errors.New("创建临时目录失败")
+		}
+		dirs = append(dirs, d)
+		if req.N == 1 {
+			ctx.FS().KeepTemp()
+		}
+		_, err := ctx.FS().WriteTempFile("xlsx/a.txt", []byte("hello"))
+		return err
+	}, &FormFunctionOptions{})
+
+	for n, traceID := range []string{"t_remove", "t_keep"} {
+		ctx := NewContext(context.WithValue(context.Background(), constants.TraceID, traceID), "POST", router, r)
+		req := &request.RunFunctionReq{Method: "POST", Router: router, Body: map[string]interface{}{"n": n}}
+		if _, err := r.runFunctionV2(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		// 响应发送之前临时文件还在
+		if _, err := os.Stat(filepath.Join(dirs[n], "a.txt")); err != nil {
+			t.Fatalf("发送响应之前不应该删除临时目录: %v", err)
+		}
+		ctx.cleanupTemp()
+	}
+
+	want := filepath.Join(dir, "temp", strings.ReplaceAll(strings.Trim(router, "/"), "/", "."), "t_remove", "xlsx")
+	if dirs[0] != want {
+		t.Fatalf("目录 %s 期望 %s", dirs[0], want)
+	}
+	if _, err := os.Stat(dirs[0]); !os.IsNotExist(err) {
+		t.Errorf("请求结束后应该删除临时目录: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dirs[1], "a.txt")); err != nil {
+		t.Errorf("KeepTemp 之后应该保留临时目录: %v", err)
+	}
+
+	// 保留的目录过期后由后台清理删除
+	root := filepath.Join(dir, "temp")
+	if n := purgeTemp(context.Background(), root, time.Hour, time.Now()); n != 0 {
+		t.Errorf("未过期的目录不应该删除，删除了 %d 个", n)
+	}
+	if n := purgeTemp(context.Background(), root, time.Hour, time.Now().Add(2*time.Hour)); n != 1 {
+		t.Errorf("应该删除1个过期目录，删除了 %d 个", n)
+	}
+	if _, err := os.Stat(filepath.Dir(dirs[1])); !os.IsNotExist(err) {
+		t.Errorf("过期目录应该被删除: %v", err)
+	}
+}
+
+func TestTempQuota(t *testing.T) {
+	chdirTemp(t)
+	t.Setenv("RUNNER_TEMP_QUOTA_MB", "1")
+	ctx := NewContext(context.WithValue(context.Background(), constants.TraceID, "t_quota"), "POST", "/quota", nil)
+	defer ctx.cleanupTemp()
+
+	if _, err := ctx.FS().WriteTempFile("a.bin", make([]byte, 600<<10)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ctx.FS().CreateTempFile("b.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.Write(make([]byte, 600<<10))
+	var appErr *AppError
+	if !errors.As(err, &appErr) || appErr.Code != "E_TEMP_QUOTA" {
+		t.Fatalf("超出配额应该返回 E_TEMP_QUOTA: %v", err)
+	}
+	if _, err := f.Write(make([]byte, 100<<10)); err != nil {
+		t.Errorf("配额内的写入不应该失败: %v", err)
+	}
+	if used, _ := ctx.FS().TempUsage(); used != 700<<10 {
+		t.Errorf("已使用 %d", used)
+	}
+}
+
+// TestTempQuotaMonotonic 创建TempFile时补记直接用os写入的文件，但不能覆盖其他写入已经预占的配额
+func TestTempQuotaMonotonic(t *testing.T) {
+	chdirTemp(t)
+	t.Setenv("RUNNER_TEMP_QUOTA_MB", "1")
+	ctx := NewContext(context.WithValue(context.Background(), constants.TraceID, "t_monotonic"), "POST", "/quota", nil)
+	defer ctx.cleanupTemp()
+
+	// 模拟另一个TempFile已经预占但还没写到磁盘的数据
+	if err := ctx.reserveTemp(400 << 10); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ctx.FS().CreateTempFile("a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if used := ctx.temp.used.Load(); used != 400<<10 {
+		t.Fatalf("创建文件不应该覆盖已经预占的配额: %d", used)
+	}
+
+	base, _ := ctx.TempBaseDir()
+	if err := os.WriteFile(filepath.Join(base, "direct.bin"), make([]byte, 700<<10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err = ctx.FS().CreateTempFile("b.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if used := ctx.temp.used.Load(); used != 700<<10 {
+		t.Fatalf("直接写入的文件应该在创建TempFile时计入: %d", used)
+	}
+	var appErr *AppError
+	if _, err := f.Write(make([]byte, 400<<10)); !errors.As(err, &appErr) || appErr.Code != "E_TEMP_QUOTA" {
+		t.Fatalf("超出配额应该返回 E_TEMP_QUOTA: %v", err)
+	}
+}
+
+// TestTempCleanupAfterDetach 超时后被分离的处理函数结束时才删除临时目录
+func TestTempCleanupAfterDetach(t *testing.T) {
+	chdirTemp(t)
+	r := newTestRunner()
+	router := fmt.Sprintf("/temp/detach_%d", time.Now().UnixNano())
+	opt := &FormFunctionOptions{}
+	opt.Timeout = 20
+	written := make(chan string, 1)
+	release := make(chan struct{})
+	r.post(router, func(ctx *Context, req *slowReq, resp response.Response) error {
+		f, err := ctx.FS().WriteTempFile("a.txt", []byte("hello"))
+		written <- f
+		<-release //不响应取消信号
+		return err
+	}, opt)
+
+	ctx := NewContext(context.WithValue(context.Background(), constants.TraceID, "t_detach"), "POST", router, r)
+	if _, err := r.runFunctionV2(ctx, &request.RunFunctionReq{Method: "POST", Router: router}); err == nil {
+		t.Fatal("应该返回超时错误")
+	}
+	ctx.cleanupTemp()
+	file := <-written
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("分离的处理函数还在运行，不应该删除临时目录: %v", err)
+	}
+	close(release)
+	for r.GetDetachedCount() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("分离的处理函数结束后应该删除临时目录: %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+// Package testkit 把 runner 录制的请求（RUNNER_RECORD_DIR）当作golden文件，在当前代码上回放并对比响应，用来做函数的回归测试
+//
+//	func TestGolden(t *testing.T) {
+//		testkit.Run(t, testkit.Options{Dir: "testdata/recordings"})
+//	}
+//
+// 纯函数（FunctionTypePure）和静态函数逐字段精确对比，其他函数的结果会随数据变化，默认只对比结构（字段和类型）
+// 设置环境变量 TESTKIT_UPDATE=1 时用当前的响应覆盖录制
+package testkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/function-go/runner"
+)
+
+// 默认忽略的元信息，每次执行都会变化
+var defaultIgnoreMeta = []string{"cost", "memory", "cost_memory"}
+
+// Options 回放的配置
+type Options struct {
+	Dir        string                           // 录制目录
+	Exact      bool                             // 所有函数都精确对比
+	IgnoreMeta []string                         // 额外忽略的meta_data字段
+	Filter     func(rec *runner.Recording) bool // 返回false的录制跳过
+}
+
+// Run 回放目录下的每个录制，每个录制是一个子测试
+func Run(t *testing.T, opts Options) {
+	t.Helper()
+	recs, err := runner.ListRecordings(opts.Dir)
+	if err != nil {
+		t.Fatalf("读取录制失败: %v", err)
+	}
+	if len(recs) == 0 {
+		t.Skipf("%s 下没有录制", opts.Dir)
+	}
+	update := os.Getenv("TESTKIT_UPDATE") == "1"
+	for _, rec := range recs {
+		if opts.Filter != nil && !opts.Filter(rec) {
+			continue
+		}
+		rec := rec
+		t.Run(fmt.Sprintf("%s %s/%s", rec.Method, rec.Router, rec.TraceID), func(t *testing.T) {
+			got, err := runner.Replay(context.Background(), rec)
+			if update {
+				rec.Response, rec.Error = got, ""
+				if err != nil {
+					rec.Error = err.Error()
+				}
+				if err := runner.SaveRecording(opts.Dir, rec); err != nil {
+					t.Fatalf("更新录制失败: %v", err)
+				}
+				return
+			}
+			if diffs := Check(rec, got, err, opts); len(diffs) > 0 {
+				for _, d := range diffs {
+					t.Error(d)
+				}
+			}
+		})
+	}
+}
+
+// Check 对比回放结果和录制，返回所有差异
+func Check(rec *runner.Recording, got *response.RunFunctionResp, err error, opts Options) []string {
+	if rec.Error != "" || err != nil {
+		errMsg := ""
+		if err != nil {
+			errMsg = err.Error()
+		}
+		if rec.Error != errMsg {
+			return []string{fmt.Sprintf("错误不一致: 录制 %q 回放 %q", rec.Error, errMsg)}
+		}
+		return nil
+	}
+	exact := opts.Exact || rec.FunctionType == runner.FunctionTypePure || rec.FunctionType == runner.FunctionTypeStatic
+	return Diff(rec.Response, got, exact, append(append([]string{}, defaultIgnoreMeta...), opts.IgnoreMeta...)...)
+}
+
+// Diff 对比两个响应的 code/render_type/data/meta_data，exact为false时data只对比结构
+func Diff(want, got *response.RunFunctionResp, exact bool, ignoreMeta ...string) []string {
+	if want == nil || got == nil {
+		if want != got {
+			return []string{fmt.Sprintf("响应不一致: 录制 %v 回放 %v", want != nil, got != nil)}
+		}
+		return nil
+	}
+	var diffs []string
+	if want.Code != got.Code {
+		diffs = append(diffs, fmt.Sprintf("code: 录制 %d 回放 %d", want.Code, got.Code))
+	}
+	if want.RenderType != got.RenderType {
+		diffs = append(diffs, fmt.Sprintf("render_type: 录制 %q 回放 %q", want.RenderType, got.RenderType))
+	}
+	diffs = append(diffs, diffValue("data", normalize(want.GetData()), normalize(got.GetData()), exact)...)
+
+	ignore := make(map[string]bool, len(ignoreMeta))
+	for _, k := range ignoreMeta {
+		ignore[k] = true
+	}
+	wantMeta, gotMeta := map[string]interface{}{}, map[string]interface{}{}
+	for k, v := range want.MetaData {
+		if !ignore[k] {
+			wantMeta[k] = v
+		}
+	}
+	for k, v := range got.MetaData {
+		if !ignore[k] {
+			gotMeta[k] = v
+		}
+	}
+	return append(diffs, diffValue("meta_data", normalize(wantMeta), normalize(gotMeta), exact)...)
+}
+
+// normalize 转成JSON再解析回来，录制文件里读出来的值和回放得到的结构体才可以直接对比
+func normalize(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	var out interface{}
+	_ = json.Unmarshal(data, &out)
+	return out
+}
+
+func diffValue(path string, want, got interface{}, exact bool) []string {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: 录制是对象，回放是 %s", path, kind(got))}
+		}
+		keys := make(map[string]bool)
+		for k := range w {
+			keys[k] = true
+		}
+		for k := range g {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		var diffs []string
+		for _, k := range sorted {
+			wv, wok := w[k]
+			gv, gok := g[k]
+			switch {
+			case !wok:
+				diffs = append(diffs, fmt.Sprintf("%s.%s: 回放多出的字段", path, k))
+			case !gok:
+				diffs = append(diffs, fmt.Sprintf("%s.%s: 回放缺少的字段", path, k))
+			default:
+				diffs = append(diffs, diffValue(path+"."+k, wv, gv, exact)...)
+			}
+		}
+		return diffs
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: 录制是数组，回放是 %s", path, kind(got))}
+		}
+		if exact && len(w) != len(g) {
+			return []string{fmt.Sprintf("%s: 长度不一致，录制 %d 回放 %d", path, len(w), len(g))}
+		}
+		// 非精确对比时数据条数可能变化，只对比第一个元素的结构
+		if !exact {
+			if len(w) == 0 || len(g) == 0 {
+				return nil
+			}
+			return diffValue(path+"[0]", w[0], g[0], exact)
+		}
+		var diffs []string
+		for i := range w {
+			diffs = append(diffs, diffValue(fmt.Sprintf("%s[%d]", path, i), w[i], g[i], exact)...)
+		}
+		return diffs
+	}
+	if !exact {
+		// 结构对比，null表示值缺失，不认为是类型变化
+		if want != nil && got != nil && kind(want) != kind(got) {
+			return []string{fmt.Sprintf("%s: 类型不一致，录制 %s 回放 %s", path, kind(want), kind(got))}
+		}
+		return nil
+	}
+	if !reflect.DeepEqual(want, got) {
+		return []string{fmt.Sprintf("%s: 录制 %v 回放 %v", path, want, got)}
+	}
+	return nil
+}
+
+func kind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "对象"
+	case []interface{}:
+		return "数组"
+	case string:
+		return "字符串"
+	case float64:
+		return "数字"
+	case bool:
+		return "布尔"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,67 @@
+package testkit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/request"
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+	"github.com/yunhanshu-net/function-go/runner"
+)
+
+type doubleReq struct {
+	N int `json:"n"`
+}
+
+func TestRunGolden(t *testing.T) {
+	// runner初始化时会在当前目录创建配置目录
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	runner.Post("/testkit/double", func(ctx *runner.Context, req *doubleReq, resp response.Response) error {
+		return resp.Form(map[string]int{"n": req.N * 2}).Build()
+	}, &runner.FormFunctionOptions{BaseConfig: runner.BaseConfig{FunctionType: runner.FunctionTypePure}})
+	runner.Post("/testkit/now", func(ctx *runner.Context, req *doubleReq, resp response.Response) error {
+		return resp.Form(map[string]interface{}{"now": time.Now().UnixNano(), "tags": []string{"a"}}).Build()
+	}, &runner.FormFunctionOptions{})
+
+	dir := t.TempDir()
+	recs := []*runner.Recording{
+		{
+			TraceID: "t1", Method: "POST", Router: "/testkit/double", FunctionType: runner.FunctionTypePure,
+			Request:  &request.RunFunctionReq{Method: "POST", Router: "/testkit/double", Body: map[string]interface{}{"n": 21}},
+			Response: &response.RunFunctionResp{RenderType: response.RenderTypeForm, Msg: "ok", Data: map[string]int{"n": 42}, MetaData: map[string]interface{}{"cost": "1ms"}},
+		},
+		{
+			TraceID: "t2", Method: "POST", Router: "/testkit/now", FunctionType: runner.FunctionTypeDynamic,
+			Request:  &request.RunFunctionReq{Method: "POST", Router: "/testkit/now", Body: map[string]interface{}{}},
+			Response: &response.RunFunctionResp{RenderType: response.RenderTypeForm, Msg: "ok", Data: map[string]interface{}{"now": 1, "tags": []string{"x", "y"}}},
+		},
+	}
+	for _, rec := range recs {
+		if err := runner.SaveRecording(dir, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Run(t, Options{Dir: dir})
+}
+
+func TestDiff(t *testing.T) {
+	want := &response.RunFunctionResp{Data: map[string]interface{}{"n": 1, "list": []int{1, 2}}, MetaData: map[string]interface{}{"cost": "1ms"}}
+	got := &response.RunFunctionResp{Data: map[string]interface{}{"n": 2, "list": []int{1}, "extra": true}, MetaData: map[string]interface{}{"cost": "9ms"}}
+
+	if diffs := Diff(want, got, true, defaultIgnoreMeta...); len(diffs) != 3 {
+		t.Fatalf("精确对比应该有3处差异: %v", diffs)
+	}
+	if diffs := Diff(want, got, false, defaultIgnoreMeta...); len(diffs) != 1 {
+		t.Fatalf("结构对比只有多出的字段算差异: %v", diffs)
+	}
+	got.Data = map[string]interface{}{"n": "1", "list": []int{}}
+	if diffs := Diff(want, got, false, defaultIgnoreMeta...); len(diffs) != 1 {
+		t.Fatalf("类型变化应该算差异: %v", diffs)
+	}
+}
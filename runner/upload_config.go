@@ -1,23 +1,26 @@
 package runner
 
 import (
+	"context"
 	"fmt"
 	"github.com/yunhanshu-net/function-go/env"
 	"os"
+	"strconv"
 
+	"github.com/yunhanshu-net/pkg/logger"
 	"github.com/yunhanshu-net/pkg/trace"
 )
 
-// getUploadConfig 获取上传配置
+// getUploadConfig 获取上传配置，全部从环境变量读取，默认使用本地存储（见 upload_storage.go），不会访问CDN
+// connect模式下没有配置时会打印警告，见 checkConnectUploadConfig
 func getUploadConfig() trace.UploadConfig {
-	// 优先从环境变量读取
-	provider := getEnvOrDefault("UPLOAD_PROVIDER", "qiniu")
+	provider := getEnvOrDefault("UPLOAD_PROVIDER", UploadProviderLocal)
 	uploadDomain := getEnvOrDefault("UPLOAD_DOMAIN", "")
-	downloadDomain := getEnvOrDefault("DOWNLOAD_DOMAIN", "http://cdn.geeleo.com")
+	downloadDomain := getEnvOrDefault("DOWNLOAD_DOMAIN", "")
 	uploadToken := getEnvOrDefault("UPLOAD_TOKEN", "")
-	bucket := getEnvOrDefault("UPLOAD_BUCKET", "geeleo")
-	accessKey := getEnvOrDefault("UPLOAD_ACCESS_KEY", "ehF_E4x_EyO_wSN_nwqExyhXPe5hGl5Xjo89_cZ6")
-	secretKey := getEnvOrDefault("UPLOAD_SECRET_KEY", "FjfIpqUevEcVx9bQxdgiuX9Di-CUOrKFkR88CZAj")
+	bucket := getEnvOrDefault("UPLOAD_BUCKET", "")
+	accessKey := getEnvOrDefault("UPLOAD_ACCESS_KEY", "")
+	secretKey := getEnvOrDefault("UPLOAD_SECRET_KEY", "")
 
 	config := trace.UploadConfig{
 		Provider:       provider,
@@ -70,6 +73,19 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvIntOrDefault 获取整数类型的环境变量，不存在或者解析失败时返回默认值
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return v
+}
+
 // createFunctionMsg 创建FunctionMsg
 func createFunctionMsg(traceId string, method string, router string) *trace.FunctionMsg {
 	return &trace.FunctionMsg{
@@ -83,10 +99,33 @@ func createFunctionMsg(traceId string, method string, router string) *trace.Func
 	}
 }
 
+// checkConnectUploadConfig 常驻(connect)模式下文件要给平台和其他实例访问，没有配置 UPLOAD_PROVIDER 时
+// 回退到本地存储并打印警告，选择了非local的提供商但配置不完整或者没有可用的存储时启动失败
+func checkConnectUploadConfig(ctx context.Context) error {
+	if os.Getenv("UPLOAD_PROVIDER") == "" {
+		logger.Warnf(ctx, "connect模式没有配置UPLOAD_PROVIDER，文件将保存到本机目录，平台和其他实例可能无法访问（可选 %s/%s/%s/%s）",
+			UploadProviderLocal, UploadProviderS3, UploadProviderQiniu, UploadProviderHTTP)
+		return nil
+	}
+	_, err := getStorageProvider()
+	return err
+}
+
 // validateUploadConfig 验证上传配置
 func validateUploadConfig(config trace.UploadConfig) error {
 	switch config.Provider {
-	case "qiniu":
+	case UploadProviderLocal:
+	case UploadProviderS3, "aws":
+		if getEnvOrDefault("UPLOAD_ENDPOINT", "") == "" {
+			return fmt.Errorf("S3上传需要配置UPLOAD_ENDPOINT")
+		}
+		if config.Bucket == "" {
+			return fmt.Errorf("S3上传需要配置UPLOAD_BUCKET")
+		}
+		if config.AccessKey == "" || config.SecretKey == "" {
+			return fmt.Errorf("S3上传需要配置UPLOAD_ACCESS_KEY/UPLOAD_SECRET_KEY")
+		}
+	case UploadProviderQiniu:
 		if config.Bucket == "" {
 			return fmt.Errorf("七牛云上传需要配置UPLOAD_BUCKET")
 		}
@@ -95,14 +134,12 @@ func validateUploadConfig(config trace.UploadConfig) error {
 				return fmt.Errorf("七牛云上传需要配置UPLOAD_ACCESS_KEY/UPLOAD_SECRET_KEY或UPLOAD_TOKEN")
 			}
 		}
-	case "http":
+	case UploadProviderHTTP:
 		if config.UploadDomain == "" {
 			return fmt.Errorf("HTTP上传需要配置UPLOAD_DOMAIN")
 		}
 	case "aliyun":
 		return fmt.Errorf("阿里云OSS上传器暂未实现")
-	case "aws":
-		return fmt.Errorf("AWS S3上传器暂未实现")
 	default:
 		return fmt.Errorf("不支持的上传提供商: %s", config.Provider)
 	}
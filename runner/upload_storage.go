@@ -0,0 +1,294 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 上传提供商，环境变量 UPLOAD_PROVIDER，默认local
+const (
+	UploadProviderLocal = "local" // 保存到本地目录，serve/dev 模式通过 /_files/ 访问
+	UploadProviderS3    = "s3"    // S3兼容的对象存储（AWS S3、MinIO等）
+	UploadProviderQiniu = "qiniu" // 七牛云，没有内置实现，需要通过 SetStorageProvider 注册
+	UploadProviderHTTP  = "http"  // 上传到 UPLOAD_DOMAIN，没有内置实现，需要通过 SetStorageProvider 注册
+)
+
+// StorageProvider 文件存储，Context.CreateFilesFromData/CreateFilesFromPath 和 ContextFS.Upload 通过它保存文件
+// key 是相对路径，例如 <user>/<runner>/<router>/<trace_id>/a.xlsx，Put返回可以下载的地址
+type StorageProvider interface {
+	Name() string
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalStorage 本地目录存储，本地开发和测试使用，不会访问任何CDN
+//
+//	UPLOAD_LOCAL_DIR      保存目录，默认 ./uploads
+//	UPLOAD_LOCAL_BASE_URL 下载地址前缀，默认 /_files（serve/dev 模式下可以直接访问）
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalStorage(dir string, baseURL string) *LocalStorage {
+	return &LocalStorage{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (s *LocalStorage) Name() string { return UploadProviderLocal }
+
+// path key不能跳出保存目录
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("文件key不能为空")
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", fmt.Errorf("创建目录失败: %w", err)
+	}
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		return "", fmt.Errorf("保存文件失败: %w", err)
+	}
+	return s.BaseURL + path.Clean("/"+key), nil
+}
+
+func (s *LocalStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// S3Storage S3兼容的对象存储，使用path-style地址和SigV4签名，可以直接对接MinIO
+//
+//	UPLOAD_ENDPOINT   服务地址，例如 http://127.0.0.1:9000
+//	UPLOAD_REGION     区域，默认 us-east-1
+//	UPLOAD_BUCKET     桶
+//	UPLOAD_ACCESS_KEY/UPLOAD_SECRET_KEY 凭证
+//	DOWNLOAD_DOMAIN   下载地址前缀，默认 <endpoint>/<bucket>
+//	UPLOAD_TIMEOUT    单次请求的超时时间（秒），默认60，请求的ctx有更早的截止时间时以ctx为准
+type S3Storage struct {
+	Endpoint       string
+	Region         string
+	Bucket         string
+	AccessKey      string
+	SecretKey      string
+	DownloadDomain string
+	Client         *http.Client
+}
+
+func (s *S3Storage) Name() string { return UploadProviderS3 }
+
+func (s *S3Storage) objectURL(key string) string {
+	return strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + escapePath(strings.TrimPrefix(key, "/"))
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if _, err := s.do(req, data); err != nil {
+		return "", err
+	}
+	if s.DownloadDomain != "" {
+		return strings.TrimSuffix(s.DownloadDomain, "/") + "/" + escapePath(strings.TrimPrefix(key, "/")), nil
+	}
+	return s.objectURL(key), nil
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(req, nil)
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(req, nil)
+	return err
+}
+
+func (s *S3Storage) do(req *http.Request, body []byte) ([]byte, error) {
+	timeout := time.Duration(getEnvIntOrDefault("UPLOAD_TIMEOUT", 60)) * time.Second
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+	signS3Request(req, body, s.AccessKey, s.SecretKey, s.Region, time.Now())
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: timeout}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求对象存储失败: %w", err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("对象存储返回 %d: %s", res.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// signS3Request 按AWS SigV4给请求签名，签名覆盖 host/x-amz-content-sha256/x-amz-date
+func signS3Request(req *http.Request, body []byte, accessKey string, secretKey string, region string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	signature := hex.EncodeToString(hmacSHA256(s3SigningKey(secretKey, date, region), s3StringToSign(req, payloadHash, amzDate, scope)))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, s3SignedHeaders, signature))
+}
+
+const s3SignedHeaders = "host;x-amz-content-sha256;x-amz-date"
+
+func s3StringToSign(req *http.Request, payloadHash string, amzDate string, scope string) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, url.QueryEscape(k)+"="+url.QueryEscape(query.Get(k)))
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(pairs, "&"),
+		"host:" + host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		s3SignedHeaders,
+		payloadHash,
+	}, "\n")
+	return "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+}
+
+func s3SigningKey(secretKey string, date string, region string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secretKey), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// escapePath 按SigV4的规则逐段转义路径，只保留 A-Za-z0-9-_.~ 和 /
+func escapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+var (
+	storageLock     sync.Mutex
+	storageProvider StorageProvider
+)
+
+// getStorageProvider 根据环境变量选择存储，通过 SetStorageProvider 注册过的存储优先，
+// qiniu/http 没有内置实现，没有注册存储时返回错误
+func getStorageProvider() (StorageProvider, error) {
+	storageLock.Lock()
+	defer storageLock.Unlock()
+	if storageProvider != nil {
+		return storageProvider, nil
+	}
+	config := getUploadConfig()
+	if err := validateUploadConfig(config); err != nil {
+		return nil, err
+	}
+	switch config.Provider {
+	case UploadProviderLocal:
+		storageProvider = NewLocalStorage(getEnvOrDefault("UPLOAD_LOCAL_DIR", "./uploads"), getEnvOrDefault("UPLOAD_LOCAL_BASE_URL", "/_files"))
+	case UploadProviderS3, "aws":
+		storageProvider = &S3Storage{
+			Endpoint:       getEnvOrDefault("UPLOAD_ENDPOINT", ""),
+			Region:         getEnvOrDefault("UPLOAD_REGION", "us-east-1"),
+			Bucket:         config.Bucket,
+			AccessKey:      config.AccessKey,
+			SecretKey:      config.SecretKey,
+			DownloadDomain: config.DownloadDomain,
+		}
+	default:
+		return nil, fmt.Errorf("UPLOAD_PROVIDER=%s 没有内置的文件存储，请通过 SetStorageProvider 注册", config.Provider)
+	}
+	return storageProvider, nil
+}
+
+// SetStorageProvider 替换文件存储，例如使用自己实现的存储或者在测试中使用内存存储
+func SetStorageProvider(provider StorageProvider) {
+	storageLock.Lock()
+	defer storageLock.Unlock()
+	storageProvider = provider
+}
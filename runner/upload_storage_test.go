@@ -0,0 +1,182 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/pkg/constants"
+	"github.com/yunhanshu-net/pkg/trace"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	SetStorageProvider(NewLocalStorage(dir, "/_files/"))
+	defer SetStorageProvider(nil)
+
+	traceID := "trace_upload"
+	c := context.WithValue(context.Background(), constants.TraceID, traceID)
+	c = context.WithValue(c, trace.FunctionMsgKey, createFunctionMsg(traceID, "POST", "/export"))
+	ctx := NewContext(c, "POST", "/export", nil)
+
+	f, err := ctx.CreateFilesFromData("../report.txt", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := f.GetFiles()[0].URL
+	key := strings.TrimPrefix(path.Join(ctx.GetUploadPath(), traceID, "report.txt"), "/")
+	if url != "/_files/"+key {
+		t.Fatalf("url = %s", url)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(key)))
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("文件内容 %q %v", data, err)
+	}
+
+	// serve 模式下可以直接下载
+	srv := httptest.NewServer(newTestRunner().httpHandler())
+	defer srv.Close()
+	res, err := http.Get(srv.URL + url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Fatalf("下载 %d %q", res.StatusCode, body)
+	}
+
+	if _, err := (&LocalStorage{Dir: dir}).Get(context.Background(), "/"); err == nil {
+		t.Error("空key应该报错")
+	}
+}
+
+// fakeS3 内存里的S3，校验SigV4签名
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	secret  string
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	auth := req.Header.Get("Authorization")
+	check := req.Clone(context.Background())
+	amzDate, _ := time.Parse("20060102T150405Z", req.Header.Get("X-Amz-Date"))
+	signS3Request(check, body, "minio", s.secret, "us-east-1", amzDate)
+	if auth == "" || auth != check.Header.Get("Authorization") {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch req.Method {
+	case http.MethodPut:
+		s.objects[req.URL.Path] = body
+	case http.MethodGet:
+		data, ok := s.objects[req.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(s.objects, req.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}, secret: "minio123"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	s := &S3Storage{Endpoint: srv.URL, Region: "us-east-1", Bucket: "files", AccessKey: "minio", SecretKey: "minio123"}
+	ctx := context.Background()
+	url, err := s.Put(ctx, "u/r/导出 a+b=1.txt", []byte("data"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != srv.URL+"/files/u/r/%E5%AF%BC%E5%87%BA%20a%2Bb%3D1.txt" {
+		t.Fatalf("url = %s", url)
+	}
+	data, err := s.Get(ctx, "u/r/导出 a+b=1.txt")
+	if err != nil || string(data) != "data" {
+		t.Fatalf("Get %q %v", data, err)
+	}
+	if err := s.Delete(ctx, "u/r/导出 a+b=1.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "u/r/导出 a+b=1.txt"); err == nil {
+		t.Error("删除后不应该还能读取")
+	}
+
+	s.SecretKey = "wrong"
+	if _, err := s.Put(ctx, "a.txt", []byte("x"), ""); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("错误的密钥应该返回403: %v", err)
+	}
+}
+
+func TestValidateUploadConfig(t *testing.T) {
+	t.Setenv("UPLOAD_PROVIDER", "")
+	t.Setenv("UPLOAD_ACCESS_KEY", "")
+	t.Setenv("UPLOAD_SECRET_KEY", "")
+	config := getUploadConfig()
+	if config.Provider != UploadProviderLocal || config.AccessKey != "" || config.SecretKey != "" {
+		t.Fatalf("默认配置 %+v", config)
+	}
+	if err := validateUploadConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkConnectUploadConfig(context.Background()); err != nil {
+		t.Errorf("connect模式没有配置UPLOAD_PROVIDER时回退到local: %v", err)
+	}
+	t.Setenv("UPLOAD_PROVIDER", "s3")
+	if err := validateUploadConfig(getUploadConfig()); err == nil {
+		t.Error("s3缺少配置应该报错")
+	}
+	if err := checkConnectUploadConfig(context.Background()); err == nil {
+		t.Error("connect模式s3缺少配置应该报错")
+	}
+	t.Setenv("UPLOAD_PROVIDER", UploadProviderQiniu)
+	t.Setenv("UPLOAD_BUCKET", "files")
+	t.Setenv("UPLOAD_TOKEN", "token")
+	if err := checkConnectUploadConfig(context.Background()); err == nil || !strings.Contains(err.Error(), "SetStorageProvider") {
+		t.Errorf("qiniu没有注册存储时应该报错: %v", err)
+	}
+	t.Setenv("UPLOAD_PROVIDER", UploadProviderLocal)
+	defer SetStorageProvider(nil)
+	if err := checkConnectUploadConfig(context.Background()); err != nil {
+		t.Errorf("显式配置local时允许启动: %v", err)
+	}
+}
+
+func TestS3StorageTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	s := &S3Storage{Endpoint: srv.URL, Region: "us-east-1", Bucket: "files", AccessKey: "minio", SecretKey: "minio123"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := s.Put(ctx, "a.txt", []byte("x"), ""); err == nil {
+		t.Fatal("请求超时应该报错")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("请求应该在ctx超时后结束: %v", time.Since(start))
+	}
+}
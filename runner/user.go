@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/yunhanshu-net/pkg/logger"
+)
+
+// UserInfo 发起请求的用户，从NATS请求头 constants.RequestUserInfo（HTTP模式为 X-Request-User）解析
+//
+//	{"id":"10086","username":"beiluo","roles":["admin"],"tenant":"geeleo","is_logged_in":true}
+//
+// 兼容旧的平台只传用户名的情况，请求头不是JSON时当作已登录的用户名，请求头为空表示未登录
+type UserInfo struct {
+	ID         string   `json:"id"`
+	Username   string   `json:"username"` //用户名
+	Roles      []string `json:"roles"`
+	Tenant     string   `json:"tenant"`
+	IsLoggedIn bool     `json:"is_logged_in"` //是否已经登陆？
+}
+
+// HasRole 是否有指定角色
+func (u *UserInfo) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAnyRole 是否有其中任意一个角色
+func (u *UserInfo) HasAnyRole(roles ...string) bool {
+	for _, role := range roles {
+		if u.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUserInfo 解析请求头里的用户信息，没有 is_logged_in 字段时有id或用户名就认为已登录
+func parseUserInfo(raw string) (*UserInfo, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return &UserInfo{}, nil
+	}
+	if !strings.HasPrefix(raw, "{") {
+		return &UserInfo{Username: raw, IsLoggedIn: true}, nil
+	}
+	var payload struct {
+		UserInfo
+		Name       string `json:"name"` // username 的别名
+		IsLoggedIn *bool  `json:"is_logged_in"`
+	}
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		return &UserInfo{}, err
+	}
+	user := payload.UserInfo
+	if user.Username == "" {
+		user.Username = payload.Name
+	}
+	if payload.IsLoggedIn != nil {
+		user.IsLoggedIn = *payload.IsLoggedIn
+	} else {
+		user.IsLoggedIn = user.ID != "" || user.Username != ""
+	}
+	return &user, nil
+}
+
+// UserInfo 发起请求的用户，解析失败时按未登录处理，运行器所属的用户见 User()
+func (c *Context) UserInfo() *UserInfo {
+	c.userOnce.Do(func() {
+		var raw string
+		if msg := c.FunctionMsg; msg != nil {
+			raw = msg.RequestUser
+		} else if msg := c.GetFunctionMsg(); msg != nil {
+			raw = msg.RequestUser
+		}
+		user, err := parseUserInfo(raw)
+		if err != nil {
+			logger.Warnf(c, "解析请求用户信息失败: %v", err)
+		}
+		c.userInfo = user
+	})
+	return c.userInfo
+}
+
+// mustLogin 全局开启 RUNNER_MUST_LOGIN=true 时除公共api（IsPublicApi）外都需要登录
+func mustLogin() bool {
+	return getEnvOrDefault("RUNNER_MUST_LOGIN", "false") == "true"
+}
@@ -48,6 +48,9 @@ type OnTableDeleteRows func(ctx *Context, req *usercall.OnTableDeleteRowsReq) (*
 // OnTableUpdateRows 当返回前端的数据是table类型时候，前端会把数据渲染成表格，这时候表格数据会有更新的行为，实现这个函数用来更新数据
 type OnTableUpdateRows func(ctx *Context, req *usercall.OnTableUpdateRowsReq) (*usercall.OnTableUpdateRowsResp, error)
 
+// OnTableRestoreRows 恢复被软删除的表格数据，AutoCrud的模型带 gorm.DeletedAt 时不实现也会有默认的恢复逻辑
+type OnTableRestoreRows func(ctx *Context, req *usercall.OnTableRestoreRowsReq) (*usercall.OnTableRestoreRowsResp, error)
+
 // OnTableAddRows 当返回前端的数据是table类型时候，前端会把数据渲染成表格，这时候表格数据会有新增的行为，实现这个函数用来新增数据
 type OnTableAddRows func(ctx *Context, req *usercall.OnTableAddRowsReq) (*usercall.OnTableAddRowsResp, error)
 
@@ -57,6 +60,18 @@ type OnTableSearch func(ctx *Context, req *usercall.OnTableSearchReq) (*usercall
 // OnDryRun DryRun 回调函数，用于预览危险操作
 type OnDryRun func(ctx *Context, req *usercall.OnDryRunReq) (*usercall.OnDryRunResp, error)
 
+// platformCallbackTypes 由平台在部署、升级和下线时发起的生命周期回调，这些回调没有终端用户
+var platformCallbackTypes = map[string]bool{
+	consts.CallbackTypeOnCreateTables:    true,
+	consts.UserCallTypeOnApiCreated:      true,
+	consts.CallbackTypeOnApiUpdated:      true,
+	consts.CallbackTypeBeforeApiDelete:   true,
+	consts.CallbackTypeAfterApiDeleted:   true,
+	consts.CallbackTypeBeforeRunnerClose: true,
+	consts.CallbackTypeAfterRunnerClose:  true,
+	consts.CallbackTypeOnVersionChange:   true,
+}
+
 func (r *Runner) _callback(ctx *Context, req *usercall.Request, resp response.Response) (err error) {
 	var res usercall.Response
 
@@ -70,6 +85,12 @@ func (r *Runner) _callback(ctx *Context, req *usercall.Request, resp response.Re
 		logger.Infof(ctx, "回调处理失败 [类型:%s]: %v", req.Type, err)
 		return err
 	}
+	// 用户触发的回调和函数本身使用相同的登录和权限要求，平台的生命周期回调不带用户信息，不做校验
+	if !platformCallbackTypes[req.Type] {
+		if err := authorize(ctx, worker); err != nil {
+			return err
+		}
+	}
 
 	//todo
 	// 检查是否是 FunctionOptions 类型
@@ -132,7 +153,14 @@ func (r *Runner) _callback(ctx *Context, req *usercall.Request, resp response.Re
 	// API 生命周期回调
 	case consts.UserCallTypeOnApiCreated:
 		var reqData usercall.OnApiCreatedReq
+		// 先执行注册的迁移，保证回调里用到的表结构是最新的
+		if err := autoRunMigrations(ctx); err != nil {
+			return err
+		}
 		callbackOnApiCreated, yes := callbacks[consts.UserCallTypeOnApiCreated]
+		if !yes && hasMigrations() {
+			break
+		}
 		if !yes {
 			err = fmt.Errorf("OnApiCreated handler not configured %s不存在", req.Type)
 			logger.Infof(ctx, "回调处理失败 [类型:%s]: %v", req.Type, err)
@@ -294,7 +322,13 @@ func (r *Runner) _callback(ctx *Context, req *usercall.Request, resp response.Re
 	// 版本控制回调
 	case consts.CallbackTypeOnVersionChange:
 		var reqData usercall.OnVersionChangeReq
+		if err := autoRunMigrations(ctx); err != nil {
+			return err
+		}
 		callbackOnVersionChange, yes := callbacks[consts.CallbackTypeOnVersionChange]
+		if !yes && hasMigrations() {
+			break
+		}
 		if !yes {
 			err = fmt.Errorf("OnVersionChange handler not configured %s不存在", req.Type)
 			logger.Infof(ctx, "回调处理失败 [类型:%s]: %v", req.Type, err)
@@ -392,20 +426,25 @@ func (r *Runner) _callback(ctx *Context, req *usercall.Request, resp response.Re
 		if !ok {
 			return fmt.Errorf("onTableDeleteRowsReq 类型不匹配 failed")
 		}
-		var respData interface{}
+		respData := &usercall.OnTableDeleteRowsResp{}
 		if tb.OnTableDeleteRows != nil {
 			rows, err := tb.OnTableDeleteRows(ctx, &reqData)
 			if err != nil {
-				logger.Errorf(ctx, "回调处理失败 [类型:%s]: OnTableDeleteRows 解码失败 %v", req.Type, err)
+				logger.Errorf(ctx, "回调处理失败 [类型:%s]: OnTableDeleteRows 处理失败 %v", req.Type, err)
+				return err
+			}
+			if rows != nil {
+				respData = rows
+			}
+		} else if tb.AutoCrudTable != nil {
+			rows, err := tb.defaultDeleteRows(ctx, &reqData)
+			if err != nil {
+				logger.Errorf(ctx, "回调处理失败 [类型:%s]: defaultDeleteRows 执行失败 %v", req.Type, err)
+				return err
 			}
 			respData = rows
 		} else {
-			if tb.AutoCrudTable != nil {
-				err = tb.defaultDeleteRows(ctx, &reqData)
-				if err != nil {
-					logger.Errorf(ctx, "回调处理失败 [类型:%s]: defaultDeleteRows 解码失败 %v", req.Type, err)
-				}
-			}
+			return fmt.Errorf("OnTableDeleteRows handler not configured")
 		}
 
 		res.Response = respData
@@ -413,7 +452,61 @@ func (r *Runner) _callback(ctx *Context, req *usercall.Request, resp response.Re
 		// 记录响应参数
 		respDataJSON, _ := json.Marshal(respData)
 		logger.Infof(ctx, "回调处理成功 [类型:%s] 响应: %s", req.Type, respDataJSON)
-		return resp.Form(&usercall.OnTableDeleteRowsResp{}).Build()
+		return resp.Form(respData).Build()
+	case CallbackTypeOnTableRestoreRows:
+		var reqData usercall.OnTableRestoreRowsReq
+		if err = req.DecodeData(&reqData); err != nil {
+			logger.Infof(ctx, "回调处理失败 [类型:%s]: 解码失败 %v", req.Type, err)
+			return fmt.Errorf("OnTableRestoreRowsReq decode failed: %w", err)
+		}
+		tb, ok := worker.Option.(*TableFunctionOptions)
+		if !ok {
+			return fmt.Errorf("onTableRestoreRowsReq 类型不匹配 failed")
+		}
+		var respData *usercall.OnTableRestoreRowsResp
+		if tb.OnTableRestoreRows != nil {
+			respData, err = tb.OnTableRestoreRows(ctx, &reqData)
+		} else if tb.AutoCrudTable != nil {
+			respData, err = autoCrudRestoreRows(ctx, tb.AutoCrudTable, &reqData, tb.EnableHistory)
+		} else {
+			return fmt.Errorf("OnTableRestoreRows handler not configured")
+		}
+		if err != nil {
+			logger.Errorf(ctx, "回调处理失败 [类型:%s]: 恢复数据失败 %v", req.Type, err)
+			return err
+		}
+		if respData == nil {
+			respData = &usercall.OnTableRestoreRowsResp{}
+		}
+		res.Response = respData
+		logger.Infof(ctx, "回调处理成功 [类型:%s] 恢复行数: %d", req.Type, respData.Restored)
+		return resp.Form(respData).Build()
+	case CallbackTypeOnTableRowHistory, CallbackTypeOnTableRowRollback:
+		tb, ok := worker.Option.(*TableFunctionOptions)
+		if !ok || tb.AutoCrudTable == nil || !tb.EnableHistory {
+			return fmt.Errorf("%s 需要AutoCrudTable并开启EnableHistory", req.Type)
+		}
+		var respData interface{}
+		if req.Type == CallbackTypeOnTableRowHistory {
+			var reqData RowHistoryReq
+			if err = req.DecodeData(&reqData); err != nil {
+				return fmt.Errorf("RowHistoryReq decode failed: %w", err)
+			}
+			respData, err = autoCrudRowHistory(ctx, tb.AutoCrudTable, &reqData)
+		} else {
+			var reqData RowRollbackReq
+			if err = req.DecodeData(&reqData); err != nil {
+				return fmt.Errorf("RowRollbackReq decode failed: %w", err)
+			}
+			respData, err = autoCrudRowRollback(ctx, tb.AutoCrudTable, &reqData)
+		}
+		if err != nil {
+			logger.Errorf(ctx, "回调处理失败 [类型:%s]: %v", req.Type, err)
+			return err
+		}
+		res.Response = respData
+		logger.Infof(ctx, "回调处理成功 [类型:%s]", req.Type)
+		return resp.Form(respData).Build()
 	case consts.CallbackTypeOnTableUpdateRows:
 		var reqData usercall.OnTableUpdateRowsReq
 
@@ -429,14 +522,16 @@ func (r *Runner) _callback(ctx *Context, req *usercall.Request, resp response.Re
 		if tb.OnTableUpdateRows != nil {
 			rsp, err := tb.OnTableUpdateRows(ctx, &reqData)
 			if err != nil {
-				logger.Errorf(ctx, "回调处理失败 [类型:%s]: OnTableUpdateRows 解码失败 %v", req.Type, err)
+				logger.Errorf(ctx, "回调处理失败 [类型:%s]: OnTableUpdateRows 处理失败 %v", req.Type, err)
+				return err
 			}
 			respData = rsp
 		} else {
 			if tb.AutoCrudTable != nil {
 				err = tb.defaultUpdateRows(ctx, &reqData)
 				if err != nil {
-					logger.Errorf(ctx, "回调处理失败 [类型:%s]: defaultUpdateRows 解码失败 %v", req.Type, err)
+					logger.Errorf(ctx, "回调处理失败 [类型:%s]: defaultUpdateRows 执行失败 %v", req.Type, err)
+					return err
 				}
 			}
 		}
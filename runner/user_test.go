@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yunhanshu-net/function-go/pkg/dto/response"
+)
+
+func TestParseUserInfo(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want UserInfo
+	}{
+		{"", UserInfo{}},
+		{"beiluo", UserInfo{Username: "beiluo", IsLoggedIn: true}},
+		{`{"id":"1","name":"beiluo","roles":["admin"],"tenant":"t1"}`,
+			UserInfo{ID: "1", Username: "beiluo", Roles: []string{"admin"}, Tenant: "t1", IsLoggedIn: true}},
+		{`{"id":"1","username":"beiluo","is_logged_in":false}`, UserInfo{ID: "1", Username: "beiluo"}},
+		{`{}`, UserInfo{}},
+	}
+	for _, c := range cases {
+		got, err := parseUserInfo(c.raw)
+		if err != nil {
+			t.Fatalf("%q: %v", c.raw, err)
+		}
+		if fmt.Sprintf("%+v", *got) != fmt.Sprintf("%+v", c.want) {
+			t.Errorf("%q: %+v 期望 %+v", c.raw, *got, c.want)
+		}
+	}
+	if _, err := parseUserInfo(`{"id":`); err == nil {
+		t.Error("非法JSON应该报错")
+	}
+	u, _ := parseUserInfo(`{"id":"1","roles":["editor","admin"]}`)
+	if !u.HasRole("admin") || u.HasRole("root") || !u.HasAnyRole("root", "editor") {
+		t.Errorf("角色判断错误: %+v", u)
+	}
+}
+
+func TestMustLogin(t *testing.T) {
+	r := newTestRunner()
+	prefix := fmt.Sprintf("/login_%d", time.Now().UnixNano())
+	handler := func(ctx *Context, req *serveReq, resp response.Response) error {
+		u := ctx.UserInfo()
+		return resp.Form(map[string]interface{}{"id": u.ID, "admin": u.HasRole("admin")}).Build()
+	}
+	private := &FormFunctionOptions{}
+	private.MustLogin = true
+	public := &FormFunctionOptions{}
+	public.IsPublicApi = true
+	r.get(prefix+"/private", handler, private)
+	r.get(prefix+"/public", handler, public)
+	r.get(prefix+"/default", handler, &FormFunctionOptions{})
+
+	t.Setenv("RUNNER_SERVE_TOKEN", "test-token")
+	srv := httptest.NewServer(r.httpHandler())
+	defer srv.Close()
+	call := func(path string, user string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+prefix+path, nil)
+		req.Header.Set("Authorization", "Bearer test-token")
+		if user != "" {
+			req.Header.Set(httpHeaderUser, user)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var body map[string]interface{}
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		return res.StatusCode, body
+	}
+
+	if code, body := call("/private", ""); code != http.StatusUnauthorized || !strings.Contains(fmt.Sprint(body), "E_UNAUTHORIZED") {
+		t.Errorf("未登录访问MustLogin的函数: %d %v", code, body)
+	}
+	if code, body := call("/private", `{"id":"7","roles":["admin"]}`); code != http.StatusOK || !strings.Contains(fmt.Sprint(body), "admin:true") {
+		t.Errorf("登录后访问: %d %v", code, body)
+	}
+	if code, _ := call("/default", ""); code != http.StatusOK {
+		t.Errorf("默认不需要登录: %d", code)
+	}
+
+	t.Setenv("RUNNER_MUST_LOGIN", "true")
+	if code, _ := call("/default", ""); code != http.StatusUnauthorized {
+		t.Errorf("全局开启登录后未登录访问: %d", code)
+	}
+	if code, _ := call("/public", ""); code != http.StatusOK {
+		t.Errorf("公共api不需要登录: %d", code)
+	}
+}
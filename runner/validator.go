@@ -0,0 +1,299 @@
+package runner
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// 服务端的 validate 标签校验，规则与前端保持一致，防止直接通过NATS或者命令行调用绕过校验
+// 支持：required, required_if, required_unless, excluded_if, excluded_unless, omitempty,
+// min, max, len, gt, gte, lt, lte, oneof, email
+// 零值也会执行 min/max/len 等规则，可选字段需要加上 omitempty，例如 validate:"omitempty,min=1,max=365"
+// 空指针表示没有传，和 omitempty 一样跳过其余规则；不认识的规则直接校验失败，避免写错规则名被静默忽略
+// 条件规则引用的字段使用同级结构体的json名称（也兼容runner code和go字段名）
+
+var emailRegexp = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
+
+// validateRuleNames 支持的规则
+var validateRuleNames = map[string]bool{
+	"required": true, "required_if": true, "required_unless": true, "excluded_if": true, "excluded_unless": true,
+	"omitempty": true, "min": true, "max": true, "len": true, "gt": true, "gte": true, "lt": true, "lte": true,
+	"oneof": true, "email": true,
+}
+
+// validateRule 单条校验规则，例如 min=2
+type validateRule struct {
+	name  string
+	param string
+}
+
+// validateField 结构体字段的校验元数据
+type validateField struct {
+	index    int
+	code     string // 错误key，优先使用runner标签的code
+	jsonName string
+	rules    []validateRule
+}
+
+var validateFieldCache sync.Map // reflect.Type -> []*validateField
+
+func getValidateFields(t reflect.Type) []*validateField {
+	if v, ok := validateFieldCache.Load(t); ok {
+		return v.([]*validateField)
+	}
+	var fields []*validateField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		f := &validateField{index: i, jsonName: strings.Split(sf.Tag.Get("json"), ",")[0]}
+		f.code = runnerCode(sf.Tag.Get("runner"))
+		if f.code == "" {
+			f.code = f.jsonName
+		}
+		if f.code == "" || f.code == "-" {
+			f.code = sf.Name
+		}
+		for _, item := range splitValidateTag(sf.Tag.Get("validate")) {
+			name, param, _ := strings.Cut(item, "=")
+			f.rules = append(f.rules, validateRule{name: strings.TrimSpace(name), param: param})
+		}
+		fields = append(fields, f)
+	}
+	validateFieldCache.Store(t, fields)
+	return fields
+}
+
+// runnerCode 从 runner:"code:xxx;name:yyy" 中取出code
+func runnerCode(tag string) string {
+	for _, part := range strings.Split(tag, ";") {
+		if strings.HasPrefix(part, "code:") {
+			return strings.TrimPrefix(part, "code:")
+		}
+	}
+	return ""
+}
+
+func splitValidateTag(tag string) []string {
+	var items []string
+	for _, item := range strings.Split(tag, ",") {
+		if strings.TrimSpace(item) != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validateStruct 校验结构体（包括嵌套的结构体和结构体切片）上的 validate 标签，返回字段路径到错误信息的映射
+func validateStruct(v interface{}) map[string]string {
+	errs := make(map[string]string)
+	validateValue(reflect.ValueOf(v), "", errs)
+	return errs
+}
+
+func validateValue(v reflect.Value, prefix string, errs map[string]string) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		validateStructValue(v, prefix, errs)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", prefix, i), errs)
+		}
+	}
+}
+
+func validateStructValue(v reflect.Value, prefix string, errs map[string]string) {
+	for _, f := range getValidateFields(v.Type()) {
+		fv := v.Field(f.index)
+		path := f.code
+		if prefix != "" {
+			path = prefix + "." + f.code
+		}
+		if msg := checkRules(v, fv, f.rules); msg != "" {
+			errs[path] = msg
+			continue
+		}
+		if v.Type().Field(f.index).Anonymous {
+			validateValue(fv, prefix, errs)
+		} else {
+			validateValue(fv, path, errs)
+		}
+	}
+}
+
+// checkRules 按顺序执行字段上的规则，返回第一条失败规则的错误信息
+func checkRules(parent reflect.Value, fv reflect.Value, rules []validateRule) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	empty := isEmptyValue(fv)
+	required, omitempty := false, false
+	for _, rule := range rules {
+		if !validateRuleNames[rule.name] {
+			return "未知的校验规则: " + rule.name
+		}
+		switch rule.name {
+		case "omitempty":
+			omitempty = true
+		case "required":
+			required = true
+		case "required_if", "required_unless":
+			field, value, _ := strings.Cut(rule.param, " ")
+			equal := siblingString(parent, field) == value
+			if (rule.name == "required_if") == equal {
+				required = true
+			}
+		case "excluded_if", "excluded_unless":
+			field, value, _ := strings.Cut(rule.param, " ")
+			equal := siblingString(parent, field) == value
+			if (rule.name == "excluded_if") == equal {
+				if !empty {
+					return "必须为空"
+				}
+				return ""
+			}
+		}
+	}
+	if empty {
+		if required {
+			return "必填"
+		}
+		if omitempty || isNilValue(fv) {
+			return ""
+		}
+	}
+
+	for _, rule := range rules {
+		if msg := checkRule(fv, rule); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func checkRule(fv reflect.Value, rule validateRule) string {
+	for fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface {
+		fv = fv.Elem()
+	}
+	switch rule.name {
+	case "min", "gte":
+		if n, ok := measure(fv); ok && n < parseParam(rule.param) {
+			return sizeMessage(fv, "不能小于", rule.param)
+		}
+	case "max", "lte":
+		if n, ok := measure(fv); ok && n > parseParam(rule.param) {
+			return sizeMessage(fv, "不能大于", rule.param)
+		}
+	case "gt":
+		if n, ok := measure(fv); ok && n <= parseParam(rule.param) {
+			return sizeMessage(fv, "必须大于", rule.param)
+		}
+	case "lt":
+		if n, ok := measure(fv); ok && n >= parseParam(rule.param) {
+			return sizeMessage(fv, "必须小于", rule.param)
+		}
+	case "len":
+		if n, ok := measure(fv); ok && n != parseParam(rule.param) {
+			return sizeMessage(fv, "必须等于", rule.param)
+		}
+	case "oneof":
+		options := strings.Fields(rule.param)
+		s := fmt.Sprint(fv.Interface())
+		for _, option := range options {
+			if s == option {
+				return ""
+			}
+		}
+		return "必须是以下值之一: " + strings.Join(options, " ")
+	case "email":
+		if fv.Kind() == reflect.String && !emailRegexp.MatchString(fv.String()) {
+			return "邮箱格式不正确"
+		}
+	}
+	return ""
+}
+
+// measure 字符串取字符数，切片/map取长度，数字取值本身
+func measure(fv reflect.Value) (float64, bool) {
+	switch fv.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(fv.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(fv.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), true
+	}
+	return 0, false
+}
+
+func sizeMessage(fv reflect.Value, op string, param string) string {
+	switch fv.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("长度%s%s", op, param)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("数量%s%s", op, param)
+	}
+	return fmt.Sprintf("%s%s", op, param)
+}
+
+func parseParam(param string) float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(param), 64)
+	return f
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+func isNilValue(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// siblingString 按json名称（兼容runner code和go字段名）获取同级字段的值
+func siblingString(parent reflect.Value, name string) string {
+	for _, f := range getValidateFields(parent.Type()) {
+		if f.jsonName == name || f.code == name || parent.Type().Field(f.index).Name == name {
+			fv := parent.Field(f.index)
+			for fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Interface {
+				if fv.IsNil() {
+					return ""
+				}
+				fv = fv.Elem()
+			}
+			return fmt.Sprint(fv.Interface())
+		}
+	}
+	return ""
+}
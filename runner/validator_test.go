@@ -0,0 +1,84 @@
+package runner
+
+import "testing"
+
+type validateItem struct {
+	Name  string  `json:"name" runner:"code:name;name:名称" validate:"required,min=2"`
+	Price float64 `json:"price" runner:"code:price;name:价格" validate:"required,gt=0"`
+}
+
+type validateReq struct {
+	UserType string         `json:"user_type" runner:"code:user_type;name:用户类型" validate:"required,oneof=普通用户 管理员"`
+	Email    string         `json:"email" runner:"code:email;name:邮箱" validate:"omitempty,email"`
+	Level    int            `json:"level" runner:"code:level;name:权限等级" validate:"omitempty,required_if=user_type 管理员,min=1,max=10"`
+	Remark   string         `json:"remark" runner:"code:remark;name:备注" validate:"excluded_if=user_type 普通用户"`
+	Items    []validateItem `json:"items" runner:"code:items;name:明细" validate:"required,min=1"`
+	Address  *struct {
+		City string `json:"city" validate:"required"`
+	} `json:"address" runner:"code:address;name:地址"`
+}
+
+func TestValidateStruct(t *testing.T) {
+	req := &validateReq{
+		UserType: "管理员",
+		Email:    "bad-email",
+		Remark:   "ok",
+		Items:    []validateItem{{Name: "苹果", Price: 1}, {Name: "梨", Price: 0}},
+		Address: &struct {
+			City string `json:"city" validate:"required"`
+		}{},
+	}
+	got := validateStruct(req)
+	want := map[string]string{
+		"email":          "邮箱格式不正确",
+		"level":          "必填",
+		"items[1].name":  "长度不能小于2",
+		"items[1].price": "必填",
+		"address.city":   "必填",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("期望错误 %v，实际 %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("字段 %s 期望 %q，实际 %q", k, v, got[k])
+		}
+	}
+
+	req = &validateReq{UserType: "普通用户", Remark: "不应该填写", Items: []validateItem{{Name: "苹果", Price: 1}}}
+	got = validateStruct(req)
+	if len(got) != 1 || got["remark"] != "必须为空" {
+		t.Fatalf("普通用户只应该报备注必须为空，实际 %v", got)
+	}
+
+	req = &validateReq{UserType: "管理员", Level: 11, Items: []validateItem{{Name: "苹果", Price: 1}}}
+	if got = validateStruct(req); got["level"] != "不能大于10" {
+		t.Fatalf("期望权限等级超出范围，实际 %v", got)
+	}
+}
+
+func TestValidateZeroValueAndUnknownRule(t *testing.T) {
+	type quota struct {
+		Days  int     `json:"days" validate:"min=1,max=365"`
+		Code  string  `json:"code" validate:"len=4"`
+		Kind  string  `json:"kind" validate:"oneof=a b"`
+		Limit *int    `json:"limit" validate:"min=1"`
+		Note  string  `json:"note" validate:"omitempty,min=2"`
+		Typo  float64 `json:"typo" validate:"mni=1"`
+	}
+	got := validateStruct(&quota{Typo: 1})
+	want := map[string]string{
+		"days": "不能小于1",
+		"code": "长度必须等于4",
+		"kind": "必须是以下值之一: a b",
+		"typo": "未知的校验规则: mni",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("期望错误 %v，实际 %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("字段 %s 期望 %q，实际 %q", k, v, got[k])
+		}
+	}
+}